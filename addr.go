@@ -6,8 +6,6 @@ package netmap
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"net/netip"
 	"strings"
 	"time"
@@ -20,9 +18,16 @@ import (
 
 // UpsertAddress creates an IP address in the graph.
 func (g *Graph) UpsertAddress(ctx context.Context, addr string) (*types.Asset, error) {
+	return g.UpsertAddressAt(ctx, addr, time.Time{})
+}
+
+// UpsertAddressAt creates an IP address in the graph that was observed at the provided time.
+// The first seen / last seen range of the address is extended to include the observation, and
+// the address is linked to the most specific netblock in the graph that contains it.
+func (g *Graph) UpsertAddressAt(ctx context.Context, addr string, at time.Time) (*types.Asset, error) {
 	ip, err := netip.ParseAddr(addr)
 	if err != nil {
-		return nil, err
+		return nil, inputError(err)
 	}
 
 	var t string
@@ -31,13 +36,13 @@ func (g *Graph) UpsertAddress(ctx context.Context, addr string) (*types.Asset, e
 	} else if ip.Is6() {
 		t = "IPv6"
 	} else {
-		return nil, fmt.Errorf("%s is not a valid IPv4 or IPv6 IP address", addr)
+		return nil, invalidInput("%s is not a valid IPv4 or IPv6 IP address", addr)
 	}
 
-	return g.DB.Create(nil, "", &network.IPAddress{
+	return g.upsertAssetAt(ctx, &network.IPAddress{
 		Address: ip,
 		Type:    t,
-	})
+	}, at)
 }
 
 // NameAddrPair represents a relationship between a DNS name and an IP address it eventually resolves to.
@@ -48,6 +53,23 @@ type NameAddrPair struct {
 
 // NamesToAddrs returns a NameAddrPair for each name / address combination discovered in the graph.
 func (g *Graph) NamesToAddrs(ctx context.Context, since time.Time, names ...string) ([]*NameAddrPair, error) {
+	return g.namesToAddrs(ctx, TimeRange{Since: since}, nil, names...)
+}
+
+// NamesToAddrsInRange returns a NameAddrPair for each name / address combination discovered in the graph
+// using only the assets and relations seen during the time range.
+func (g *Graph) NamesToAddrsInRange(ctx context.Context, tr TimeRange, names ...string) ([]*NameAddrPair, error) {
+	return g.namesToAddrs(ctx, tr, nil, names...)
+}
+
+func (g *Graph) namesToAddrs(ctx context.Context, tr TimeRange, sources []string, names ...string) ([]*NameAddrPair, error) {
+	if err := tr.Validate(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, invalidInput("no names to query")
+	}
+
 	nameAddrMap := make(map[string]*stringset.Set, len(names))
 	defer func() {
 		for _, ss := range nameAddrMap {
@@ -59,19 +81,16 @@ func (g *Graph) NamesToAddrs(ctx context.Context, since time.Time, names ...stri
 	defer remaining.Close()
 	remaining.InsertMany(names...)
 
-	from := "(relations inner join assets on relations.from_asset_id = assets.id)"
+	from := "((relations inner join assets on relations.from_asset_id = assets.id)"
+	from += " inner join assets as ips on relations.to_asset_id = ips.id) "
 	where := "where assets.type = 'FQDN' and relations.type in ('a_record','aaaa_record') "
-	likeset := "and assets.content->>'name' in ('" + strings.Join(remaining.Slice(), "','") + "')"
-	query := from + where + likeset
-	if !since.IsZero() {
-		query += " and relations.last_seen > " + since.Format("2006-01-02 15:04:05")
-	}
+	likeset := "and assets.content->>'name' in (" + sqlStringList(remaining.Slice()) + ")"
+	query := from + where + likeset + sourceFilter("relations.id", sources) +
+		tr.constraint("relations") + tr.constraint("assets") + tr.constraint("ips")
 
-	rels, err := g.DB.RelationQuery(query)
+	rels, err := g.relationQuery(ctx, query)
 	if err != nil {
 		return nil, err
-	} else if len(rels) == 0 {
-		return nil, errors.New("no names to query")
 	}
 
 	for _, rel := range rels {
@@ -89,12 +108,18 @@ func (g *Graph) NamesToAddrs(ctx context.Context, since time.Time, names ...stri
 
 	// Get to the end of the CNAME alias chains
 	for _, name := range remaining.Slice() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		var results []struct {
 			Name string
 			Addr string
 		}
 
-		if err := g.DB.RawQuery(cnameQuery(name, since), &results); err == nil && len(results) > 0 {
+		if err := g.rawQuery(ctx, cnameQuery(name, tr, sources), &results); err != nil {
+			return nil, err
+		} else if len(results) > 0 {
 			remaining.Remove(name)
 
 			for _, res := range results {
@@ -106,25 +131,25 @@ func (g *Graph) NamesToAddrs(ctx context.Context, since time.Time, names ...stri
 		}
 	}
 
-	query = `SELECT fqdns.content->>'name',ips.content->>'name' FROM ((((
+	query = `SELECT fqdns.content->>'name' AS name, ips.content->>'address' AS addr FROM ((((
 		assets AS fqdns INNER JOIN relations AS r1 ON fqdns.id = r1.from_asset_id) 
 		INNER JOIN assets AS srvs ON r1.to_asset_id = srvs.id) 
 		INNER JOIN relations AS r2 ON srvs.id = r2.from_asset_id) 
 		INNER JOIN assets AS ips ON r2.to_asset_id = ips.id)
 		WHERE fqdns.type = 'FQDN' AND srvs.type = 'FQDN' AND ips.type = 'IPAddress' 
 		AND r1.type = 'srv_record' AND r2.type IN ('a_record', 'aaaa_record')`
-	if !since.IsZero() {
-		query += " AND r1.last_seen > " + since.Format("2006-01-02 15:04:05") +
-			" AND r2.last_seen > " + since.Format("2006-01-02 15:04:05")
-	}
-	query += " AND fqdns.content->>'name' in ('" + strings.Join(remaining.Slice(), "','") + "')"
+	query += tr.constraint("r1") + tr.constraint("r2") + tr.constraint("fqdns") + tr.constraint("srvs") + tr.constraint("ips")
+	query += sourceFilter("r1.id", sources) + sourceFilter("r2.id", sources)
+	query += " AND fqdns.content->>'name' in (" + sqlStringList(remaining.Slice()) + ")"
 
 	var results []struct {
 		Name string
 		Addr string
 	}
 	// Get to the IPs associated with SRV records
-	if err := g.DB.RawQuery(query, &results); err == nil && len(results) > 0 {
+	if err := g.rawQuery(ctx, query, &results); err != nil {
+		return nil, err
+	} else if len(results) > 0 {
 		for _, res := range results {
 			remaining.Remove(res.Name)
 			if _, found := nameAddrMap[res.Name]; !found {
@@ -135,38 +160,51 @@ func (g *Graph) NamesToAddrs(ctx context.Context, since time.Time, names ...stri
 	}
 
 	if len(nameAddrMap) == 0 {
-		return nil, errors.New("no pairs to process")
+		return nil, notFound("no names were resolved")
 	}
 
 	pairs := generatePairsFromAddrMap(nameAddrMap)
 	if len(pairs) == 0 {
-		return nil, errors.New("no addresses were discovered")
+		return nil, notFound("no addresses were discovered")
 	}
 	return pairs, nil
 }
 
-func cnameQuery(name string, since time.Time) string {
+// cnameQuery returns the SQL that follows the CNAME alias chain starting at the name and returns the addresses
+// at the end of the chain. The time range and sources are applied to every hop of the recursion.
+func cnameQuery(name string, tr TimeRange, sources []string) string {
 	query := `WITH RECURSIVE
 	traverse_cname(fqdn) AS (
-	VALUES('` + name + `')
+	VALUES('` + sqlEscape(name) + `')
 	UNION
 	SELECT cnames.content->>'name' FROM ((assets AS fqdns
 	INNER JOIN relations ON fqdns.id = relations.from_asset_id) 
 	INNER JOIN assets AS cnames ON relations.to_asset_id = cnames.id), traverse_cname
 	WHERE fqdns.type = 'FQDN' AND cnames.type = 'FQDN'`
-	if !since.IsZero() {
-		query += " and relations.last_seen > " + since.Format("2006-01-02 15:04:05")
-	}
+	query += tr.constraint("relations") + tr.constraint("fqdns") + tr.constraint("cnames")
+	query += sourceFilter("relations.id", sources)
 	query += ` AND relations.type = 'cname_record' AND fqdns.content->>'name' = traverse_cname.fqdn
 	)
-	SELECT fqdns.content->>'name', ips.content->>'address' FROM ((assets AS fqdns
+	SELECT fqdns.content->>'name' AS name, ips.content->>'address' AS addr FROM ((assets AS fqdns
 	INNER JOIN relations ON fqdns.id = relations.from_asset_id) 
 	INNER JOIN assets AS ips ON relations.to_asset_id = ips.id)
 	WHERE fqdns.type = 'FQDN' AND ips.type = 'IPAddress'`
-	if !since.IsZero() {
-		query += " and relations.last_seen > " + since.Format("2006-01-02 15:04:05")
+	query += tr.constraint("relations") + tr.constraint("fqdns") + tr.constraint("ips")
+	query += sourceFilter("relations.id", sources)
+	return query + ` AND relations.type IN ('a_record', 'aaaa_record')
+	AND fqdns.content->>'name' IN (SELECT fqdn FROM traverse_cname)`
+}
+
+// sqlStringList returns the values quoted and separated by commas for use within an IN clause.
+func sqlStringList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, "'"+sqlEscape(v)+"'")
+	}
+	if len(quoted) == 0 {
+		return "''"
 	}
-	return query + ` AND relations.type IN ('a_record', 'aaaa_record') AND fqdns.content->>'name' IN traverse_cname`
+	return strings.Join(quoted, ",")
 }
 
 func generatePairsFromAddrMap(addrMap map[string]*stringset.Set) []*NameAddrPair {
@@ -191,27 +229,36 @@ func generatePairsFromAddrMap(addrMap map[string]*stringset.Set) []*NameAddrPair
 	return pairs
 }
 
-// UpsertA creates FQDN, IP address and A record edge in the graph and associates them with a source and event.
+// UpsertA creates FQDN, IP address and A record edge in the graph and associates them with the source and event set using WithProvenance.
 func (g *Graph) UpsertA(ctx context.Context, fqdn, addr string) error {
-	return g.addrRecord(ctx, fqdn, addr, "a_record")
+	return g.addrRecord(ctx, fqdn, addr, "a_record", time.Time{})
+}
+
+// UpsertAAt creates FQDN, IP address and A record edge in the graph that were observed at the provided time.
+func (g *Graph) UpsertAAt(ctx context.Context, fqdn, addr string, at time.Time) error {
+	return g.addrRecord(ctx, fqdn, addr, "a_record", at)
 }
 
-// UpsertAAAA creates FQDN, IP address and AAAA record edge in the graph and associates them with a source and event.
+// UpsertAAAA creates FQDN, IP address and AAAA record edge in the graph and associates them with the source and event set using WithProvenance.
 func (g *Graph) UpsertAAAA(ctx context.Context, fqdn, addr string) error {
-	return g.addrRecord(ctx, fqdn, addr, "aaaa_record")
+	return g.addrRecord(ctx, fqdn, addr, "aaaa_record", time.Time{})
+}
+
+// UpsertAAAAAt creates FQDN, IP address and AAAA record edge in the graph that were observed at the provided time.
+func (g *Graph) UpsertAAAAAt(ctx context.Context, fqdn, addr string, at time.Time) error {
+	return g.addrRecord(ctx, fqdn, addr, "aaaa_record", at)
 }
 
-func (g *Graph) addrRecord(ctx context.Context, fqdn, addr, rrtype string) error {
-	name, err := g.UpsertFQDN(ctx, fqdn)
+func (g *Graph) addrRecord(ctx context.Context, fqdn, addr, rrtype string, at time.Time) error {
+	name, err := g.UpsertFQDNAt(ctx, fqdn, at)
 	if err != nil {
 		return err
 	}
 
-	ip, err := g.UpsertAddress(ctx, addr)
+	ip, err := g.UpsertAddressAt(ctx, addr, at)
 	if err != nil {
 		return err
 	}
 
-	_, err = g.DB.Create(name, rrtype, ip.Asset)
-	return err
+	return g.linkAt(ctx, name, rrtype, ip, at)
 }
@@ -14,78 +14,126 @@ import (
 
 // UpsertAS adds/updates an autonomous system in the graph.
 func (g *Graph) UpsertAS(ctx context.Context, asn int, desc string) (*types.Asset, error) {
+	return g.UpsertASAt(ctx, asn, desc, time.Time{})
+}
 
-	a, err := g.DB.Create(nil, "", &network.AutonomousSystem{Number: asn})
+// UpsertASAt adds/updates an autonomous system in the graph that was observed at the provided time.
+func (g *Graph) UpsertASAt(ctx context.Context, asn int, desc string, at time.Time) (*types.Asset, error) {
+	a, err := g.upsertAssetAt(ctx, &network.AutonomousSystem{Number: asn}, at)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = g.DB.Create(a, "managed_by", &network.RIROrganization{Name: desc})
-	return a, err
+	rir, err := g.upsertAssetAt(ctx, &network.RIROrganization{Name: desc}, at)
+	if err != nil {
+		return a, err
+	}
+	return a, g.linkAt(ctx, a, "managed_by", rir, at)
 }
 
 // UpsertInfrastructure adds/updates an associated IP address, netblock and autonomous system in the graph.
 func (g *Graph) UpsertInfrastructure(ctx context.Context, asn int, desc, addr, cidr string) error {
-	ip, err := g.UpsertAddress(ctx, addr)
-	if err != nil {
+	return g.UpsertInfrastructureAt(ctx, asn, desc, addr, cidr, time.Time{})
+}
+
+// UpsertInfrastructureAt adds/updates an associated IP address, netblock and autonomous system in the graph
+// that were observed at the provided time.
+func (g *Graph) UpsertInfrastructureAt(ctx context.Context, asn int, desc, addr, cidr string, at time.Time) error {
+	// The address is contained by the netblock, or a more specific one, as they are upserted. The origin of
+	// an address under a more specific netblock is found by following its supernets to the announced netblock
+	if _, err := g.UpsertAddressAt(ctx, addr, at); err != nil {
 		return err
 	}
 
-	netblock, err := g.UpsertNetblock(ctx, cidr)
+	netblock, err := g.UpsertNetblockAt(ctx, cidr, at)
 	if err != nil {
 		return err
 	}
-	// Create the edge between the CIDR and the address
-	if _, err := g.DB.Create(netblock, "contains", ip.Asset); err != nil {
-		return err
-	}
 
-	as, err := g.UpsertAS(ctx, asn, desc)
+	as, err := g.UpsertASAt(ctx, asn, desc, at)
 	if err != nil {
 		return err
 	}
 	// Create the edge between the AS and the netblock
-	if _, err := g.DB.Create(as, "announces", netblock.Asset); err != nil {
-		return err
-	}
-	return nil
+	return g.linkAt(ctx, as, "announces", netblock, at)
 }
 
 // ReadASDescription the description property of an autonomous system in the graph.
-func (g *Graph) ReadASDescription(ctx context.Context, asn int, since time.Time) string {
-	assets, err := g.DB.FindByContent(&network.AutonomousSystem{Number: asn}, since)
-	if err != nil || len(assets) == 0 {
-		return ""
+func (g *Graph) ReadASDescription(ctx context.Context, asn int, since time.Time) (string, error) {
+	return g.ReadASDescriptionInRange(ctx, asn, TimeRange{Since: since})
+}
+
+// ReadASDescriptionInRange returns the description property of an autonomous system in the graph,
+// using the most recent description seen during the time range. Organizations that are only identified
+// by their RIR ID do not provide a description.
+func (g *Graph) ReadASDescriptionInRange(ctx context.Context, asn int, tr TimeRange) (string, error) {
+	as, err := g.findAS(ctx, asn, tr)
+	if err != nil {
+		return "", err
 	}
 
-	if rels, err := g.DB.OutgoingRelations(assets[0], since, "managed_by"); err == nil && len(rels) > 0 {
-		a, err := g.DB.FindById(rels[0].ToAsset.ID, since)
-		if err != nil {
-			return ""
-		} else if rir, ok := a.Asset.(*network.RIROrganization); ok {
-			return rir.Name
+	rels, err := g.relationQuery(ctx, "relations INNER JOIN assets ON relations.to_asset_id = assets.id"+
+		" WHERE relations.from_asset_id = "+as.ID+" AND relations.type = 'managed_by' AND assets.content->>'name' <> ''"+
+		tr.constraint("relations")+tr.constraint("assets")+" ORDER BY relations.last_seen DESC LIMIT 1")
+	if err != nil {
+		return "", err
+	}
+
+	if len(rels) > 0 {
+		if rir, ok := rels[0].ToAsset.Asset.(*network.RIROrganization); ok {
+			return rir.Name, nil
 		}
 	}
+	return "", notFound("the description of AS%d", asn)
+}
 
-	return ""
+// ReadASPrefixes returns the netblocks announced by the autonomous system in the graph.
+func (g *Graph) ReadASPrefixes(ctx context.Context, asn int, since time.Time) ([]string, error) {
+	return g.ReadASPrefixesInRange(ctx, asn, TimeRange{Since: since})
 }
 
-func (g *Graph) ReadASPrefixes(ctx context.Context, asn int, since time.Time) []string {
+// ReadASPrefixesInRange returns the netblocks announced by the autonomous system during the time range.
+// No error is returned when the autonomous system is in the graph without announcing netblocks.
+func (g *Graph) ReadASPrefixesInRange(ctx context.Context, asn int, tr TimeRange) ([]string, error) {
+	as, err := g.findAS(ctx, asn, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	rels, err := g.relationQuery(ctx, "relations INNER JOIN assets ON relations.to_asset_id = assets.id"+
+		" WHERE relations.from_asset_id = "+as.ID+" AND relations.type = 'announces'"+
+		tr.constraint("relations")+tr.constraint("assets"))
+	if err != nil {
+		return nil, err
+	}
+
 	var prefixes []string
+	for _, rel := range rels {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if netblock, ok := rel.ToAsset.Asset.(*network.Netblock); ok {
+			prefixes = append(prefixes, netblock.Cidr.String())
+		}
+	}
+	return prefixes, nil
+}
+
+// findAS returns the autonomous system asset when it was seen during the time range.
+func (g *Graph) findAS(ctx context.Context, asn int, tr TimeRange) (*types.Asset, error) {
+	if err := tr.Validate(); err != nil {
+		return nil, err
+	}
 
-	assets, err := g.DB.FindByContent(&network.AutonomousSystem{Number: asn}, since)
-	if err != nil || len(assets) == 0 {
-		return prefixes
+	assets, err := g.findByContent(ctx, &network.AutonomousSystem{Number: asn}, tr.Since)
+	if err != nil {
+		return nil, err
 	}
 
-	if rels, err := g.DB.OutgoingRelations(assets[0], since, "announces"); err == nil && len(rels) > 0 {
-		for _, rel := range rels {
-			if a, err := g.DB.FindById(rel.ToAsset.ID, since); err != nil {
-				continue
-			} else if netblock, ok := a.Asset.(*network.Netblock); ok {
-				prefixes = append(prefixes, netblock.Cidr.String())
-			}
+	for _, a := range assets {
+		if tr.includesAsset(a) {
+			return a, nil
 		}
 	}
-	return prefixes
+	return nil, notFound("AS%d", asn)
 }
@@ -42,18 +42,18 @@ func TestAS(t *testing.T) {
 	})
 
 	t.Run("Testing ReadASDescription", func(t *testing.T) {
-		got := g.ReadASDescription(context.Background(), asn, time.Time{})
+		got, err := g.ReadASDescription(context.Background(), asn, time.Time{})
 
-		if got != newdesc {
-			t.Errorf("expected: %v, got: %v", newdesc, got)
+		if err != nil || got != newdesc {
+			t.Errorf("expected: %v, got: %v: %v", newdesc, got, err)
 		}
 	})
 
 	t.Run("Testing ReadASPrefixes", func(t *testing.T) {
-		got := g.ReadASPrefixes(context.Background(), asn, time.Time{})
+		got, err := g.ReadASPrefixes(context.Background(), asn, time.Time{})
 
-		if len(got) != 1 || got[0] != cidr {
-			t.Errorf("expected: %v, got: %v\n", cidr, got)
+		if err != nil || len(got) != 1 || got[0] != cidr {
+			t.Errorf("expected: %v, got: %v: %v\n", cidr, got, err)
 		}
 	})
 }
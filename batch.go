@@ -0,0 +1,443 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+	"golang.org/x/net/publicsuffix"
+	"gorm.io/gorm"
+)
+
+// Batch collects the DNS record upserts that Graph.Batch writes to the graph within a single transaction.
+// Records added to the batch more than once are written once, with the range of their observation times.
+type Batch struct {
+	count int
+	items []*batchItem
+	index map[string]*batchItem
+}
+
+type batchItem struct {
+	index    int
+	name     string
+	relation string
+	target   string
+	first    time.Time
+	last     time.Time
+}
+
+// BatchItemError is the error for a record in the batch that could not be written to the graph.
+type BatchItemError struct {
+	// Index is the position of the record among the upserts made on the batch.
+	Index    int
+	Name     string
+	Relation string
+	Target   string
+	Err      error
+}
+
+func (e *BatchItemError) Error() string {
+	if e.Relation == "" {
+		return fmt.Sprintf("record %d (%s): %v", e.Index, e.Name, e.Err)
+	}
+	return fmt.Sprintf("record %d (%s -%s-> %s): %v", e.Index, e.Name, e.Relation, e.Target, e.Err)
+}
+
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by Graph.Batch when some of the records could not be written.
+// The remaining records in the batch were committed.
+type BatchError struct {
+	Items []*BatchItemError
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, 0, len(e.Items))
+	for _, item := range e.Items {
+		msgs = append(msgs, item.Error())
+	}
+	return fmt.Sprintf("%d records in the batch failed: %s", len(e.Items), strings.Join(msgs, "; "))
+}
+
+// Batch calls fn to collect record upserts, then writes them to the graph within a single transaction.
+// Each record is written within its own savepoint, so a record that fails is reported in the returned
+// *BatchError without affecting the others. Nothing is written when fn returns an error or the context
+// is cancelled. The upserts are attributed to the provenance carried by the context.
+func (g *Graph) Batch(ctx context.Context, fn func(b *Batch) error) error {
+	b := &Batch{index: make(map[string]*batchItem)}
+	if err := fn(b); err != nil {
+		return err
+	}
+	if len(b.items) == 0 {
+		return nil
+	}
+
+	var failed []*BatchItemError
+	err := g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		w := &batchWriter{
+			prov:       provenanceFrom(ctx),
+			cache:      make(map[string]*batchRow),
+			containers: make(map[netip.Addr]uint64),
+		}
+
+		for _, item := range b.items {
+			w.undo = make(map[string]*batchRow)
+
+			if err := tx.Transaction(func(sp *gorm.DB) error {
+				return w.write(sp, item)
+			}); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				w.rollback()
+				failed = append(failed, &BatchItemError{
+					Index:    item.index,
+					Name:     item.name,
+					Relation: item.relation,
+					Target:   item.target,
+					Err:      err,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return &BatchError{Items: failed}
+	}
+	return nil
+}
+
+// UpsertFQDN adds the fully qualified domain name to the batch.
+func (b *Batch) UpsertFQDN(name string) {
+	b.UpsertFQDNAt(name, time.Time{})
+}
+
+// UpsertFQDNAt adds the fully qualified domain name, observed at the provided time, to the batch.
+func (b *Batch) UpsertFQDNAt(name string, at time.Time) {
+	b.add(name, "", "", at)
+}
+
+// UpsertA adds the FQDN, IP address and A record edge to the batch.
+func (b *Batch) UpsertA(fqdn, addr string) {
+	b.UpsertAAt(fqdn, addr, time.Time{})
+}
+
+// UpsertAAt adds the FQDN, IP address and A record edge, observed at the provided time, to the batch.
+func (b *Batch) UpsertAAt(fqdn, addr string, at time.Time) {
+	b.add(fqdn, "a_record", addr, at)
+}
+
+// UpsertAAAA adds the FQDN, IP address and AAAA record edge to the batch.
+func (b *Batch) UpsertAAAA(fqdn, addr string) {
+	b.UpsertAAAAAt(fqdn, addr, time.Time{})
+}
+
+// UpsertAAAAAt adds the FQDN, IP address and AAAA record edge, observed at the provided time, to the batch.
+func (b *Batch) UpsertAAAAAt(fqdn, addr string, at time.Time) {
+	b.add(fqdn, "aaaa_record", addr, at)
+}
+
+// UpsertCNAME adds the FQDNs and CNAME record edge to the batch.
+func (b *Batch) UpsertCNAME(fqdn, target string) {
+	b.UpsertCNAMEAt(fqdn, target, time.Time{})
+}
+
+// UpsertCNAMEAt adds the FQDNs and CNAME record edge, observed at the provided time, to the batch.
+func (b *Batch) UpsertCNAMEAt(fqdn, target string, at time.Time) {
+	b.add(fqdn, "cname_record", target, at)
+}
+
+// UpsertPTR adds the FQDNs and PTR record edge to the batch.
+func (b *Batch) UpsertPTR(fqdn, target string) {
+	b.UpsertPTRAt(fqdn, target, time.Time{})
+}
+
+// UpsertPTRAt adds the FQDNs and PTR record edge, observed at the provided time, to the batch.
+func (b *Batch) UpsertPTRAt(fqdn, target string, at time.Time) {
+	b.add(fqdn, "ptr_record", target, at)
+}
+
+// UpsertSRV adds the FQDNs and SRV record edge to the batch.
+func (b *Batch) UpsertSRV(service, target string) {
+	b.UpsertSRVAt(service, target, time.Time{})
+}
+
+// UpsertSRVAt adds the FQDNs and SRV record edge, observed at the provided time, to the batch.
+func (b *Batch) UpsertSRVAt(service, target string, at time.Time) {
+	b.add(service, "srv_record", target, at)
+}
+
+// UpsertNS adds the FQDNs and NS record edge to the batch.
+func (b *Batch) UpsertNS(fqdn, target string) {
+	b.UpsertNSAt(fqdn, target, time.Time{})
+}
+
+// UpsertNSAt adds the FQDNs and NS record edge, observed at the provided time, to the batch.
+func (b *Batch) UpsertNSAt(fqdn, target string, at time.Time) {
+	b.add(fqdn, "ns_record", target, at)
+}
+
+// UpsertMX adds the FQDNs and MX record edge to the batch.
+func (b *Batch) UpsertMX(fqdn, target string) {
+	b.UpsertMXAt(fqdn, target, time.Time{})
+}
+
+// UpsertMXAt adds the FQDNs and MX record edge, observed at the provided time, to the batch.
+func (b *Batch) UpsertMXAt(fqdn, target string, at time.Time) {
+	b.add(fqdn, "mx_record", target, at)
+}
+
+// Len returns the number of distinct records in the batch.
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+func (b *Batch) add(name, relation, target string, at time.Time) {
+	idx := b.count
+	b.count++
+
+	if at.IsZero() {
+		at = time.Now()
+	}
+	at = at.UTC().Truncate(time.Second)
+
+	key := strings.Join([]string{name, relation, target}, "|")
+	if item, found := b.index[key]; found {
+		item.first, item.last = extendRange(item.first, item.last, at)
+		return
+	}
+
+	item := &batchItem{
+		index:    idx,
+		name:     name,
+		relation: relation,
+		target:   target,
+		first:    at,
+		last:     at,
+	}
+	b.items = append(b.items, item)
+	b.index[key] = item
+}
+
+// batchRow caches an asset or relation written within the batch transaction, so it is not read again.
+type batchRow struct {
+	id    uint64
+	first time.Time
+	last  time.Time
+	// seenFirst and seenLast are the range of the observations made within the batch.
+	seenFirst time.Time
+	seenLast  time.Time
+}
+
+type batchWriter struct {
+	prov  *Provenance
+	cache map[string]*batchRow
+	// undo holds the cache entries changed by the record being written, restored when the record fails.
+	undo map[string]*batchRow
+	// containers holds the most specific netblock containing each address, or zero when there is none.
+	containers map[netip.Addr]uint64
+}
+
+func (w *batchWriter) write(tx *gorm.DB, item *batchItem) error {
+	from, err := w.fqdn(tx, item.name, item)
+	if err != nil || item.relation == "" {
+		return err
+	}
+
+	var to uint64
+	var totype oam.AssetType
+	if item.relation == "a_record" || item.relation == "aaaa_record" {
+		ip, err := netip.ParseAddr(item.target)
+		if err != nil {
+			return invalidInput("%s is not a valid IPv4 or IPv6 IP address", item.target)
+		}
+
+		totype = oam.IPAddress
+		to, err = w.asset(tx, &network.IPAddress{Address: ip, Type: addrType(ip)}, "content->>'address'", ip.String(), item)
+		if err != nil {
+			return err
+		}
+		if err := w.contain(tx, ip, to, item); err != nil {
+			return err
+		}
+	} else {
+		totype = oam.FQDN
+		if to, err = w.fqdn(tx, item.target, item); err != nil {
+			return err
+		}
+	}
+
+	if !oam.ValidRelationship(oam.FQDN, item.relation, totype) {
+		return invalidInput("%s -%s-> %s is not valid in the taxonomy", oam.FQDN, item.relation, totype)
+	}
+	return w.relation(tx, from, item.relation, to, item)
+}
+
+// fqdn upserts the apex domain name and then the name, as Graph.UpsertFQDN does.
+func (w *batchWriter) fqdn(tx *gorm.DB, name string, item *batchItem) (uint64, error) {
+	apex, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return 0, inputError(err)
+	}
+	if apex != name {
+		if _, err := w.asset(tx, &domain.FQDN{Name: apex}, "content->>'name'", apex, item); err != nil {
+			return 0, err
+		}
+	}
+	return w.asset(tx, &domain.FQDN{Name: name}, "content->>'name'", name, item)
+}
+
+// asset upserts the asset, which is identified by the value of the SQL expression evaluated against its content.
+func (w *batchWriter) asset(tx *gorm.DB, asset oam.Asset, expr, value string, item *batchItem) (uint64, error) {
+	key := string(asset.AssetType()) + "|" + value
+
+	return w.upsert(tx, "assets", key, item, func() (*batchRow, error) {
+		var rows []relationRow
+		if err := tx.Raw("SELECT id, created_at, last_seen FROM assets WHERE type = ? AND "+expr+
+			" = ? ORDER BY id LIMIT 1", string(asset.AssetType()), value).Scan(&rows).Error; err != nil {
+			return nil, err
+		} else if len(rows) > 0 {
+			return &batchRow{id: rows[0].ID, first: rows[0].CreatedAt, last: rows[0].LastSeen}, nil
+		}
+
+		content, err := asset.JSON()
+		if err != nil {
+			return nil, err
+		}
+
+		var id uint64
+		ts := item.first.Format(sqlTimeFormat)
+		if err := tx.Raw(`INSERT INTO assets (type, content, created_at, last_seen) VALUES (?, ?, ?, ?)
+			RETURNING id`, string(asset.AssetType()), string(content), ts, ts).Scan(&id).Error; err != nil {
+			return nil, err
+		}
+		return &batchRow{id: id, first: item.first, last: item.first}, nil
+	})
+}
+
+// contain links the address to the most specific netblock that contains it, as Graph.UpsertAddress does.
+func (w *batchWriter) contain(tx *gorm.DB, ip netip.Addr, id uint64, item *batchItem) error {
+	parent, found := w.containers[ip]
+	if !found {
+		nb, ok, err := containingNetblock(tx, netip.PrefixFrom(ip, ip.BitLen()), true)
+		if err != nil {
+			return err
+		} else if ok {
+			if parent, err = strconv.ParseUint(nb.ID, 10, 64); err != nil {
+				return err
+			}
+		}
+		w.containers[ip] = parent
+	}
+
+	if parent == 0 {
+		return nil
+	}
+	return w.relation(tx, parent, "contains", id, item)
+}
+
+func (w *batchWriter) relation(tx *gorm.DB, from uint64, relation string, to uint64, item *batchItem) error {
+	key := fmt.Sprintf("%d|%s|%d", from, relation, to)
+
+	_, err := w.upsert(tx, "relations", key, item, func() (*batchRow, error) {
+		var rows []relationRow
+		if err := tx.Raw(`SELECT id, created_at, last_seen FROM relations WHERE from_asset_id = ?
+			AND to_asset_id = ? AND type = ? ORDER BY id LIMIT 1`, from, to, relation).Scan(&rows).Error; err != nil {
+			return nil, err
+		} else if len(rows) > 0 {
+			return &batchRow{id: rows[0].ID, first: rows[0].CreatedAt, last: rows[0].LastSeen}, nil
+		}
+
+		var id uint64
+		ts := item.first.Format(sqlTimeFormat)
+		if err := tx.Raw(`INSERT INTO relations (type, from_asset_id, to_asset_id, created_at, last_seen)
+			VALUES (?, ?, ?, ?, ?) RETURNING id`, relation, from, to, ts, ts).Scan(&id).Error; err != nil {
+			return nil, err
+		}
+		return &batchRow{id: id, first: item.first, last: item.first}, nil
+	})
+	return err
+}
+
+// upsert extends the first seen / last seen range of the cached row, or the row returned by load, to include the
+// observation times of the item. The observations are attributed to the provenance when they widen the range
+// already attributed within the batch.
+func (w *batchWriter) upsert(tx *gorm.DB, table, key string, item *batchItem,
+	load func() (*batchRow, error)) (uint64, error) {
+	row, cached := w.cache[key]
+	if !cached {
+		var err error
+		if row, err = load(); err != nil {
+			return 0, err
+		}
+	}
+	if _, saved := w.undo[key]; !saved {
+		if cached {
+			prev := *row
+			w.undo[key] = &prev
+		} else {
+			w.undo[key] = nil
+		}
+	}
+
+	first, last := extendRange(row.first, row.last, item.first)
+	first, last = extendRange(first, last, item.last)
+	if !first.Equal(row.first.UTC()) || !last.Equal(row.last.UTC()) {
+		if err := tx.Exec("UPDATE "+table+" SET created_at = ?, last_seen = ? WHERE id = ?",
+			first.Format(sqlTimeFormat), last.Format(sqlTimeFormat), row.id).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	updated := &batchRow{id: row.id, first: first, last: last, seenFirst: row.seenFirst, seenLast: row.seenLast}
+	for _, at := range []time.Time{item.first, item.last} {
+		if !updated.seenFirst.IsZero() && !at.Before(updated.seenFirst) && !at.After(updated.seenLast) {
+			continue
+		}
+
+		updated.seenFirst, updated.seenLast = extendRange(updated.seenFirst, updated.seenLast, at)
+		if w.prov != nil {
+			if err := w.attribute(tx, table, row.id, at); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	w.cache[key] = updated
+	return row.id, nil
+}
+
+func (w *batchWriter) attribute(tx *gorm.DB, table string, id uint64, at time.Time) error {
+	if table == "relations" {
+		return attribute(tx, "relation_sources", "relation_id", id, w.prov, at)
+	}
+	return attribute(tx, "asset_sources", "asset_id", id, w.prov, at)
+}
+
+// rollback restores the cache entries changed by the record that failed, since its savepoint was rolled back.
+func (w *batchWriter) rollback() {
+	for key, prev := range w.undo {
+		if prev == nil {
+			delete(w.cache, key)
+		} else {
+			w.cache[key] = prev
+		}
+	}
+}
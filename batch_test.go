@@ -0,0 +1,142 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func TestBatch(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := WithProvenance(context.Background(), "resolver", "")
+	first := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	last := first.Add(48 * time.Hour)
+
+	err := g.Batch(ctx, func(b *Batch) error {
+		b.UpsertAAt("www.owasp.org", "192.0.2.1", last)
+		b.UpsertAAt("www.owasp.org", "192.0.2.1", first)
+		b.UpsertAAt("www.owasp.org", "192.0.2.1", first)
+		b.UpsertCNAMEAt("docs.owasp.org", "www.owasp.org", first)
+		b.UpsertA("bad.owasp.org", "not-an-address")
+		b.UpsertNS("owasp.org", "ns1.owasp.org")
+
+		if b.Len() != 4 {
+			t.Errorf("expected 4 distinct records in the batch, got %d", b.Len())
+		}
+		return nil
+	})
+
+	var berr *BatchError
+	if !errors.As(err, &berr) || len(berr.Items) != 1 || berr.Items[0].Index != 4 || berr.Items[0].Name != "bad.owasp.org" {
+		t.Fatalf("expected the invalid address to be reported: %v", err)
+	}
+	// The failed record was rolled back, while the apex it shares with the others remains
+	if _, err := g.findAsset(context.Background(), &domain.FQDN{Name: "bad.owasp.org"}); err == nil {
+		t.Error("the name from the failed record was committed")
+	}
+
+	pairs, err := g.NamesToAddrsInRange(ctx, TimeRange{}, "docs.owasp.org")
+	if err != nil || len(pairs) != 1 || pairs[0].Addr.Address.String() != "192.0.2.1" {
+		t.Fatalf("failed to resolve the alias written by the batch: %v", err)
+	}
+	if !g.IsNSNode(ctx, "ns1.owasp.org", time.Time{}) {
+		t.Error("the NS record was not written")
+	}
+
+	rels, err := g.DB.RelationQuery("relations WHERE relations.type = 'a_record'")
+	if err != nil || len(rels) != 1 {
+		t.Fatalf("expected the duplicate records to be written once: %v", err)
+	}
+	if !rels[0].CreatedAt.UTC().Equal(first) || !rels[0].LastSeen.UTC().Equal(last) {
+		t.Errorf("unexpected range for the relation: %v - %v", rels[0].CreatedAt, rels[0].LastSeen)
+	}
+
+	stats, err := g.ReadSourceStats(ctx)
+	if err != nil || len(stats) != 1 || stats[0].Source != "resolver" || stats[0].Relations != 3 {
+		t.Errorf("the batch was not attributed to the provenance: %v", err)
+	}
+}
+
+func TestBatchAbort(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	abort := errors.New("abort")
+	if err := g.Batch(ctx, func(b *Batch) error {
+		b.UpsertA("www.owasp.org", "192.0.2.1")
+		return abort
+	}); !errors.Is(err, abort) {
+		t.Fatalf("expected the error returned by the function: %v", err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := g.Batch(cctx, func(b *Batch) error {
+		b.UpsertA("www.owasp.org", "192.0.2.1")
+		return nil
+	}); err == nil {
+		t.Error("expected an error when the context is cancelled")
+	}
+
+	if _, err := g.findAsset(context.Background(), &domain.FQDN{Name: "www.owasp.org"}); err == nil {
+		t.Error("the aborted batches wrote to the graph")
+	}
+}
+
+const benchmarkRecords = 100
+
+func BenchmarkUpsertA(b *testing.B) {
+	benchmarkBackends(b, func(ctx context.Context, g *Graph, n int) {
+		for i := 0; i < benchmarkRecords; i++ {
+			_ = g.UpsertA(ctx, fmt.Sprintf("host%d-%d.owasp.org", n, i), fmt.Sprintf("10.%d.%d.%d", n%256, i/256, i%256))
+		}
+	})
+}
+
+func BenchmarkBatchUpsertA(b *testing.B) {
+	benchmarkBackends(b, func(ctx context.Context, g *Graph, n int) {
+		_ = g.Batch(ctx, func(batch *Batch) error {
+			for i := 0; i < benchmarkRecords; i++ {
+				batch.UpsertA(fmt.Sprintf("host%d-%d.owasp.org", n, i), fmt.Sprintf("10.%d.%d.%d", n%256, i/256, i%256))
+			}
+			return nil
+		})
+	})
+}
+
+// benchmarkBackends runs the benchmark against SQLite, and against Postgres when
+// the NETMAP_POSTGRES_DSN environment variable provides a database to use.
+func benchmarkBackends(b *testing.B, fn func(ctx context.Context, g *Graph, n int)) {
+	backends := map[string]string{"sqlite": ""}
+	if dsn := os.Getenv("NETMAP_POSTGRES_DSN"); dsn != "" {
+		backends["postgres"] = dsn
+	}
+
+	for name, dsn := range backends {
+		b.Run(name, func(b *testing.B) {
+			g := NewGraph("memory", "", "")
+			if dsn != "" {
+				g = NewGraph("postgres", dsn, "")
+			}
+			defer g.Remove()
+
+			ctx := context.Background()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				fn(ctx, g, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/network"
+	"gorm.io/gorm"
+)
+
+// The graph keeps each IP address and netblock contained by the most specific netblock that covers it,
+// so the infrastructure hierarchy does not depend on the order the assets were upserted. The most specific netblock
+// may not be announced, so readers follow the netblocks containing it until an announced netblock is found.
+
+type containedRow struct {
+	ID        uint64
+	Type      string
+	Address   string
+	Cidr      string
+	CreatedAt time.Time
+}
+
+// prefix returns the netblock, or the single address prefix, described by the row.
+func (r *containedRow) prefix() (netip.Prefix, bool) {
+	if r.Type == string(oam.IPAddress) {
+		ip, err := netip.ParseAddr(r.Address)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		return netip.PrefixFrom(ip, ip.BitLen()), true
+	}
+
+	prefix, err := netip.ParsePrefix(r.Cidr)
+	return prefix, err == nil
+}
+
+// place links the upserted IP address or netblock into the netblock hierarchy. Only a netblock
+// created by the upsert takes over the assets it covers.
+func (g *Graph) place(ctx context.Context, a *types.Asset, created bool, at time.Time) error {
+	switch v := a.Asset.(type) {
+	case *network.IPAddress:
+		return g.contain(ctx, a, netip.PrefixFrom(v.Address, v.Address.BitLen()), true, at)
+	case *network.Netblock:
+		if err := g.indexNetblock(a, v); err != nil {
+			return err
+		}
+		if err := g.contain(ctx, a, v.Cidr, false, at); err != nil || !created {
+			return err
+		}
+		return g.sweep(ctx, a, v.Cidr, at)
+	}
+	return nil
+}
+
+// contain links the asset to the most specific netblock in the graph that covers the prefix.
+// A netblock equal to the prefix is only considered when exact is true.
+func (g *Graph) contain(ctx context.Context, a *types.Asset, prefix netip.Prefix, exact bool, at time.Time) error {
+	parent, found, err := containingNetblock(g.db.WithContext(ctx), prefix, exact)
+	if err != nil || !found {
+		return err
+	}
+	// The netblock cannot contain the asset before the netblock was first seen
+	if !at.IsZero() && at.Before(parent.CreatedAt) {
+		at = parent.CreatedAt
+	}
+	return g.linkAt(ctx, parent, "contains", a, at)
+}
+
+// sweep moves the addresses and netblocks covered by the new netblock underneath it. They are taken from the
+// netblock that contains the new netblock, or from the assets not yet contained by any netblock.
+func (g *Graph) sweep(ctx context.Context, nb *types.Asset, prefix netip.Prefix, at time.Time) error {
+	tx := g.db.WithContext(ctx)
+
+	var parentID uint64
+	parent, found, err := containingNetblock(tx, prefix, false)
+	if err != nil {
+		return err
+	} else if found {
+		if parentID, err = strconv.ParseUint(parent.ID, 10, 64); err != nil {
+			return err
+		}
+	}
+
+	var rows []containedRow
+	if err := tx.Raw(`SELECT assets.id, assets.type, assets.content->>'address' AS address,
+		assets.content->>'cidr' AS cidr FROM assets WHERE assets.type IN ('IPAddress', 'Netblock')
+		AND assets.content->>'type' = ? AND COALESCE(assets.content->>'address', assets.content->>'cidr') LIKE ?
+		AND (assets.id IN (SELECT to_asset_id FROM relations
+		WHERE from_asset_id = ? AND type = 'contains') OR NOT EXISTS (SELECT 1 FROM relations
+		INNER JOIN assets AS nbs ON relations.from_asset_id = nbs.id WHERE relations.to_asset_id = assets.id
+		AND relations.type = 'contains' AND nbs.type = 'Netblock')) ORDER BY assets.id`,
+		addrType(prefix.Addr()), prefixPattern(prefix), parentID).Scan(&rows).Error; err != nil {
+		return backendError(err)
+	}
+
+	var moved []uint64
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		child, ok := row.prefix()
+		if !ok || child == prefix || child.Bits() < prefix.Bits() || !prefix.Contains(child.Addr()) {
+			continue
+		}
+
+		asset := &types.Asset{ID: strconv.FormatUint(row.ID, 10)}
+		if row.Type == string(oam.IPAddress) {
+			asset.Asset = &network.IPAddress{Address: child.Addr(), Type: addrType(child.Addr())}
+		} else {
+			asset.Asset = &network.Netblock{Cidr: child, Type: addrType(child.Addr())}
+		}
+		// The new edge is created before the old one is removed, so a failure never leaves the asset uncontained
+		if err := g.linkAt(ctx, nb, "contains", asset, at); err != nil {
+			return err
+		}
+		moved = append(moved, row.ID)
+	}
+	if parentID == 0 || len(moved) == 0 {
+		return nil
+	}
+
+	return backendError(tx.Transaction(func(tx *gorm.DB) error {
+		args := map[string]interface{}{"parent": parentID, "ids": moved}
+		match := "from_asset_id = @parent AND to_asset_id IN @ids AND type = 'contains'"
+
+		if err := tx.Exec("DELETE FROM relation_sources WHERE relation_id IN (SELECT id FROM relations WHERE "+
+			match+")", args).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM relations WHERE "+match, args).Error
+	}))
+}
+
+// prefixPattern returns a LIKE pattern matching the text of every address and netblock covered by the prefix.
+// Only the leading groups fixed by the prefix are matched, so the candidates still need to be checked against it.
+func prefixPattern(prefix netip.Prefix) string {
+	ip := prefix.Addr()
+	if ip.Is4() {
+		octets := strings.Split(ip.String(), ".")
+		fixed := min(prefix.Bits()/8, len(octets)-1)
+		return strings.Join(append(octets[:fixed:fixed], "%"), ".")
+	}
+
+	// IPv6 text compresses a run of zero groups, so only the groups before the first zero group are reliable
+	var pattern string
+	raw := ip.As16()
+	for i := 0; i < prefix.Bits()/16 && i < 7; i++ {
+		group := uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+		if group == 0 {
+			break
+		}
+		pattern += strconv.FormatUint(uint64(group), 16) + ":"
+	}
+	return pattern + "%"
+}
+
+// containingNetblock returns the most specific netblock in the graph that covers the prefix.
+// A netblock equal to the prefix is only returned when exact is true.
+func containingNetblock(tx *gorm.DB, prefix netip.Prefix, exact bool) (*types.Asset, bool, error) {
+	last := prefix.Bits()
+	if !exact {
+		last--
+	}
+
+	var cidrs []string
+	for bits := 0; bits <= last; bits++ {
+		if p, err := prefix.Addr().Prefix(bits); err == nil {
+			cidrs = append(cidrs, p.String())
+		}
+	}
+	if len(cidrs) == 0 {
+		return nil, false, nil
+	}
+
+	var rows []containedRow
+	if err := tx.Raw("SELECT id, type, content->>'cidr' AS cidr, created_at FROM assets WHERE type = 'Netblock'" +
+		" AND content->>'cidr' IN (" + sqlStringList(cidrs) + ")").Scan(&rows).Error; err != nil {
+		return nil, false, backendError(err)
+	}
+
+	var best *containedRow
+	var bestPrefix netip.Prefix
+	for i := range rows {
+		if p, ok := rows[i].prefix(); ok && (best == nil || p.Bits() > bestPrefix.Bits()) {
+			best, bestPrefix = &rows[i], p
+		}
+	}
+	if best == nil {
+		return nil, false, nil
+	}
+
+	return &types.Asset{
+		ID:        strconv.FormatUint(best.ID, 10),
+		CreatedAt: best.CreatedAt,
+		Asset:     &network.Netblock{Cidr: bestPrefix, Type: addrType(bestPrefix.Addr())},
+	}, true, nil
+}
+
+// placeAll links every IP address and netblock in the graph to the most specific netblock containing it, and
+// removes the contains edges from other netblocks. Bulk imports use it once, rather than placing each netblock
+// as it is written. The new edges are attributed to the provenance carried by the context.
+func (g *Graph) placeAll(ctx context.Context, at time.Time) error {
+	at = at.UTC().Truncate(time.Second)
+
+	var rows []containedRow
+	if err := g.rawQuery(ctx, `SELECT id, type, content->>'address' AS address, content->>'cidr' AS cidr
+		FROM assets WHERE type IN ('IPAddress', 'Netblock')`, &rows); err != nil {
+		return err
+	}
+
+	idx := newPrefixIndex()
+	prefixes := make(map[uint64]netip.Prefix, len(rows))
+	for i := range rows {
+		if p, ok := rows[i].prefix(); ok {
+			prefixes[rows[i].ID] = p
+			if rows[i].Type == string(oam.Netblock) {
+				idx.insert(p, rows[i].ID)
+			}
+		}
+	}
+
+	parents := make(map[uint64]uint64, len(rows))
+	for i := range rows {
+		p, ok := prefixes[rows[i].ID]
+		if !ok {
+			continue
+		}
+
+		matches := idx.match(p.Addr())
+		for j := len(matches) - 1; j >= 0; j-- {
+			m := matches[j]
+			if m.id != rows[i].ID && m.prefix.Bits() <= p.Bits() {
+				parents[rows[i].ID] = m.id
+				break
+			}
+		}
+	}
+
+	var edges []struct {
+		ID          uint64
+		FromAssetID uint64
+		ToAssetID   uint64
+	}
+	if err := g.rawQuery(ctx, `SELECT relations.id, relations.from_asset_id, relations.to_asset_id FROM relations
+		INNER JOIN assets ON relations.from_asset_id = assets.id
+		WHERE relations.type = 'contains' AND assets.type = 'Netblock'`, &edges); err != nil {
+		return err
+	}
+
+	var stale []uint64
+	for _, e := range edges {
+		if parent, found := parents[e.ToAssetID]; found && parent == e.FromAssetID {
+			delete(parents, e.ToAssetID)
+		} else {
+			stale = append(stale, e.ID)
+		}
+	}
+
+	children := make([]uint64, 0, len(parents))
+	for child := range parents {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i] < children[j] })
+
+	prov := provenanceFrom(ctx)
+	ts := at.Format(sqlTimeFormat)
+	return backendError(g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(stale); start += relationLoadPageSize {
+			end := start + relationLoadPageSize
+			if end > len(stale) {
+				end = len(stale)
+			}
+
+			if err := tx.Exec("DELETE FROM relation_sources WHERE relation_id IN ?", stale[start:end]).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("DELETE FROM relations WHERE id IN ?", stale[start:end]).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, child := range children {
+			var id uint64
+			if err := tx.Raw(`INSERT INTO relations (type, from_asset_id, to_asset_id, created_at, last_seen)
+				VALUES ('contains', ?, ?, ?, ?) RETURNING id`, parents[child], child, ts, ts).Scan(&id).Error; err != nil {
+				return err
+			}
+			if prov != nil {
+				if err := attribute(tx, "relation_sources", "relation_id", id, prov, at); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,163 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainment(t *testing.T) {
+	want := []string{
+		"10.0.0.0/8 -> 10.1.0.0/16",
+		"10.0.0.0/8 -> 10.2.0.1",
+		"10.1.0.0/16 -> 10.1.0.0/24",
+		"10.1.0.0/16 -> 10.1.1.1",
+		"10.1.0.0/24 -> 10.1.0.1",
+	}
+
+	orders := map[string][]string{
+		"general first":   {"10.0.0.0/8", "10.1.0.0/16", "10.1.0.0/24", "10.1.0.1", "10.1.1.1", "10.2.0.1", "172.16.0.1"},
+		"specific first":  {"10.1.0.0/24", "10.1.0.0/16", "10.0.0.0/8", "10.1.0.1", "10.1.1.1", "10.2.0.1", "172.16.0.1"},
+		"addresses first": {"10.1.0.1", "10.1.1.1", "10.2.0.1", "172.16.0.1", "10.0.0.0/8", "10.1.0.0/24", "10.1.0.0/16"},
+		"interleaved":     {"10.1.1.1", "10.0.0.0/8", "10.1.0.1", "172.16.0.1", "10.1.0.0/24", "10.2.0.1", "10.1.0.0/16"},
+	}
+
+	for name, order := range orders {
+		t.Run(name, func(t *testing.T) {
+			g := NewGraph("memory", "", "")
+			defer g.Remove()
+
+			ctx := context.Background()
+			for _, v := range order {
+				var err error
+				if strings.Contains(v, "/") {
+					_, err = g.UpsertNetblock(ctx, v)
+				} else {
+					_, err = g.UpsertAddress(ctx, v)
+				}
+				if err != nil {
+					t.Fatalf("failed to upsert %s: %v", v, err)
+				}
+			}
+
+			if got := containsEdges(t, g); strings.Join(got, ", ") != strings.Join(want, ", ") {
+				t.Errorf("unexpected hierarchy:\n got %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestUnannouncedNetblockOrigin(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	// The unannounced /24 takes the address over from the announced /16
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.example.com", "10.0.0.1")
+	_ = g.UpsertInfrastructure(ctx, 64500, "EXAMPLE", "10.0.0.1", "10.0.0.0/16")
+	_, _ = g.UpsertNetblock(ctx, "10.0.0.0/24")
+
+	if got := containsEdges(t, g); strings.Join(got, ", ") != "10.0.0.0/16 -> 10.0.0.0/24, 10.0.0.0/24 -> 10.0.0.1" {
+		t.Errorf("unexpected hierarchy: %v", got)
+	}
+
+	if got, err := g.LookupAddress(ctx, "10.0.0.1"); err != nil || got.ASN != 64500 || got.Netblock.String() != "10.0.0.0/16" {
+		t.Errorf("unexpected lookup: %+v: %v", got, err)
+	}
+
+	var buf bytes.Buffer
+	if err := g.WriteAddressCSV(ctx, &buf, time.Time{}, nil, "www.example.com"); err != nil {
+		t.Fatalf("failed to write the address report: %v", err)
+	}
+	if !strings.Contains(buf.String(), "www.example.com,10.0.0.1,64500,EXAMPLE,10.0.0.0/16,") {
+		t.Errorf("the address report lost the origin: %s", buf.String())
+	}
+
+	bundle, err := g.BuildSTIXBundle(ctx, time.Time{}, "www.example.com")
+	if err != nil {
+		t.Fatalf("failed to build the STIX bundle: %v", err)
+	}
+	var found bool
+	for _, obj := range bundle.Objects {
+		if obj.Type == "autonomous-system" && obj.Number == 64500 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("the STIX bundle lost the autonomous system")
+	}
+}
+
+func TestPrefixPattern(t *testing.T) {
+	for _, tc := range []struct {
+		cidr, pattern string
+		covered       []string
+	}{
+		{"10.0.0.0/8", "10.%", []string{"10.1.0.1", "10.1.0.0/16"}},
+		{"10.1.0.0/16", "10.1.%", []string{"10.1.255.1", "10.1.0.0/24"}},
+		{"192.0.2.0/25", "192.0.2.%", []string{"192.0.2.1"}},
+		{"192.0.2.1/32", "192.0.2.%", []string{"192.0.2.1"}},
+		{"0.0.0.0/0", "%", []string{"10.1.0.1"}},
+		{"2001:db8:1::/48", "2001:db8:1:%", []string{"2001:db8:1::1", "2001:db8:1:1::/64"}},
+		{"2001:0:0:5::/64", "2001:%", []string{"2001::5:1:1:1:1", "2001:0:0:5::1"}},
+		{"::/0", "%", []string{"::1"}},
+	} {
+		prefix := netip.MustParsePrefix(tc.cidr)
+		if got := prefixPattern(prefix); got != tc.pattern {
+			t.Errorf("%s: expected %q, got %q", tc.cidr, tc.pattern, got)
+		}
+		for _, v := range tc.covered {
+			if !strings.HasPrefix(v, strings.TrimSuffix(tc.pattern, "%")) {
+				t.Errorf("%s: the pattern %q does not match %s", tc.cidr, tc.pattern, v)
+			}
+		}
+	}
+}
+
+func TestBatchContainment(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_, _ = g.UpsertNetblock(ctx, "192.0.2.0/24")
+
+	if err := g.Batch(ctx, func(b *Batch) error {
+		b.UpsertA("www.owasp.org", "192.0.2.1")
+		b.UpsertA("owasp.org", "192.0.2.1")
+		b.UpsertA("mail.owasp.org", "198.51.100.1")
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to write the batch: %v", err)
+	}
+
+	if got := containsEdges(t, g); len(got) != 1 || got[0] != "192.0.2.0/24 -> 192.0.2.1" {
+		t.Errorf("unexpected contains edges: %v", got)
+	}
+}
+
+func containsEdges(t *testing.T, g *Graph) []string {
+	var rows []struct {
+		Parent string
+		Child  string
+	}
+	if err := g.db.Raw(`SELECT p.content->>'cidr' AS parent, COALESCE(c.content->>'cidr', c.content->>'address') AS child
+		FROM relations INNER JOIN assets AS p ON relations.from_asset_id = p.id
+		INNER JOIN assets AS c ON relations.to_asset_id = c.id WHERE relations.type = 'contains'`).Scan(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var edges []string
+	for _, r := range rows {
+		edges = append(edges, r.Parent+" -> "+r.Child)
+	}
+	sort.Strings(edges)
+	return edges
+}
@@ -0,0 +1,358 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+// CSVColumn identifies a column that can be included in a CSV report.
+type CSVColumn string
+
+// Columns available in the reports generated by WriteAddressCSV.
+const (
+	ColumnName          CSVColumn = "name"
+	ColumnAddress       CSVColumn = "address"
+	ColumnASN           CSVColumn = "asn"
+	ColumnASDescription CSVColumn = "as_description"
+	ColumnNetblock      CSVColumn = "netblock"
+	ColumnFirstSeen     CSVColumn = "first_seen"
+	ColumnLastSeen      CSVColumn = "last_seen"
+	// ColumnROV is the route origin validation state of the netblock announced by the autonomous system.
+	ColumnROV CSVColumn = "rov"
+)
+
+// Columns available in the reports generated by WriteRelationCSV, along with ColumnFirstSeen and ColumnLastSeen.
+const (
+	ColumnFromType CSVColumn = "from_type"
+	ColumnFrom     CSVColumn = "from"
+	ColumnRelation CSVColumn = "relation"
+	ColumnToType   CSVColumn = "to_type"
+	ColumnTo       CSVColumn = "to"
+)
+
+// DefaultAddressColumns is the column set used by WriteAddressCSV when none are provided.
+var DefaultAddressColumns = []CSVColumn{
+	ColumnName, ColumnAddress, ColumnASN, ColumnASDescription, ColumnNetblock, ColumnFirstSeen, ColumnLastSeen,
+}
+
+// addressColumns is the column set supported by WriteAddressCSV. ColumnROV is only written when requested.
+var addressColumns = append([]CSVColumn{ColumnROV}, DefaultAddressColumns...)
+
+// DefaultRelationColumns is the column set used by WriteRelationCSV when none are provided.
+var DefaultRelationColumns = []CSVColumn{
+	ColumnFromType, ColumnFrom, ColumnRelation, ColumnToType, ColumnTo, ColumnFirstSeen, ColumnLastSeen,
+}
+
+type addrInfo struct {
+	asn       int
+	desc      string
+	netblock  string
+	firstSeen time.Time
+	lastSeen  time.Time
+	rov       ROVState
+}
+
+// WriteAddressCSV writes one row per name / address pair returned by NamesToAddrs for the provided names.
+// The first and last seen columns refer to the IP address asset. Only the requested columns are written.
+// ColumnROV is left empty when no ROAs have been imported.
+func (g *Graph) WriteAddressCSV(ctx context.Context, w io.Writer, since time.Time, columns []CSVColumn, names ...string) error {
+	if len(columns) == 0 {
+		columns = DefaultAddressColumns
+	}
+	if err := checkColumns(columns, addressColumns); err != nil {
+		return err
+	}
+
+	var roas *roaSet
+	if hasColumn(columns, ColumnROV) {
+		payloads, err := g.readROAs(ctx, "")
+		if err != nil {
+			return err
+		} else if len(payloads) > 0 {
+			roas = newROASet(payloads)
+		}
+	}
+
+	pairs, err := g.NamesToAddrs(ctx, since, names...)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columnHeader(columns)); err != nil {
+		return err
+	}
+
+	infos := make(map[string]*addrInfo)
+	for _, p := range pairs {
+		addr := p.Addr.Address.String()
+
+		info, found := infos[addr]
+		if !found {
+			if info, err = g.readAddrInfo(ctx, p.Addr, since); err != nil {
+				return err
+			}
+			if prefix, err := netip.ParsePrefix(info.netblock); err == nil && roas != nil && info.asn != 0 {
+				info.rov = validateRoute(prefix, info.asn, roas.covering(prefix)).State
+			}
+			infos[addr] = info
+		}
+
+		row := make([]string, 0, len(columns))
+		for _, c := range columns {
+			switch c {
+			case ColumnName:
+				row = append(row, p.FQDN.Name)
+			case ColumnAddress:
+				row = append(row, addr)
+			case ColumnASN:
+				var asn string
+				if info.asn != 0 {
+					asn = strconv.Itoa(info.asn)
+				}
+				row = append(row, asn)
+			case ColumnASDescription:
+				row = append(row, info.desc)
+			case ColumnNetblock:
+				row = append(row, info.netblock)
+			case ColumnFirstSeen:
+				row = append(row, csvTime(info.firstSeen))
+			case ColumnLastSeen:
+				row = append(row, csvTime(info.lastSeen))
+			case ColumnROV:
+				row = append(row, string(info.rov))
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// readAddrInfo collects the announcing netblock and autonomous system for the address.
+func (g *Graph) readAddrInfo(ctx context.Context, addr *network.IPAddress, since time.Time) (*addrInfo, error) {
+	info := new(addrInfo)
+
+	assets, err := g.findByContent(ctx, addr, since)
+	if err != nil || len(assets) == 0 {
+		return info, err
+	}
+	info.firstSeen = assets[0].CreatedAt
+	info.lastSeen = assets[0].LastSeen
+
+	netblock, asn, err := g.announcingNetblock(ctx, assets[0], since)
+	if err != nil || netblock == nil {
+		return info, err
+	}
+	info.netblock = netblock.Asset.(*network.Netblock).Cidr.String()
+	if asn == 0 {
+		return info, nil
+	}
+
+	info.asn = asn
+	if info.desc, err = g.ReadASDescription(ctx, asn, since); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return info, nil
+}
+
+// announcingNetblock follows the netblocks containing the asset, from the most specific one, until a netblock
+// announced by an autonomous system is found. The most specific netblock is returned with a zero ASN when none
+// of them are announced, and a nil netblock when the asset is not contained by any netblock.
+func (g *Graph) announcingNetblock(ctx context.Context, asset *types.Asset, since time.Time) (*types.Asset, int, error) {
+	var first *types.Asset
+
+	seen := map[string]struct{}{asset.ID: {}}
+	for cur := asset; ; {
+		rels, err := g.incomingRelations(ctx, cur, since, "contains")
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var parent *types.Asset
+		for _, rel := range rels {
+			if _, ok := rel.FromAsset.Asset.(*network.Netblock); ok {
+				if _, found := seen[rel.FromAsset.ID]; !found {
+					parent = rel.FromAsset
+					break
+				}
+			}
+		}
+		if parent == nil {
+			return first, 0, nil
+		}
+		seen[parent.ID] = struct{}{}
+		if first == nil {
+			first = parent
+		}
+
+		asn, err := g.announcedBy(ctx, parent, since)
+		if err != nil {
+			return nil, 0, err
+		} else if asn != 0 {
+			return parent, asn, nil
+		}
+		cur = parent
+	}
+}
+
+// announcedBy returns the number of the autonomous system announcing the netblock, or zero if not found.
+func (g *Graph) announcedBy(ctx context.Context, netblock *types.Asset, since time.Time) (int, error) {
+	rels, err := g.incomingRelations(ctx, netblock, since, "announces")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rel := range rels {
+		if as, ok := rel.FromAsset.Asset.(*network.AutonomousSystem); ok {
+			return as.Number, nil
+		}
+	}
+	return 0, nil
+}
+
+// incomingRelations returns the relations of the type pointing to the asset, when the relation
+// and the asset it originates from were seen after since.
+func (g *Graph) incomingRelations(ctx context.Context, asset *types.Asset, since time.Time, relation string) ([]*types.Relation, error) {
+	tr := TimeRange{Since: since}
+
+	return g.relationQuery(ctx, "relations INNER JOIN assets ON relations.from_asset_id = assets.id"+
+		" WHERE relations.to_asset_id = "+asset.ID+" AND relations.type = '"+sqlEscape(relation)+"'"+
+		tr.constraint("relations")+tr.constraint("assets"))
+}
+
+// WriteRelationCSV writes one row per relation of the provided type in the graph.
+// Only the requested columns are written.
+func (g *Graph) WriteRelationCSV(ctx context.Context, w io.Writer, since time.Time, relation string, columns []CSVColumn) error {
+	if len(columns) == 0 {
+		columns = DefaultRelationColumns
+	}
+	if err := checkColumns(columns, DefaultRelationColumns); err != nil {
+		return err
+	}
+
+	rels, err := g.relationQuery(ctx, "relations WHERE relations.type = '"+sqlEscape(relation)+"'"+
+		TimeRange{Since: since}.constraint("relations"))
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columnHeader(columns)); err != nil {
+		return err
+	}
+
+	for _, rel := range rels {
+		row := make([]string, 0, len(columns))
+		for _, c := range columns {
+			switch c {
+			case ColumnFromType:
+				row = append(row, string(rel.FromAsset.Asset.AssetType()))
+			case ColumnFrom:
+				row = append(row, assetString(rel.FromAsset.Asset))
+			case ColumnRelation:
+				row = append(row, rel.Type)
+			case ColumnToType:
+				row = append(row, string(rel.ToAsset.Asset.AssetType()))
+			case ColumnTo:
+				row = append(row, assetString(rel.ToAsset.Asset))
+			case ColumnFirstSeen:
+				row = append(row, csvTime(rel.CreatedAt))
+			case ColumnLastSeen:
+				row = append(row, csvTime(rel.LastSeen))
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func checkColumns(columns, allowed []CSVColumn) error {
+	for _, c := range columns {
+		var found bool
+
+		for _, a := range allowed {
+			if c == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not a supported column for this report", c)
+		}
+	}
+	return nil
+}
+
+func hasColumn(columns []CSVColumn, column CSVColumn) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+func columnHeader(columns []CSVColumn) []string {
+	header := make([]string, 0, len(columns))
+
+	for _, c := range columns {
+		header = append(header, string(c))
+	}
+	return header
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(sqlTimeFormat)
+}
+
+// assetString returns the value that best identifies the asset in a report.
+func assetString(asset oam.Asset) string {
+	switch v := asset.(type) {
+	case *domain.FQDN:
+		return v.Name
+	case *network.IPAddress:
+		return v.Address.String()
+	case *network.Netblock:
+		return v.Cidr.String()
+	case *network.AutonomousSystem:
+		return strconv.Itoa(v.Number)
+	case *network.RIROrganization:
+		return v.Name
+	}
+
+	if content, err := asset.JSON(); err == nil {
+		return string(content)
+	}
+	return ""
+}
+
+func sqlEscape(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
@@ -0,0 +1,92 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSV(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.owasp.org", "192.168.1.1")
+	_ = g.UpsertCNAME(ctx, "owasp.org", "www.owasp.org")
+	_ = g.UpsertInfrastructure(ctx, 667, "Great AS", "192.168.1.1", "192.168.1.0/24")
+
+	t.Run("Testing WriteAddressCSV...", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := g.WriteAddressCSV(ctx, &buf, time.Time{}, nil, "www.owasp.org"); err != nil {
+			t.Fatalf("failed to write the address report: %v", err)
+		}
+
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil || len(records) != 2 {
+			t.Fatalf("expected a header and one row, got %v: %v", records, err)
+		}
+
+		want := []string{"www.owasp.org", "192.168.1.1", "667", "Great AS", "192.168.1.0/24"}
+		for i, w := range want {
+			if records[1][i] != w {
+				t.Errorf("column %s: expected: %s, got: %s", records[0][i], w, records[1][i])
+			}
+		}
+		if records[1][5] == "" || records[1][6] == "" {
+			t.Error("the first and last seen columns were not populated")
+		}
+	})
+
+	t.Run("Testing WriteAddressCSV columns...", func(t *testing.T) {
+		var buf bytes.Buffer
+		cols := []CSVColumn{ColumnAddress, ColumnName}
+		if err := g.WriteAddressCSV(ctx, &buf, time.Time{}, cols, "www.owasp.org"); err != nil {
+			t.Fatalf("failed to write the address report: %v", err)
+		}
+		if got := buf.String(); got != "address,name\n192.168.1.1,www.owasp.org\n" {
+			t.Errorf("unexpected report: %s", got)
+		}
+
+		if err := g.WriteAddressCSV(ctx, &buf, time.Time{}, []CSVColumn{ColumnTo}, "www.owasp.org"); err == nil {
+			t.Error("did not return an error for an unsupported column")
+		}
+	})
+
+	t.Run("Testing WriteRelationCSV...", func(t *testing.T) {
+		var buf bytes.Buffer
+		cols := []CSVColumn{ColumnFrom, ColumnRelation, ColumnTo}
+		if err := g.WriteRelationCSV(ctx, &buf, time.Time{}, "cname_record", cols); err != nil {
+			t.Fatalf("failed to write the relation report: %v", err)
+		}
+		if got := buf.String(); got != "from,relation,to\nowasp.org,cname_record,www.owasp.org\n" {
+			t.Errorf("unexpected report: %s", got)
+		}
+	})
+}
+
+func TestAddressCSVNestedNetblock(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.example.com", "10.0.0.1")
+	_ = g.UpsertInfrastructure(ctx, 64500, "EXAMPLE", "10.0.0.1", "10.0.0.0/16")
+	// The unannounced netblock takes over the address from the announced one
+	_, _ = g.UpsertNetblock(ctx, "10.0.0.0/24")
+
+	var buf bytes.Buffer
+	cols := []CSVColumn{ColumnName, ColumnASN, ColumnASDescription, ColumnNetblock}
+	if err := g.WriteAddressCSV(ctx, &buf, time.Time{}, cols, "www.example.com"); err != nil {
+		t.Fatalf("failed to write the address report: %v", err)
+	}
+	if want := "www.example.com,64500,EXAMPLE,10.0.0.0/16\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("expected the announcing supernet %q: %s", want, buf.String())
+	}
+}
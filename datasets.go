@@ -0,0 +1,438 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/open-asset-model/network"
+	"gorm.io/gorm"
+)
+
+// datasetBatchSize is the number of dataset entries written within each transaction.
+const datasetBatchSize = 1000
+
+// datasetEntry is a netblock and the autonomous systems announcing it, read from an IP-to-ASN dataset.
+// An entry without a valid prefix only describes the autonomous systems.
+type datasetEntry struct {
+	prefix netip.Prefix
+	asns   []int
+	// org is the organization managing the autonomous systems and the netblock.
+	org *network.RIROrganization
+	// adjacent are the autonomous systems that the autonomous systems of the entry exchange routes with,
+	// linked by the relation, or adjacent_to when it is empty.
+	adjacent []int
+	relation string
+}
+
+// ImportIPtoASN reads an iptoasn.com dataset (ip2asn-v4.tsv, ip2asn-v6.tsv or ip2asn-combined.tsv) and upserts
+// the netblocks of each range, the autonomous system announcing them and the organization managing it.
+// The ranges that are not routed are ignored. Malformed lines are skipped and listed in the returned report.
+func (g *Graph) ImportIPtoASN(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	report := new(ImportReport)
+	imp := g.newDatasetImporter(ctx, report, time.Time{})
+
+	err := scanLines(ctx, r, func(n int, line []byte) {
+		report.Lines++
+
+		fields := strings.Split(strings.TrimRight(string(line), "\r"), "\t")
+		if len(fields) < 5 {
+			report.skip(n, fmt.Errorf("the line has %d fields instead of 5", len(fields)))
+			return
+		}
+
+		start, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			report.skip(n, err)
+			return
+		}
+		end, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			report.skip(n, err)
+			return
+		} else if start.BitLen() != end.BitLen() || end.Less(start) {
+			report.skip(n, fmt.Errorf("%s - %s is not a valid range", start, end))
+			return
+		}
+
+		asn, err := strconv.Atoi(fields[2])
+		if err != nil {
+			report.skip(n, err)
+			return
+		} else if asn == 0 {
+			return
+		}
+
+		var org *network.RIROrganization
+		if desc := strings.TrimSpace(fields[4]); desc != "" {
+			org = &network.RIROrganization{Name: desc}
+		}
+		for _, prefix := range rangePrefixes(start, end) {
+			imp.add(&datasetEntry{prefix: prefix, asns: []int{asn}, org: org})
+		}
+	})
+	return report, imp.close(err)
+}
+
+// ImportPrefix2AS reads a CAIDA Routeviews prefix-to-AS dataset (pfx2as) and upserts each netblock and the
+// autonomous systems originating it. Multi-origin and AS set origins announce the netblock from each of the
+// autonomous systems. Malformed lines are skipped and listed in the returned report.
+func (g *Graph) ImportPrefix2AS(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	report := new(ImportReport)
+	imp := g.newDatasetImporter(ctx, report, time.Time{})
+
+	err := scanLines(ctx, r, func(n int, line []byte) {
+		report.Lines++
+
+		fields := strings.Fields(string(line))
+		if len(fields) != 3 {
+			report.skip(n, fmt.Errorf("the line has %d fields instead of 3", len(fields)))
+			return
+		}
+
+		prefix, err := netip.ParsePrefix(fields[0] + "/" + fields[1])
+		if err != nil {
+			report.skip(n, err)
+			return
+		}
+
+		var asns []int
+		for _, origin := range strings.FieldsFunc(fields[2], func(r rune) bool { return r == '_' || r == ',' }) {
+			asn, err := strconv.Atoi(origin)
+			if err != nil {
+				report.skip(n, fmt.Errorf("%s is not a valid origin", fields[2]))
+				return
+			}
+			asns = append(asns, asn)
+		}
+		imp.add(&datasetEntry{prefix: prefix.Masked(), asns: asns})
+	})
+	return report, imp.close(err)
+}
+
+// ImportRIRDelegations reads a delegated or delegated-extended statistics file published by a regional Internet
+// registry and upserts the allocated and assigned autonomous systems and netblocks. The files do not name the
+// holders, so the organization managing the autonomous systems and netblocks only has the opaque ID of the extended
+// format as its RIR ID. The files record registrations rather than routing, so no announcements are created.
+// Malformed lines are skipped and listed in the returned report.
+func (g *Graph) ImportRIRDelegations(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	report := new(ImportReport)
+
+	var entries []*datasetEntry
+	err := scanLines(ctx, r, func(n int, line []byte) {
+		text := strings.TrimSpace(string(line))
+		if strings.HasPrefix(text, "#") {
+			return
+		}
+		report.Lines++
+
+		fields := strings.Split(text, "|")
+		// The version line starts with the format version and the summary lines end with "summary"
+		if _, err := strconv.ParseFloat(fields[0], 64); err == nil || fields[len(fields)-1] == "summary" {
+			return
+		}
+		if len(fields) < 7 {
+			report.skip(n, fmt.Errorf("the line has %d fields instead of at least 7", len(fields)))
+			return
+		}
+
+		registry, rtype, start, value, status := fields[0], fields[2], fields[3], fields[4], fields[6]
+		if status != "allocated" && status != "assigned" {
+			return
+		}
+
+		entry := new(datasetEntry)
+		switch rtype {
+		case "asn":
+			first, err := strconv.Atoi(start)
+			if err != nil {
+				report.skip(n, err)
+				return
+			}
+			count, err := strconv.Atoi(value)
+			if err != nil || count <= 0 {
+				report.skip(n, fmt.Errorf("%s is not a valid number of autonomous systems", value))
+				return
+			}
+			for asn := first; asn < first+count; asn++ {
+				entry.asns = append(entry.asns, asn)
+			}
+		case "ipv4":
+			addr, err := netip.ParseAddr(start)
+			if err != nil || !addr.Is4() {
+				report.skip(n, fmt.Errorf("%s is not a valid IPv4 address", start))
+				return
+			}
+			count, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || count == 0 {
+				report.skip(n, fmt.Errorf("%s is not a valid number of addresses", value))
+				return
+			}
+
+			b := addr.As4()
+			last := uint64(binary.BigEndian.Uint32(b[:])) + count - 1
+			if last > 0xffffffff {
+				report.skip(n, fmt.Errorf("the range starting at %s exceeds the address space", start))
+				return
+			}
+			binary.BigEndian.PutUint32(b[:], uint32(last))
+
+			org := delegationHolder(registry, fields)
+			for _, prefix := range rangePrefixes(addr, netip.AddrFrom4(b)) {
+				entries = append(entries, &datasetEntry{prefix: prefix, org: org})
+			}
+			return
+		case "ipv6":
+			prefix, err := netip.ParsePrefix(start + "/" + value)
+			if err != nil || !prefix.Addr().Is6() {
+				report.skip(n, fmt.Errorf("%s/%s is not a valid IPv6 netblock", start, value))
+				return
+			}
+			entry.prefix = prefix.Masked()
+		default:
+			report.skip(n, fmt.Errorf("the %s record type is not supported", rtype))
+			return
+		}
+
+		entry.org = delegationHolder(registry, fields)
+		entries = append(entries, entry)
+	})
+
+	imp := g.newDatasetImporter(ctx, report, time.Time{})
+	for _, entry := range entries {
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+		imp.add(entry)
+	}
+	return report, imp.close(err)
+}
+
+// delegationHolder returns the organization identified by the opaque ID of a delegated-extended record,
+// or nil when the record does not have one.
+func delegationHolder(registry string, fields []string) *network.RIROrganization {
+	if len(fields) > 7 && fields[7] != "" {
+		return &network.RIROrganization{RIRId: fields[7], RIR: registry}
+	}
+	return nil
+}
+
+// datasetImporter writes the entries of a dataset into the graph in transactions of datasetBatchSize.
+type datasetImporter struct {
+	g       *Graph
+	ctx     context.Context
+	at      time.Time
+	report  *ImportReport
+	seen    map[string]struct{}
+	pending []*datasetEntry
+	writer  *batchWriter
+	err     error
+	// netblocks is true once an entry with a netblock has been queued.
+	netblocks bool
+}
+
+// newDatasetImporter returns a datasetImporter that writes the entries observed at the provided time.
+// When at is zero, the current time is used as the observation time.
+func (g *Graph) newDatasetImporter(ctx context.Context, report *ImportReport, at time.Time) *datasetImporter {
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	return &datasetImporter{
+		g:      g,
+		ctx:    ctx,
+		at:     at.UTC().Truncate(time.Second),
+		report: report,
+		seen:   make(map[string]struct{}),
+		writer: &batchWriter{
+			prov:  provenanceFrom(ctx),
+			cache: make(map[string]*batchRow),
+		},
+	}
+}
+
+// add queues the entry, writing the queued entries once the batch is full. Once a batch has failed,
+// the entries are ignored and the error is returned by close.
+func (imp *datasetImporter) add(entry *datasetEntry) {
+	if imp.err != nil {
+		return
+	}
+
+	key := fmt.Sprintf("%s|%v|%v|%s", entry.prefix, entry.asns, entry.adjacent, entry.relation)
+	if entry.org != nil {
+		key += "|" + entry.org.Name + "|" + entry.org.RIRId
+	}
+	if _, found := imp.seen[key]; found {
+		imp.report.Duplicates++
+		return
+	}
+	imp.seen[key] = struct{}{}
+
+	imp.netblocks = imp.netblocks || entry.prefix.IsValid()
+	imp.pending = append(imp.pending, entry)
+	if len(imp.pending) >= datasetBatchSize {
+		imp.err = imp.flush()
+	}
+}
+
+// close writes the remaining entries and places the new netblocks within the netblock hierarchy.
+// The error provided is returned when no other error has occurred.
+func (imp *datasetImporter) close(err error) error {
+	if imp.err != nil {
+		err = imp.err
+	} else if err == nil {
+		err = imp.flush()
+	}
+	if imp.report.Records == 0 || !imp.netblocks {
+		return err
+	}
+
+	// Whatever was committed is placed, even when the import did not complete
+	if perr := imp.g.placeAll(imp.ctx, imp.at); err == nil {
+		err = perr
+	}
+	imp.g.index.reset()
+	return err
+}
+
+func (imp *datasetImporter) flush() error {
+	if len(imp.pending) == 0 {
+		return nil
+	}
+
+	item := &batchItem{first: imp.at, last: imp.at}
+	err := imp.g.db.WithContext(imp.ctx).Transaction(func(tx *gorm.DB) error {
+		imp.writer.undo = make(map[string]*batchRow)
+
+		for _, entry := range imp.pending {
+			if err := imp.write(tx, entry, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		return backendError(err)
+	}
+
+	imp.report.Records += len(imp.pending)
+	imp.pending = imp.pending[:0]
+	return nil
+}
+
+func (imp *datasetImporter) write(tx *gorm.DB, entry *datasetEntry, item *batchItem) error {
+	w := imp.writer
+
+	var org uint64
+	if entry.org != nil {
+		var err error
+		if org, err = w.org(tx, entry.org, item); err != nil {
+			return err
+		}
+	}
+
+	var netblock uint64
+	if entry.prefix.IsValid() {
+		var err error
+		netblock, err = w.asset(tx, &network.Netblock{Cidr: entry.prefix, Type: addrType(entry.prefix.Addr())},
+			"content->>'cidr'", entry.prefix.String(), item)
+		if err != nil {
+			return err
+		}
+		if org != 0 {
+			if err := w.relation(tx, netblock, "managed_by", org, item); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, asn := range entry.asns {
+		as, err := w.asset(tx, &network.AutonomousSystem{Number: asn},
+			"CAST(content->>'number' AS TEXT)", strconv.Itoa(asn), item)
+		if err != nil {
+			return err
+		}
+
+		if netblock != 0 {
+			if err := w.relation(tx, as, "announces", netblock, item); err != nil {
+				return err
+			}
+		}
+		relation := entry.relation
+		if relation == "" {
+			relation = "adjacent_to"
+		}
+		for _, adj := range entry.adjacent {
+			neighbor, err := w.asset(tx, &network.AutonomousSystem{Number: adj},
+				"CAST(content->>'number' AS TEXT)", strconv.Itoa(adj), item)
+			if err != nil {
+				return err
+			}
+			if err := w.relation(tx, as, relation, neighbor, item); err != nil {
+				return err
+			}
+		}
+		if org != 0 {
+			if err := w.relation(tx, as, "managed_by", org, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// org returns the identifier of the organization, which is identified by its RIR ID when it has no name.
+func (w *batchWriter) org(tx *gorm.DB, org *network.RIROrganization, item *batchItem) (uint64, error) {
+	if org.Name == "" {
+		return w.asset(tx, org, "content->>'rir_id'", org.RIRId, item)
+	}
+	return w.asset(tx, org, "content->>'name'", org.Name, item)
+}
+
+// rangePrefixes returns the smallest set of prefixes covering the range of addresses from start to end.
+func rangePrefixes(start, end netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+
+	for start.IsValid() && !end.Less(start) {
+		bits := start.BitLen()
+		for bits > 0 {
+			p, _ := start.Prefix(bits - 1)
+			if p.Addr() != start || end.Less(lastAddr(p)) {
+				break
+			}
+			bits--
+		}
+
+		p := netip.PrefixFrom(start, bits)
+		prefixes = append(prefixes, p)
+		start = lastAddr(p).Next()
+	}
+	return prefixes
+}
+
+// lastAddr returns the last address within the prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
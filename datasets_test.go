@@ -0,0 +1,172 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportIPtoASN(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_, _ = g.UpsertAddress(ctx, "1.0.5.1")
+
+	report := importDataset(t, g, g.ImportIPtoASN, "testdata/datasets/ip2asn-combined.tsv")
+	if report.Lines != 6 || report.Records != 4 || report.Duplicates != 1 || len(report.Skipped) != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	if got, err := g.LookupAddress(ctx, "1.0.5.1"); err != nil || got.Netblock.String() != "1.0.5.0/25" ||
+		got.ASN != 38803 || got.Description != "GTELECOM-AUSTRALIA Gtelecom-AUSTRALIA" {
+		t.Errorf("unexpected lookup result: %+v, %v", got, err)
+	}
+	if got, err := g.LookupAddress(ctx, "2001:db8::1"); err != nil || got.ASN != 64496 {
+		t.Errorf("unexpected lookup result: %+v, %v", got, err)
+	}
+	if _, err := g.LookupAddress(ctx, "1.0.2.1"); err == nil {
+		t.Error("the range that is not routed was imported")
+	}
+	// The existing address was placed in the imported netblock
+	if got := containsEdges(t, g); !contains(got, "1.0.5.0/25 -> 1.0.5.1") {
+		t.Errorf("the address was not placed in the imported netblock: %v", got)
+	}
+}
+
+func TestImportPrefix2AS(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	report := importDataset(t, g, g.ImportPrefix2AS, "testdata/datasets/routeviews-rv2.pfx2as")
+	if report.Lines != 6 || report.Records != 5 || len(report.Skipped) != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	if got, err := g.ReadSubnets(ctx, "1.0.4.0/22", time.Time{}); err != nil ||
+		strings.Join(got, " ") != "1.0.4.0/24 1.0.5.0/24" {
+		t.Errorf("the imported netblocks were not placed in the hierarchy: %v, %v", got, err)
+	}
+
+	overlaps, err := g.AnnouncementOverlaps(ctx, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var moas int
+	for _, o := range overlaps {
+		if o.MOAS() {
+			moas++
+		}
+	}
+	if len(overlaps) != 5 || moas != 2 {
+		t.Errorf("unexpected overlaps between the multiple origins: %d, %d MOAS", len(overlaps), moas)
+	}
+}
+
+func TestImportRIRDelegations(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	report := importDataset(t, g, g.ImportRIRDelegations, "testdata/datasets/delegated-apnic-extended.txt")
+	if report.Lines != 11 || report.Records != 6 || len(report.Skipped) != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// The registrations are not announcements
+	if got, err := g.ReadASPrefixes(ctx, 38803, time.Time{}); err != nil || len(got) != 0 {
+		t.Errorf("the netblocks of the holder were announced: %v, %v", got, err)
+	}
+	if got, err := g.LookupAddress(ctx, "1.0.4.1"); err != nil || got.Netblock.String() != "1.0.4.0/23" || got.ASN != 0 {
+		t.Errorf("unexpected lookup result: %+v, %v", got, err)
+	}
+
+	var got []string
+	if err := g.db.Raw(`SELECT nbs.content->>'cidr' FROM relations INNER JOIN assets AS nbs
+		ON relations.from_asset_id = nbs.id INNER JOIN assets AS orgs ON relations.to_asset_id = orgs.id
+		WHERE relations.type = 'managed_by' AND nbs.type = 'Netblock'
+		AND orgs.content->>'rir_id' = 'A91D7C57'`).Scan(&got).Error; err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if strings.Join(got, " ") != "1.0.4.0/23 1.0.6.0/24 2001:db8::/32" {
+		t.Errorf("unexpected netblocks managed by the holder: %v", got)
+	}
+	var orgs []string
+	if err := g.db.Raw(`SELECT orgs.content->>'rir_id' FROM relations INNER JOIN assets AS orgs
+		ON relations.to_asset_id = orgs.id WHERE relations.type = 'managed_by' AND orgs.type = 'RIROrg'
+		AND orgs.content->>'name' = '' AND relations.from_asset_id = (SELECT id FROM assets WHERE type = 'ASN'
+		AND CAST(content->>'number' AS TEXT) = '64501')`).Scan(&orgs).Error; err != nil ||
+		strings.Join(orgs, " ") != "A9100000" {
+		t.Errorf("unexpected organization managing the autonomous system: %v, %v", orgs, err)
+	}
+	// The opaque ID is not a description of the autonomous system
+	if desc, err := g.ReadASDescription(ctx, 64501, time.Time{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the RIR ID was returned as the description: %s, %v", desc, err)
+	}
+	if got, err := g.LookupAddress(ctx, "198.51.100.1"); err == nil {
+		t.Errorf("the available netblock was imported: %+v", got)
+	}
+}
+
+func TestImportRIRDelegationsDescription(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = importDataset(t, g, g.ImportIPtoASN, "testdata/datasets/ip2asn-combined.tsv")
+	_ = importDataset(t, g, g.ImportRIRDelegations, "testdata/datasets/delegated-apnic-extended.txt")
+
+	if desc, err := g.ReadASDescription(ctx, 38803, time.Time{}); err != nil ||
+		desc != "GTELECOM-AUSTRALIA Gtelecom-AUSTRALIA" {
+		t.Errorf("the description was replaced by the holder ID: %s, %v", desc, err)
+	}
+}
+
+func TestRangePrefixes(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       string
+	}{
+		{"10.0.0.0", "10.0.0.255", "10.0.0.0/24"},
+		{"10.0.0.1", "10.0.0.6", "10.0.0.1/32 10.0.0.2/31 10.0.0.4/31 10.0.0.6/32"},
+		{"0.0.0.0", "255.255.255.255", "0.0.0.0/0"},
+		{"255.255.255.254", "255.255.255.255", "255.255.255.254/31"},
+		{"2001:db8::", "2001:db8::1:ffff", "2001:db8::/111"},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, p := range rangePrefixes(netip.MustParseAddr(test.start), netip.MustParseAddr(test.end)) {
+			got = append(got, p.String())
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("%s - %s: got %v, want %s", test.start, test.end, got, test.want)
+		}
+	}
+}
+
+func importDataset(t *testing.T, g *Graph, fn func(context.Context, io.Reader) (*ImportReport, error), path string) *ImportReport {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	report, err := fn(context.Background(), f)
+	if err != nil {
+		t.Fatalf("failed to import %s: %v", path, err)
+	}
+	return report
+}
@@ -0,0 +1,450 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	oam "github.com/owasp-amass/open-asset-model"
+)
+
+// GraphDiff describes the changes to the attack surface between two points in time or two sessions.
+type GraphDiff struct {
+	From               string
+	To                 string
+	NewFQDNs           []string
+	DisappearedFQDNs   []string
+	ChangedResolutions []*ResolutionChange
+	NewNetblocks       []string
+	NewASNs            []int
+	ASChanges          []*ASChange
+}
+
+// ResolutionChange describes a name that resolves to a different set of addresses and autonomous systems.
+type ResolutionChange struct {
+	Name       string
+	Before     []string
+	After      []string
+	BeforeASNs []int
+	AfterASNs  []int
+}
+
+// ASChange describes a netblock that is announced by a different set of autonomous systems.
+type ASChange struct {
+	Netblock string
+	Before   []int
+	After    []int
+}
+
+// diffState provides the constraints selecting the assets and relations that make up one side of a diff.
+type diffState struct {
+	asset    func(alias string) string
+	relation func(alias string) string
+	// known selects the assets that were already known, which are not new when found on the other side.
+	// When nil, the assets selected by asset are used.
+	known func(alias string) string
+}
+
+// Diff compares the graph as it was seen during the period of the same length ending at from with the
+// observations made after from, up until to. Names first seen after from are new, and names seen during
+// the earlier period that have not been seen since from have disappeared.
+func (g *Graph) Diff(ctx context.Context, from, to time.Time) (*GraphDiff, error) {
+	if !from.Before(to) {
+		return nil, invalidInput("the from time %v is not before the to time %v", from, to)
+	}
+
+	// The state before from is limited to what was still seen during the previous period, so the
+	// names and resolutions that went away earlier are not reported by every later diff
+	f := from.UTC().Format(sqlTimeFormat)
+	t := to.UTC().Format(sqlTimeFormat)
+	p := from.Add(-to.Sub(from)).UTC().Format(sqlTimeFormat)
+	before := func(alias string) string {
+		return alias + ".last_seen > '" + p + "' AND " + alias + ".created_at <= '" + f + "'"
+	}
+	known := func(alias string) string {
+		return alias + ".created_at <= '" + f + "'"
+	}
+	after := func(alias string) string {
+		return alias + ".last_seen > '" + f + "' AND " + alias + ".created_at <= '" + t + "'"
+	}
+
+	d := &GraphDiff{
+		From: from.UTC().Format(time.RFC3339),
+		To:   to.UTC().Format(time.RFC3339),
+	}
+	return d, g.diff(ctx, d, &diffState{asset: before, relation: before, known: known},
+		&diffState{asset: after, relation: after})
+}
+
+// DiffSessions compares the assets and relations linked to the from session with those linked to the to session.
+func (g *Graph) DiffSessions(ctx context.Context, from, to string) (*GraphDiff, error) {
+	var states []*diffState
+	for _, id := range []string{from, to} {
+		if _, err := g.ReadSession(ctx, id); err != nil {
+			return nil, err
+		}
+
+		event := sqlEscape(id)
+		states = append(states, &diffState{
+			asset: func(alias string) string {
+				return alias + ".id IN (SELECT asset_id FROM asset_sources WHERE event = '" + event + "')"
+			},
+			relation: func(alias string) string {
+				return alias + ".id IN (SELECT relation_id FROM relation_sources WHERE event = '" + event + "')"
+			},
+		})
+	}
+
+	d := &GraphDiff{From: from, To: to}
+	return d, g.diff(ctx, d, states[0], states[1])
+}
+
+func (g *Graph) diff(ctx context.Context, d *GraphDiff, before, after *diffState) error {
+	known := before
+	if before.known != nil {
+		known = &diffState{asset: before.known}
+	}
+
+	sets := make(map[oam.AssetType][3]map[string]struct{})
+	for atype, field := range map[oam.AssetType]string{
+		oam.FQDN:     "name",
+		oam.Netblock: "cidr",
+		oam.ASN:      "number",
+	} {
+		var set [3]map[string]struct{}
+		for i, state := range []*diffState{before, after, known} {
+			if i == 2 && known == before {
+				set[i] = set[0]
+				continue
+			}
+
+			values, err := g.diffAssets(ctx, atype, field, state)
+			if err != nil {
+				return err
+			}
+			set[i] = values
+		}
+		sets[atype] = set
+	}
+	bnames, anames, knames := sets[oam.FQDN][0], sets[oam.FQDN][1], sets[oam.FQDN][2]
+	ablocks, kblocks := sets[oam.Netblock][1], sets[oam.Netblock][2]
+	aasns, kasns := sets[oam.ASN][1], sets[oam.ASN][2]
+
+	d.NewFQDNs = setDifference(anames, knames)
+	d.DisappearedFQDNs = setDifference(bnames, anames)
+	d.NewNetblocks = setDifference(ablocks, kblocks)
+	for _, asn := range setDifference(aasns, kasns) {
+		if n, err := strconv.Atoi(asn); err == nil {
+			d.NewASNs = append(d.NewASNs, n)
+		}
+	}
+	sort.Ints(d.NewASNs)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := g.diffResolutions(ctx, d, before, after); err != nil {
+		return err
+	}
+	return g.diffAnnouncements(ctx, d, before, after)
+}
+
+func (g *Graph) diffAssets(ctx context.Context, atype oam.AssetType, field string, state *diffState) (map[string]struct{}, error) {
+	var values []string
+
+	if err := g.db.WithContext(ctx).Raw("SELECT assets.content->>'" + field + "' FROM assets WHERE assets.type = '" +
+		string(atype) + "' AND " + state.asset("assets")).Scan(&values).Error; err != nil {
+		return nil, backendError(err)
+	}
+
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set, nil
+}
+
+type diffPair struct {
+	Src string
+	Dst string
+}
+
+// diffPairs returns the values related to each key, using the relations of the state.
+func (g *Graph) diffPairs(ctx context.Context, query string) (map[string]map[string]struct{}, error) {
+	var pairs []diffPair
+
+	if err := g.db.WithContext(ctx).Raw(query).Scan(&pairs).Error; err != nil {
+		return nil, backendError(err)
+	}
+
+	m := make(map[string]map[string]struct{})
+	for _, p := range pairs {
+		if _, found := m[p.Src]; !found {
+			m[p.Src] = make(map[string]struct{})
+		}
+		m[p.Src][p.Dst] = struct{}{}
+	}
+	return m, nil
+}
+
+func (g *Graph) diffResolutions(ctx context.Context, d *GraphDiff, before, after *diffState) error {
+	resolutions := func(state *diffState) (map[string]map[string]struct{}, error) {
+		return g.diffPairs(ctx, `SELECT fqdns.content->>'name' AS src, ips.content->>'address' AS dst
+			FROM ((relations INNER JOIN assets AS fqdns ON relations.from_asset_id = fqdns.id)
+			INNER JOIN assets AS ips ON relations.to_asset_id = ips.id)
+			WHERE relations.type IN ('a_record', 'aaaa_record') AND `+state.relation("relations"))
+	}
+	origins := func(state *diffState) (map[string]map[string]struct{}, error) {
+		// The addresses are contained by the most specific netblock, which may not be announced itself
+		addrs, err := g.diffPairs(ctx, `SELECT ips.content->>'address' AS src, CAST(c.from_asset_id AS TEXT) AS dst
+			FROM relations AS c INNER JOIN assets AS ips ON c.to_asset_id = ips.id
+			WHERE c.type = 'contains' AND ips.type = 'IPAddress' AND `+state.relation("c"))
+		if err != nil {
+			return nil, err
+		}
+		parents, err := g.diffPairs(ctx, `SELECT CAST(c.to_asset_id AS TEXT) AS src, CAST(c.from_asset_id AS TEXT) AS dst
+			FROM ((relations AS c INNER JOIN assets AS nbs ON c.to_asset_id = nbs.id)
+			INNER JOIN assets AS supernets ON c.from_asset_id = supernets.id)
+			WHERE c.type = 'contains' AND nbs.type = 'Netblock' AND supernets.type = 'Netblock' AND `+state.relation("c"))
+		if err != nil {
+			return nil, err
+		}
+		announced, err := g.diffPairs(ctx, `SELECT CAST(a.to_asset_id AS TEXT) AS src, asns.content->>'number' AS dst
+			FROM relations AS a INNER JOIN assets AS asns ON a.from_asset_id = asns.id
+			WHERE a.type = 'announces' AND asns.type = 'ASN' AND `+state.relation("a"))
+		if err != nil {
+			return nil, err
+		}
+
+		m := make(map[string]map[string]struct{}, len(addrs))
+		for addr, netblocks := range addrs {
+			if asns := nearestOrigins(netblocks, parents, announced); len(asns) > 0 {
+				m[addr] = asns
+			}
+		}
+		return m, nil
+	}
+
+	bres, err := resolutions(before)
+	if err != nil {
+		return err
+	}
+	ares, err := resolutions(after)
+	if err != nil {
+		return err
+	}
+	borigins, err := origins(before)
+	if err != nil {
+		return err
+	}
+	aorigins, err := origins(after)
+	if err != nil {
+		return err
+	}
+
+	for name, aaddrs := range ares {
+		baddrs, found := bres[name]
+		if !found {
+			continue
+		}
+
+		bASNs := addrASNs(baddrs, borigins)
+		aASNs := addrASNs(aaddrs, aorigins)
+		if sameSet(baddrs, aaddrs) && intsEqual(bASNs, aASNs) {
+			continue
+		}
+
+		d.ChangedResolutions = append(d.ChangedResolutions, &ResolutionChange{
+			Name:       name,
+			Before:     sortedKeys(baddrs),
+			After:      sortedKeys(aaddrs),
+			BeforeASNs: bASNs,
+			AfterASNs:  aASNs,
+		})
+	}
+
+	sort.Slice(d.ChangedResolutions, func(i, j int) bool {
+		return d.ChangedResolutions[i].Name < d.ChangedResolutions[j].Name
+	})
+	return nil
+}
+
+func (g *Graph) diffAnnouncements(ctx context.Context, d *GraphDiff, before, after *diffState) error {
+	announcements := func(state *diffState) (map[string]map[string]struct{}, error) {
+		return g.diffPairs(ctx, `SELECT nbs.content->>'cidr' AS src, asns.content->>'number' AS dst
+			FROM ((relations INNER JOIN assets AS asns ON relations.from_asset_id = asns.id)
+			INNER JOIN assets AS nbs ON relations.to_asset_id = nbs.id)
+			WHERE relations.type = 'announces' AND `+state.relation("relations"))
+	}
+
+	bann, err := announcements(before)
+	if err != nil {
+		return err
+	}
+	aann, err := announcements(after)
+	if err != nil {
+		return err
+	}
+
+	for cidr, aasns := range aann {
+		if basns, found := bann[cidr]; found && !sameSet(basns, aasns) {
+			d.ASChanges = append(d.ASChanges, &ASChange{
+				Netblock: cidr,
+				Before:   atois(sortedKeys(basns)),
+				After:    atois(sortedKeys(aasns)),
+			})
+		}
+	}
+
+	sort.Slice(d.ASChanges, func(i, j int) bool { return d.ASChanges[i].Netblock < d.ASChanges[j].Netblock })
+	return nil
+}
+
+// WriteReport writes the diff to w as a human-readable report.
+func (d *GraphDiff) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Changes from %s to %s\n", d.From, d.To)
+	writeSection(tw, "New FQDNs", d.NewFQDNs)
+	writeSection(tw, "Disappeared FQDNs", d.DisappearedFQDNs)
+
+	fmt.Fprintf(tw, "\nChanged resolutions (%d)\n", len(d.ChangedResolutions))
+	for _, c := range d.ChangedResolutions {
+		fmt.Fprintf(tw, "  %s\t%s %s\t->\t%s %s\n", c.Name, strings.Join(c.Before, ","), asnList(c.BeforeASNs),
+			strings.Join(c.After, ","), asnList(c.AfterASNs))
+	}
+
+	writeSection(tw, "New netblocks", d.NewNetblocks)
+
+	var asns []string
+	for _, asn := range d.NewASNs {
+		asns = append(asns, "AS"+strconv.Itoa(asn))
+	}
+	writeSection(tw, "New autonomous systems", asns)
+
+	fmt.Fprintf(tw, "\nChanged announcements (%d)\n", len(d.ASChanges))
+	for _, c := range d.ASChanges {
+		fmt.Fprintf(tw, "  %s\t%s\t->\t%s\n", c.Netblock, asnList(c.Before), asnList(c.After))
+	}
+	return tw.Flush()
+}
+
+func writeSection(w io.Writer, title string, items []string) {
+	fmt.Fprintf(w, "\n%s (%d)\n", title, len(items))
+	for _, item := range items {
+		fmt.Fprintf(w, "  %s\n", item)
+	}
+}
+
+func asnList(asns []int) string {
+	if len(asns) == 0 {
+		return "[]"
+	}
+
+	s := make([]string, 0, len(asns))
+	for _, asn := range asns {
+		s = append(s, "AS"+strconv.Itoa(asn))
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
+
+func addrASNs(addrs map[string]struct{}, origins map[string]map[string]struct{}) []int {
+	set := make(map[string]struct{})
+	for addr := range addrs {
+		for asn := range origins[addr] {
+			set[asn] = struct{}{}
+		}
+	}
+	return atois(sortedKeys(set))
+}
+
+// nearestOrigins returns the autonomous systems announcing the netblocks, or the nearest of their supernets
+// that are announced when none of the netblocks are.
+func nearestOrigins(netblocks map[string]struct{}, parents, announced map[string]map[string]struct{}) map[string]struct{} {
+	seen := make(map[string]struct{})
+
+	for len(netblocks) > 0 {
+		asns := make(map[string]struct{})
+		next := make(map[string]struct{})
+		for nb := range netblocks {
+			seen[nb] = struct{}{}
+			for asn := range announced[nb] {
+				asns[asn] = struct{}{}
+			}
+			for parent := range parents[nb] {
+				if _, found := seen[parent]; !found {
+					next[parent] = struct{}{}
+				}
+			}
+		}
+		if len(asns) > 0 {
+			return asns
+		}
+		netblocks = next
+	}
+	return nil
+}
+
+func setDifference(a, b map[string]struct{}) []string {
+	var diff []string
+	for k := range a {
+		if _, found := b[k]; !found {
+			diff = append(diff, k)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func sameSet(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, found := b[k]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func atois(values []string) []int {
+	var nums []int
+	for _, v := range values {
+		if n, err := strconv.Atoi(v); err == nil {
+			nums = append(nums, n)
+		}
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	week1 := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	week2 := week1.Add(7 * 24 * time.Hour)
+
+	for _, rec := range []struct {
+		name, addr, cidr string
+		asn              int
+		at               time.Time
+	}{
+		{"www.owasp.org", "192.0.2.1", "192.0.2.0/24", 100, week1},
+		{"old.owasp.org", "192.0.2.2", "192.0.2.0/24", 100, week1},
+		{"api.owasp.org", "192.0.2.3", "192.0.2.0/24", 100, week1},
+		{"www.owasp.org", "198.51.100.1", "198.51.100.0/24", 200, week2},
+		{"new.owasp.org", "198.51.100.2", "198.51.100.0/24", 200, week2},
+		{"api.owasp.org", "192.0.2.3", "192.0.2.0/24", 300, week2},
+	} {
+		if err := g.UpsertAAt(ctx, rec.name, rec.addr, rec.at); err != nil {
+			t.Fatalf("failed to insert the A record: %v", err)
+		}
+		if err := g.UpsertInfrastructureAt(ctx, rec.asn, "AS"+rec.name, rec.addr, rec.cidr, rec.at); err != nil {
+			t.Fatalf("failed to insert the infrastructure: %v", err)
+		}
+	}
+
+	d, err := g.Diff(ctx, week1.Add(time.Hour), week2.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to diff the graph: %v", err)
+	}
+
+	if !reflect.DeepEqual(d.NewFQDNs, []string{"new.owasp.org"}) {
+		t.Errorf("unexpected new FQDNs: %v", d.NewFQDNs)
+	}
+	if !reflect.DeepEqual(d.DisappearedFQDNs, []string{"old.owasp.org"}) {
+		t.Errorf("unexpected disappeared FQDNs: %v", d.DisappearedFQDNs)
+	}
+	if !reflect.DeepEqual(d.NewNetblocks, []string{"198.51.100.0/24"}) {
+		t.Errorf("unexpected new netblocks: %v", d.NewNetblocks)
+	}
+	if !reflect.DeepEqual(d.NewASNs, []int{200, 300}) {
+		t.Errorf("unexpected new ASNs: %v", d.NewASNs)
+	}
+
+	expected := []*ResolutionChange{
+		{Name: "api.owasp.org", Before: []string{"192.0.2.3"}, After: []string{"192.0.2.3"}, BeforeASNs: []int{100}, AfterASNs: []int{300}},
+		{Name: "www.owasp.org", Before: []string{"192.0.2.1"}, After: []string{"198.51.100.1"}, BeforeASNs: []int{100}, AfterASNs: []int{200}},
+	}
+	if !reflect.DeepEqual(d.ChangedResolutions, expected) {
+		t.Errorf("unexpected resolution changes: %+v", d.ChangedResolutions)
+	}
+	if len(d.ASChanges) != 1 || d.ASChanges[0].Netblock != "192.0.2.0/24" ||
+		!reflect.DeepEqual(d.ASChanges[0].Before, []int{100}) || !reflect.DeepEqual(d.ASChanges[0].After, []int{300}) {
+		t.Errorf("unexpected AS changes: %+v", d.ASChanges)
+	}
+
+	var buf strings.Builder
+	if err := d.WriteReport(&buf); err != nil {
+		t.Fatalf("failed to write the report: %v", err)
+	}
+	report := buf.String()
+	for _, s := range []string{"New FQDNs (1)", "old.owasp.org", "192.0.2.1 [AS100]", "198.51.100.1 [AS200]", "Changed announcements (1)"} {
+		if !strings.Contains(report, s) {
+			t.Errorf("the report does not contain %q:\n%s", s, report)
+		}
+	}
+
+	if _, err := g.Diff(ctx, week2, week1); err == nil {
+		t.Error("did not return an error when from is after to")
+	}
+}
+
+func TestDiffWeekly(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+
+	_ = g.UpsertAAt(ctx, "old.owasp.org", "192.0.2.3", start)
+	_ = g.UpsertAAt(ctx, "www.owasp.org", "192.0.2.1", start)
+	for i := 0; i <= 11; i++ {
+		_ = g.UpsertAAt(ctx, "www.owasp.org", "192.0.2.2", start.Add(time.Duration(i)*week))
+	}
+
+	from := start.Add(10 * week)
+	d, err := g.Diff(ctx, from, from.Add(week))
+	if err != nil {
+		t.Fatalf("failed to diff the graph: %v", err)
+	}
+	// The name and resolution that went away during the first week are not reported again
+	if len(d.NewFQDNs) != 0 || len(d.DisappearedFQDNs) != 0 || len(d.ChangedResolutions) != 0 {
+		t.Errorf("unexpected changes to a stable graph: %+v", d)
+	}
+
+	d, err = g.Diff(ctx, start.Add(time.Hour), start.Add(week+time.Hour))
+	if err != nil {
+		t.Fatalf("failed to diff the graph: %v", err)
+	}
+	if !reflect.DeepEqual(d.DisappearedFQDNs, []string{"old.owasp.org"}) || len(d.ChangedResolutions) != 1 ||
+		!reflect.DeepEqual(d.ChangedResolutions[0].After, []string{"192.0.2.2"}) {
+		t.Errorf("unexpected changes during the first week: %+v", d)
+	}
+}
+
+func TestDiffNestedNetblock(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	week1 := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	week2 := week1.Add(7 * 24 * time.Hour)
+
+	for _, rec := range []struct {
+		asn int
+		at  time.Time
+	}{{100, week1}, {200, week2}} {
+		_ = g.UpsertAAt(ctx, "www.owasp.org", "10.0.0.1", rec.at)
+		_ = g.UpsertInfrastructureAt(ctx, rec.asn, "EXAMPLE", "10.0.0.1", "10.0.0.0/16", rec.at)
+		// The unannounced netblock contains the address
+		_, _ = g.UpsertNetblockAt(ctx, "10.0.0.0/24", rec.at)
+	}
+
+	d, err := g.Diff(ctx, week1.Add(time.Hour), week2.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to diff the graph: %v", err)
+	}
+
+	expected := []*ResolutionChange{
+		{Name: "www.owasp.org", Before: []string{"10.0.0.1"}, After: []string{"10.0.0.1"}, BeforeASNs: []int{100}, AfterASNs: []int{200}},
+	}
+	if !reflect.DeepEqual(d.ChangedResolutions, expected) {
+		t.Errorf("unexpected resolution changes: %+v", d.ChangedResolutions)
+	}
+}
+
+func TestDiffSessions(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	first, err := g.StartSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to start the session: %v", err)
+	}
+	if err := g.UpsertA(WithSession(ctx, first.ID), "a.owasp.org", "192.0.2.1"); err != nil {
+		t.Fatalf("failed to insert the A record: %v", err)
+	}
+
+	second, err := g.StartSession(ctx)
+	if err != nil {
+		t.Fatalf("failed to start the session: %v", err)
+	}
+	if err := g.UpsertA(WithSession(ctx, second.ID), "b.owasp.org", "192.0.2.1"); err != nil {
+		t.Fatalf("failed to insert the A record: %v", err)
+	}
+
+	d, err := g.DiffSessions(ctx, first.ID, second.ID)
+	if err != nil {
+		t.Fatalf("failed to diff the sessions: %v", err)
+	}
+	if !reflect.DeepEqual(d.NewFQDNs, []string{"b.owasp.org"}) || !reflect.DeepEqual(d.DisappearedFQDNs, []string{"a.owasp.org"}) {
+		t.Errorf("unexpected diff: %+v", d)
+	}
+	if _, err := g.DiffSessions(ctx, first.ID, "unknown"); err == nil {
+		t.Error("did not return an error for an unknown session")
+	}
+}
@@ -0,0 +1,471 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// dnstapContentType is the Frame Streams content type used by dnstap.
+const dnstapContentType = "protobuf:dnstap.Dnstap"
+
+// Frame Streams control frame types and fields.
+const (
+	fstrmControlAccept      = 0x01
+	fstrmControlStart       = 0x02
+	fstrmControlStop        = 0x03
+	fstrmControlReady       = 0x04
+	fstrmControlFinish      = 0x05
+	fstrmFieldContentType   = 0x01
+	fstrmMaxControlFrameLen = 512
+	fstrmMaxDataFrameLen    = 1024 * 1024
+)
+
+// dnstap message types carrying responses that are ingested into the graph.
+const (
+	dnstapResolverResponse = 4
+	dnstapClientResponse   = 6
+)
+
+// DnstapOptions configures how dnstap data is written into the graph.
+type DnstapOptions struct {
+	// BatchSize is the number of distinct answer records collected before they are upserted.
+	BatchSize int
+	// FlushInterval is the longest time an answer record waits in a batch before being upserted.
+	FlushInterval time.Duration
+	// QueueSize is the number of decoded responses buffered before reading from the input is paused.
+	QueueSize int
+}
+
+// DefaultDnstapOptions returns the options used when none are provided to the dnstap ingesters.
+func DefaultDnstapOptions() *DnstapOptions {
+	return &DnstapOptions{
+		BatchSize:     500,
+		FlushInterval: time.Second,
+		QueueSize:     1000,
+	}
+}
+
+// IngestDnstapFile reads a dnstap file written using the Frame Streams format and upserts the answers
+// from the CLIENT_RESPONSE and RESOLVER_RESPONSE messages into the graph.
+func (g *Graph) IngestDnstapFile(ctx context.Context, r io.Reader, opts *DnstapOptions) (*PassiveReport, error) {
+	ing := g.newDnstapIngester(ctx, opts)
+
+	err := ing.read(ctx, bufio.NewReader(r), nil)
+	ing.close()
+	return ing.stats(), err
+}
+
+// ServeDnstap accepts Frame Streams connections from dnstap senders on the listener and upserts the answers
+// from the CLIENT_RESPONSE and RESOLVER_RESPONSE messages into the graph as they arrive. It returns when the
+// context is cancelled or the listener fails. When the queue is full, reading from the connections is paused.
+func (g *Graph) ServeDnstap(ctx context.Context, l net.Listener, opts *DnstapOptions) (*PassiveReport, error) {
+	ing := g.newDnstapIngester(ctx, opts)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-stop:
+		}
+	}()
+
+	var err error
+	var wg sync.WaitGroup
+	for {
+		conn, aerr := l.Accept()
+		if aerr != nil {
+			if ctx.Err() == nil {
+				err = aerr
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			defer conn.Close()
+
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-done:
+				}
+			}()
+
+			// The errors caused by the cancellation closing the connection are not failures of the stream
+			if err := ing.read(ctx, bufio.NewReader(conn), conn); err != nil && ctx.Err() == nil {
+				ing.Lock()
+				ing.report.FailedStreams++
+				ing.Unlock()
+			}
+		}(conn)
+	}
+
+	wg.Wait()
+	ing.close()
+	return ing.stats(), err
+}
+
+type dnstapObservation struct {
+	records []*dnsRecord
+	at      time.Time
+}
+
+type dnstapBatchEntry struct {
+	rec   *dnsRecord
+	first time.Time
+	last  time.Time
+}
+
+// dnstapIngester decodes dnstap frames and upserts the answers into the graph from a single goroutine.
+type dnstapIngester struct {
+	sync.Mutex
+	g      *Graph
+	ctx    context.Context
+	opts   DnstapOptions
+	queue  chan *dnstapObservation
+	done   chan struct{}
+	report PassiveReport
+}
+
+func (g *Graph) newDnstapIngester(ctx context.Context, opts *DnstapOptions) *dnstapIngester {
+	o := *DefaultDnstapOptions()
+	if opts != nil {
+		if opts.BatchSize > 0 {
+			o.BatchSize = opts.BatchSize
+		}
+		if opts.FlushInterval > 0 {
+			o.FlushInterval = opts.FlushInterval
+		}
+		if opts.QueueSize > 0 {
+			o.QueueSize = opts.QueueSize
+		}
+	}
+
+	ing := &dnstapIngester{
+		g:     g,
+		ctx:   context.WithoutCancel(ctx),
+		opts:  o,
+		queue: make(chan *dnstapObservation, o.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go ing.processQueue()
+	return ing
+}
+
+func (ing *dnstapIngester) close() {
+	close(ing.queue)
+	<-ing.done
+}
+
+func (ing *dnstapIngester) stats() *PassiveReport {
+	ing.Lock()
+	defer ing.Unlock()
+
+	r := ing.report
+	return &r
+}
+
+// read processes the Frame Streams data from r. When w is not nil, the bidirectional handshake is performed.
+func (ing *dnstapIngester) read(ctx context.Context, r io.Reader, w io.Writer) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, control, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if data == nil {
+			switch control.ctype {
+			case fstrmControlReady:
+				if w == nil {
+					continue
+				}
+				if !control.hasContentType(dnstapContentType) {
+					return fmt.Errorf("the sender does not support the %s content type", dnstapContentType)
+				}
+				if err := writeControlFrame(w, fstrmControlAccept, dnstapContentType); err != nil {
+					return err
+				}
+			case fstrmControlStart:
+				if len(control.contentTypes) > 0 && !control.hasContentType(dnstapContentType) {
+					return fmt.Errorf("the stream does not carry the %s content type", dnstapContentType)
+				}
+			case fstrmControlStop:
+				if w != nil {
+					return writeControlFrame(w, fstrmControlFinish, "")
+				}
+				return nil
+			}
+			continue
+		}
+
+		ing.Lock()
+		ing.report.Packets++
+		ing.Unlock()
+
+		mtype, msg, at, err := parseDnstap(data)
+		if err != nil {
+			ing.addErrors(1)
+			continue
+		} else if mtype != dnstapClientResponse && mtype != dnstapResolverResponse {
+			continue
+		}
+
+		records, response, err := parseDNSResponse(msg)
+		if response {
+			ing.Lock()
+			ing.report.Messages++
+			ing.Unlock()
+		}
+		if err != nil {
+			ing.addErrors(1)
+			continue
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		select {
+		case ing.queue <- &dnstapObservation{records: records, at: at}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (ing *dnstapIngester) addErrors(n int) {
+	ing.Lock()
+	defer ing.Unlock()
+
+	ing.report.Errors += n
+}
+
+func (ing *dnstapIngester) processQueue() {
+	defer close(ing.done)
+
+	t := time.NewTicker(ing.opts.FlushInterval)
+	defer t.Stop()
+
+	batch := make(map[dnsRecord]*dnstapBatchEntry)
+	for {
+		select {
+		case obs, ok := <-ing.queue:
+			if !ok {
+				ing.flush(batch)
+				return
+			}
+
+			for _, rec := range obs.records {
+				if e, found := batch[*rec]; found {
+					e.first, e.last = extendRange(e.first, e.last, obs.at)
+					continue
+				}
+				batch[*rec] = &dnstapBatchEntry{rec: rec, first: obs.at, last: obs.at}
+			}
+
+			if len(batch) >= ing.opts.BatchSize {
+				ing.flush(batch)
+				batch = make(map[dnsRecord]*dnstapBatchEntry)
+			}
+		case <-t.C:
+			if len(batch) > 0 {
+				ing.flush(batch)
+				batch = make(map[dnsRecord]*dnstapBatchEntry)
+			}
+		}
+	}
+}
+
+func (ing *dnstapIngester) flush(batch map[dnsRecord]*dnstapBatchEntry) {
+	var records, errs int
+	ctx := ing.ctx
+
+	for _, e := range batch {
+		err := ing.g.upsertRecord(ctx, e.rec.name, e.rec.rrtype, e.rec.data, e.first)
+		if err == nil && !e.last.Equal(e.first) {
+			err = ing.g.upsertRecord(ctx, e.rec.name, e.rec.rrtype, e.rec.data, e.last)
+		}
+		if err != nil {
+			errs++
+			continue
+		}
+		records++
+	}
+
+	ing.Lock()
+	defer ing.Unlock()
+
+	ing.report.Records += records
+	ing.report.Errors += errs
+}
+
+type controlFrame struct {
+	ctype        uint32
+	contentTypes []string
+}
+
+func (c *controlFrame) hasContentType(ct string) bool {
+	for _, t := range c.contentTypes {
+		if t == ct {
+			return true
+		}
+	}
+	return false
+}
+
+// readFrame returns the payload of the next data frame, or the next control frame when the payload is nil.
+func readFrame(r io.Reader) ([]byte, *controlFrame, error) {
+	var buf [4]byte
+
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, nil, err
+	}
+
+	if flen := binary.BigEndian.Uint32(buf[:]); flen != 0 {
+		if flen > fstrmMaxDataFrameLen {
+			return nil, nil, fmt.Errorf("the data frame length %d is too large", flen)
+		}
+
+		data := make([]byte, flen)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, nil, err
+		}
+		return data, nil, nil
+	}
+
+	// A zero length is the escape sequence that precedes a control frame
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, nil, err
+	}
+	clen := binary.BigEndian.Uint32(buf[:])
+	if clen < 4 || clen > fstrmMaxControlFrameLen {
+		return nil, nil, fmt.Errorf("the control frame length %d is invalid", clen)
+	}
+
+	payload := make([]byte, clen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	c := &controlFrame{ctype: binary.BigEndian.Uint32(payload[0:4])}
+	for fields := payload[4:]; len(fields) >= 8; {
+		ftype := binary.BigEndian.Uint32(fields[0:4])
+		flen := binary.BigEndian.Uint32(fields[4:8])
+		if uint64(flen) > uint64(len(fields)-8) {
+			return nil, nil, errors.New("the control frame field is truncated")
+		}
+
+		if ftype == fstrmFieldContentType {
+			c.contentTypes = append(c.contentTypes, string(fields[8:8+flen]))
+		}
+		fields = fields[8+flen:]
+	}
+	return nil, c, nil
+}
+
+func writeControlFrame(w io.Writer, ctype uint32, contentType string) error {
+	payload := binary.BigEndian.AppendUint32(nil, ctype)
+	if contentType != "" {
+		payload = binary.BigEndian.AppendUint32(payload, fstrmFieldContentType)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(contentType)))
+		payload = append(payload, contentType...)
+	}
+
+	frame := binary.BigEndian.AppendUint32(nil, 0)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// parseDnstap decodes the dnstap protobuf and returns the message type, the DNS response and the time it was sent.
+func parseDnstap(b []byte) (int, []byte, time.Time, error) {
+	var msg []byte
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, nil, time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == 14 && typ == protowire.BytesType {
+			msg, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return 0, nil, time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	if msg == nil {
+		return 0, nil, time.Time{}, errors.New("the dnstap frame does not contain a message")
+	}
+
+	var mtype int
+	var resp []byte
+	var qsec, rsec uint64
+	var qnsec, rnsec uint32
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return 0, nil, time.Time{}, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(msg)
+			mtype = int(v)
+		case num == 8 && typ == protowire.VarintType:
+			qsec, n = protowire.ConsumeVarint(msg)
+		case num == 9 && typ == protowire.Fixed32Type:
+			qnsec, n = protowire.ConsumeFixed32(msg)
+		case num == 12 && typ == protowire.VarintType:
+			rsec, n = protowire.ConsumeVarint(msg)
+		case num == 13 && typ == protowire.Fixed32Type:
+			rnsec, n = protowire.ConsumeFixed32(msg)
+		case num == 14 && typ == protowire.BytesType:
+			resp, n = protowire.ConsumeBytes(msg)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return 0, nil, time.Time{}, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+	}
+
+	at := time.Now()
+	if rsec != 0 {
+		at = time.Unix(int64(rsec), int64(rnsec))
+	} else if qsec != 0 {
+		at = time.Unix(int64(qsec), int64(qnsec))
+	}
+	return mtype, resp, at.UTC(), nil
+}
@@ -0,0 +1,168 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func checkDnstapGraph(t *testing.T, g *Graph, report *PassiveReport) {
+	if report.Packets != 3 || report.Messages != 2 || report.Records != 3 || report.Errors != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	ctx := context.Background()
+	if pairs, err := g.NamesToAddrs(ctx, time.Time{}, "owasp.org"); err != nil || len(pairs) != 2 {
+		t.Errorf("failed to obtain the name / address pairs: %v", err)
+	}
+	if !g.IsCNAMENode(ctx, "www.owasp.org", time.Time{}) {
+		t.Error("the CNAME record was not ingested")
+	}
+
+	assets, err := g.DB.FindByContent(&domain.FQDN{Name: "owasp.org"}, time.Time{})
+	if err != nil || len(assets) != 1 {
+		t.Fatalf("failed to find the FQDN: %v", err)
+	}
+	first := time.Date(2024, time.January, 15, 10, 43, 20, 0, time.UTC)
+	last := time.Date(2024, time.January, 15, 10, 44, 20, 0, time.UTC)
+	if !assets[0].CreatedAt.Equal(first) || !assets[0].LastSeen.Equal(last) {
+		t.Errorf("expected the response timestamps %v and %v, got %v and %v",
+			first, last, assets[0].CreatedAt, assets[0].LastSeen)
+	}
+}
+
+func TestIngestDnstapFile(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	f, err := os.Open("testdata/dns.dnstap")
+	if err != nil {
+		t.Fatalf("failed to open the fixture: %v", err)
+	}
+	defer f.Close()
+
+	report, err := g.IngestDnstapFile(context.Background(), f, nil)
+	if err != nil {
+		t.Fatalf("failed to ingest the dnstap file: %v", err)
+	}
+	checkDnstapGraph(t, g, report)
+}
+
+func TestServeDnstap(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	fixture, err := os.ReadFile("testdata/dns.dnstap")
+	if err != nil {
+		t.Fatalf("failed to read the fixture: %v", err)
+	}
+
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "dnstap.sock"))
+	if err != nil {
+		t.Fatalf("failed to create the listener: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		report *PassiveReport
+		err    error
+	}
+	done := make(chan *result, 1)
+	go func() {
+		report, err := g.ServeDnstap(ctx, l, &DnstapOptions{BatchSize: 1, QueueSize: 1})
+		done <- &result{report: report, err: err}
+	}()
+
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to the listener: %v", err)
+	}
+	defer conn.Close()
+
+	if err := writeControlFrame(conn, fstrmControlReady, dnstapContentType); err != nil {
+		t.Fatalf("failed to send the READY frame: %v", err)
+	}
+	r := bufio.NewReader(conn)
+	if _, c, err := readFrame(r); err != nil || c == nil || c.ctype != fstrmControlAccept {
+		t.Fatalf("did not receive the ACCEPT frame: %v", err)
+	}
+	if _, err := conn.Write(fixture); err != nil {
+		t.Fatalf("failed to send the dnstap frames: %v", err)
+	}
+	if _, c, err := readFrame(r); err != nil || c == nil || c.ctype != fstrmControlFinish {
+		t.Fatalf("did not receive the FINISH frame: %v", err)
+	}
+
+	cancel()
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("failed to serve the dnstap connection: %v", res.err)
+	}
+	checkDnstapGraph(t, g, res.report)
+}
+
+func TestIngestDnstapFileContentType(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	var buf bytes.Buffer
+	_ = writeControlFrame(&buf, fstrmControlStart, "protobuf:other.Message")
+	if _, err := g.IngestDnstapFile(context.Background(), &buf, nil); err == nil {
+		t.Error("did not return an error for a stream with another content type")
+	}
+}
+
+func TestServeDnstapErrors(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "dnstap.sock"))
+	if err != nil {
+		t.Fatalf("failed to create the listener: %v", err)
+	}
+
+	type result struct {
+		report *PassiveReport
+		err    error
+	}
+	done := make(chan *result, 1)
+	go func() {
+		report, err := g.ServeDnstap(context.Background(), l, nil)
+		done <- &result{report: report, err: err}
+	}()
+
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to the listener: %v", err)
+	}
+	if err := writeControlFrame(conn, fstrmControlReady, "protobuf:other.Message"); err != nil {
+		t.Fatalf("failed to send the READY frame: %v", err)
+	}
+	// The server closes the connection after rejecting the content type
+	_, _ = io.Copy(io.Discard, conn)
+	conn.Close()
+
+	// The listener failing without a cancellation ends the server with the error
+	l.Close()
+	res := <-done
+	if res.err == nil {
+		t.Error("did not return the error of the listener")
+	}
+	if res.report.FailedStreams != 1 {
+		t.Errorf("expected one failed stream, got %+v", res.report)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// The errors returned by the graph wrap one of these values, so callers can use errors.Is to tell an
+// empty result from a failure. Context cancellation and deadline errors are returned without wrapping.
+var (
+	// ErrNotFound is returned when the graph does not contain the requested data.
+	ErrNotFound = errors.New("not found in the graph")
+	// ErrInvalidInput is returned when an argument is malformed or inconsistent.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrBackend is returned when the database fails to perform an operation. The cause is also wrapped.
+	ErrBackend = errors.New("database failure")
+)
+
+func notFound(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrNotFound, fmt.Sprintf(format, args...))
+}
+
+func invalidInput(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidInput, fmt.Sprintf(format, args...))
+}
+
+// inputError wraps the error returned while parsing an argument with ErrInvalidInput.
+func inputError(err error) error {
+	return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+}
+
+// backendError wraps the error returned by the database with ErrBackend. The errors already wrapping
+// one of the values above, such as those returned from within a transaction, are returned unchanged.
+func backendError(err error) error {
+	if err == nil || errors.Is(err, ErrBackend) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidInput) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrBackend, err)
+}
@@ -0,0 +1,97 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.owasp.org", "192.0.2.1")
+	_, _ = g.UpsertAS(ctx, 64496, "EXAMPLE-AS")
+
+	if _, err := g.NamesToAddrs(ctx, time.Time{}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput without names: %v", err)
+	}
+	if _, err := g.NamesToAddrs(ctx, time.Time{}, "missing.owasp.org"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown name: %v", err)
+	}
+	if _, err := g.UpsertAddress(ctx, "192.0.2"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a malformed address: %v", err)
+	}
+	if _, err := g.NamesToAddrsInRange(ctx, TimeRange{Since: time.Now(), Until: time.Now().Add(-time.Hour)},
+		"www.owasp.org"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for an inverted time range: %v", err)
+	}
+
+	if _, err := g.ReadASDescription(ctx, 64511, time.Time{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown AS: %v", err)
+	}
+	if desc, err := g.ReadASDescription(ctx, 64496, time.Time{}); err != nil || desc != "EXAMPLE-AS" {
+		t.Errorf("failed to read the AS description: %v", err)
+	}
+	if prefixes, err := g.ReadASPrefixes(ctx, 64496, time.Time{}); err != nil || len(prefixes) != 0 {
+		t.Errorf("expected no prefixes and no error for an AS without announcements: %v", err)
+	}
+	if _, err := g.ReadASPrefixes(ctx, 64511, time.Time{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown AS: %v", err)
+	}
+
+	// A failing database is distinguished from an empty result, and the cause is kept
+	sqlDB, err := g.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+
+	_, err = g.ReadASDescription(ctx, 64496, time.Time{})
+	if !errors.Is(err, ErrBackend) || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("expected ErrBackend when the database is closed: %v", err)
+	}
+	if _, err := g.NamesToAddrs(ctx, time.Time{}, "www.owasp.org"); !errors.Is(err, ErrBackend) {
+		t.Errorf("expected ErrBackend when the database is closed: %v", err)
+	}
+}
+
+func TestBackendErrors(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.owasp.org", "192.0.2.1")
+	s, err := g.StartSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, err := g.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+
+	now := time.Now()
+	for name, err := range map[string]error{
+		"StartSession":   func() error { _, err := g.StartSession(ctx, "tag"); return err }(),
+		"FinishSession":  g.FinishSession(ctx, s.ID),
+		"ReadSessions":   func() error { _, err := g.ReadSessions(ctx); return err }(),
+		"Diff":           func() error { _, err := g.Diff(ctx, now.Add(-time.Hour), now); return err }(),
+		"Prune":          func() error { _, err := g.Prune(ctx, &PrunePolicy{}); return err }(),
+		"RemoveFQDN":     g.RemoveFQDN(ctx, "www.owasp.org", nil),
+		"RemoveNetblock": g.RemoveNetblock(ctx, "192.0.2.0/24", nil),
+	} {
+		if !errors.Is(err, ErrBackend) {
+			t.Errorf("%s: expected ErrBackend when the database is closed: %v", name, err)
+		}
+	}
+}
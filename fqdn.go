@@ -15,99 +15,142 @@ import (
 
 // UpsertFQDN adds a fully qualified domain name to the graph.
 func (g *Graph) UpsertFQDN(ctx context.Context, name string) (*types.Asset, error) {
+	return g.UpsertFQDNAt(ctx, name, time.Time{})
+}
+
+// UpsertFQDNAt adds a fully qualified domain name to the graph that was observed at the provided time.
+// The first seen / last seen range of the name is extended to include the observation.
+func (g *Graph) UpsertFQDNAt(ctx context.Context, name string, at time.Time) (*types.Asset, error) {
 	d, err := publicsuffix.EffectiveTLDPlusOne(name)
 	if err != nil {
-		return nil, err
+		return nil, inputError(err)
 	}
-	_, _ = g.DB.Create(nil, "", &domain.FQDN{Name: d})
+	_, _ = g.upsertAssetAt(ctx, &domain.FQDN{Name: d}, at)
 
-	return g.DB.Create(nil, "", &domain.FQDN{Name: name})
+	return g.upsertAssetAt(ctx, &domain.FQDN{Name: name}, at)
 }
 
 // UpsertCNAME adds the FQDNs and CNAME record between them to the graph.
 func (g *Graph) UpsertCNAME(ctx context.Context, fqdn, target string) error {
-	return g.insertAlias(ctx, fqdn, target, "cname_record")
+	return g.insertAlias(ctx, fqdn, target, "cname_record", time.Time{})
+}
+
+// UpsertCNAMEAt adds the FQDNs and CNAME record between them to the graph that were observed at the provided time.
+func (g *Graph) UpsertCNAMEAt(ctx context.Context, fqdn, target string, at time.Time) error {
+	return g.insertAlias(ctx, fqdn, target, "cname_record", at)
 }
 
 // IsCNAMENode returns true if the FQDN has a CNAME edge to another FQDN in the graph.
 func (g *Graph) IsCNAMENode(ctx context.Context, fqdn string, since time.Time) bool {
-	return g.checkForOutEdge(ctx, fqdn, "cname_record", since)
+	return g.checkForOutEdge(ctx, fqdn, "cname_record", TimeRange{Since: since})
 }
 
-func (g *Graph) insertAlias(ctx context.Context, fqdn, target, relation string) error {
-	fAsset, err := g.UpsertFQDN(ctx, fqdn)
+// IsCNAMENodeInRange returns true if the FQDN has a CNAME edge to another FQDN in the graph during the time range.
+func (g *Graph) IsCNAMENodeInRange(ctx context.Context, fqdn string, tr TimeRange) bool {
+	return g.checkForOutEdge(ctx, fqdn, "cname_record", tr)
+}
+
+func (g *Graph) insertAlias(ctx context.Context, fqdn, target, relation string, at time.Time) error {
+	fAsset, err := g.UpsertFQDNAt(ctx, fqdn, at)
 	if err != nil {
 		return err
 	}
 
-	tAsset, err := g.UpsertFQDN(ctx, target)
+	tAsset, err := g.UpsertFQDNAt(ctx, target, at)
 	if err != nil {
 		return err
 	}
 
-	_, err = g.DB.Create(fAsset, relation, tAsset.Asset)
-	return err
+	return g.linkAt(ctx, fAsset, relation, tAsset, at)
 }
 
 // UpsertPTR adds the FQDNs and PTR record between them to the graph.
 func (g *Graph) UpsertPTR(ctx context.Context, fqdn, target string) error {
-	return g.insertAlias(ctx, fqdn, target, "ptr_record")
+	return g.insertAlias(ctx, fqdn, target, "ptr_record", time.Time{})
+}
+
+// UpsertPTRAt adds the FQDNs and PTR record between them to the graph that were observed at the provided time.
+func (g *Graph) UpsertPTRAt(ctx context.Context, fqdn, target string, at time.Time) error {
+	return g.insertAlias(ctx, fqdn, target, "ptr_record", at)
 }
 
 // IsPTRNode returns true if the FQDN has a PTR edge to another FQDN in the graph.
 func (g *Graph) IsPTRNode(ctx context.Context, fqdn string, since time.Time) bool {
-	return g.checkForOutEdge(ctx, fqdn, "ptr_record", since)
+	return g.checkForOutEdge(ctx, fqdn, "ptr_record", TimeRange{Since: since})
+}
+
+// IsPTRNodeInRange returns true if the FQDN has a PTR edge to another FQDN in the graph during the time range.
+func (g *Graph) IsPTRNodeInRange(ctx context.Context, fqdn string, tr TimeRange) bool {
+	return g.checkForOutEdge(ctx, fqdn, "ptr_record", tr)
 }
 
 // UpsertSRV adds the FQDNs and SRV record between them to the graph.
 func (g *Graph) UpsertSRV(ctx context.Context, service, target string) error {
-	return g.insertAlias(ctx, service, target, "srv_record")
+	return g.insertAlias(ctx, service, target, "srv_record", time.Time{})
+}
+
+// UpsertSRVAt adds the FQDNs and SRV record between them to the graph that were observed at the provided time.
+func (g *Graph) UpsertSRVAt(ctx context.Context, service, target string, at time.Time) error {
+	return g.insertAlias(ctx, service, target, "srv_record", at)
 }
 
 // UpsertNS adds the FQDNs and NS record between them to the graph.
 func (g *Graph) UpsertNS(ctx context.Context, fqdn, target string) error {
-	return g.insertAlias(ctx, fqdn, target, "ns_record")
+	return g.insertAlias(ctx, fqdn, target, "ns_record", time.Time{})
+}
+
+// UpsertNSAt adds the FQDNs and NS record between them to the graph that were observed at the provided time.
+func (g *Graph) UpsertNSAt(ctx context.Context, fqdn, target string, at time.Time) error {
+	return g.insertAlias(ctx, fqdn, target, "ns_record", at)
 }
 
 // IsNSNode returns true if the FQDN has a NS edge pointing to it in the graph.
 func (g *Graph) IsNSNode(ctx context.Context, fqdn string, since time.Time) bool {
-	return g.checkForInEdge(ctx, fqdn, "ns_record", since)
+	return g.checkForInEdge(ctx, fqdn, "ns_record", TimeRange{Since: since})
+}
+
+// IsNSNodeInRange returns true if the FQDN has a NS edge pointing to it in the graph during the time range.
+func (g *Graph) IsNSNodeInRange(ctx context.Context, fqdn string, tr TimeRange) bool {
+	return g.checkForInEdge(ctx, fqdn, "ns_record", tr)
 }
 
 // UpsertMX adds the FQDNs and MX record between them to the graph.
 func (g *Graph) UpsertMX(ctx context.Context, fqdn, target string) error {
-	return g.insertAlias(ctx, fqdn, target, "mx_record")
+	return g.insertAlias(ctx, fqdn, target, "mx_record", time.Time{})
+}
+
+// UpsertMXAt adds the FQDNs and MX record between them to the graph that were observed at the provided time.
+func (g *Graph) UpsertMXAt(ctx context.Context, fqdn, target string, at time.Time) error {
+	return g.insertAlias(ctx, fqdn, target, "mx_record", at)
 }
 
 // IsMXNode returns true if the FQDN has a MX edge pointing to it in the graph.
 func (g *Graph) IsMXNode(ctx context.Context, fqdn string, since time.Time) bool {
-	return g.checkForInEdge(ctx, fqdn, "mx_record", since)
-}
-
-func (g *Graph) checkForInEdge(ctx context.Context, id, relation string, since time.Time) bool {
-	if assets, err := g.DB.FindByContent(&domain.FQDN{Name: id}, since); err == nil {
-		for _, a := range assets {
-			if fqdn, ok := a.Asset.(*domain.FQDN); ok && fqdn.Name == id {
-				if rels, err := g.DB.IncomingRelations(a, since, relation); err == nil && len(rels) > 0 {
-					return true
-				}
-				break
-			}
-		}
-	}
-	return false
-}
-
-func (g *Graph) checkForOutEdge(ctx context.Context, id, relation string, since time.Time) bool {
-	if assets, err := g.DB.FindByContent(&domain.FQDN{Name: id}, since); err == nil {
-		for _, a := range assets {
-			if fqdn, ok := a.Asset.(*domain.FQDN); ok && fqdn.Name == id {
-				if rels, err := g.DB.OutgoingRelations(a, since, relation); err == nil && len(rels) > 0 {
-					return true
-				}
-				break
-			}
-		}
+	return g.checkForInEdge(ctx, fqdn, "mx_record", TimeRange{Since: since})
+}
+
+// IsMXNodeInRange returns true if the FQDN has a MX edge pointing to it in the graph during the time range.
+func (g *Graph) IsMXNodeInRange(ctx context.Context, fqdn string, tr TimeRange) bool {
+	return g.checkForInEdge(ctx, fqdn, "mx_record", tr)
+}
+
+func (g *Graph) checkForInEdge(ctx context.Context, id, relation string, tr TimeRange) bool {
+	return g.checkForEdge(ctx, id, relation, "to_asset_id", tr)
+}
+
+func (g *Graph) checkForOutEdge(ctx context.Context, id, relation string, tr TimeRange) bool {
+	return g.checkForEdge(ctx, id, relation, "from_asset_id", tr)
+}
+
+func (g *Graph) checkForEdge(ctx context.Context, id, relation, column string, tr TimeRange) bool {
+	if tr.Validate() != nil {
+		return false
 	}
-	return false
+
+	query := "relations INNER JOIN assets ON relations." + column + " = assets.id WHERE assets.type = 'FQDN'" +
+		" AND assets.content->>'name' = '" + sqlEscape(id) + "' AND relations.type = '" + sqlEscape(relation) + "'" +
+		tr.constraint("relations") + tr.constraint("assets") + " LIMIT 1"
+
+	rels, err := g.relationQuery(ctx, query)
+	return err == nil && len(rels) > 0
 }
@@ -18,13 +18,25 @@ import (
 	migrate "github.com/rubenv/sql-migrate"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
+// netmapMigrationsTable is the table used to track the migrations applied to the netmap specific tables.
+const netmapMigrationsTable = "netmap_migrations"
+
+//go:embed migrations/sqlite3/*.sql
+var sqliteNetmapMigrations embed.FS
+
+//go:embed migrations/postgres/*.sql
+var pgNetmapMigrations embed.FS
+
 // Graph is the object for managing a network infrastructure link graph.
 type Graph struct {
 	DB     *db.AssetDB
+	db     *gorm.DB
 	dsn    string
 	dbtype repository.DBType
+	index  *prefixIndex
 }
 
 // NewGraph returns an intialized Graph object.
@@ -35,7 +47,7 @@ func NewGraph(system, path string, options string) *Graph {
 	switch system {
 	case "memory":
 		dbtype = repository.SQLite
-		dsn = fmt.Sprintf("file:sqlite%d?mode=memory&cache=shared", rand.Int31n(100))
+		dsn = fmt.Sprintf("file:sqlite%d?mode=memory&cache=shared", rand.Int63())
 	case "local":
 		dbtype = repository.SQLite
 		dsn = path
@@ -55,26 +67,32 @@ func NewGraph(system, path string, options string) *Graph {
 		DB:     store,
 		dsn:    dsn,
 		dbtype: dbtype,
+		index:  newPrefixIndex(),
 	}
 
-	var name string
-	var fs embed.FS
+	var name, root string
+	var fs, netmapfs embed.FS
 	var database gorm.Dialector
 	switch dbtype {
 	case repository.SQLite:
 		name = "sqlite3"
+		root = "migrations/sqlite3"
 		fs = sqlitemigrations.Migrations()
+		netmapfs = sqliteNetmapMigrations
 		database = sqlite.Open(g.dsn)
 	case repository.Postgres:
 		name = "postgres"
+		root = "migrations/postgres"
 		fs = pgmigrations.Migrations()
+		netmapfs = pgNetmapMigrations
 		database = postgres.Open(g.dsn)
 	}
 
-	sql, err := gorm.Open(database, &gorm.Config{})
+	sql, err := gorm.Open(database, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
 	if err != nil {
 		return nil
 	}
+	g.db = sql
 
 	migrationsSource := migrate.EmbedFileSystemMigrationSource{
 		FileSystem: fs,
@@ -90,6 +108,15 @@ func NewGraph(system, path string, options string) *Graph {
 	if err != nil {
 		panic(err)
 	}
+
+	netmapMigrations := migrate.MigrationSet{TableName: netmapMigrationsTable}
+	_, err = netmapMigrations.Exec(sqlDb, name, migrate.EmbedFileSystemMigrationSource{
+		FileSystem: netmapfs,
+		Root:       root,
+	}, migrate.Up)
+	if err != nil {
+		panic(err)
+	}
 	return g
 }
 
@@ -118,6 +145,15 @@ func teardownPostgres(dsn string) {
 		panic(err)
 	}
 
+	netmapMigrations := migrate.MigrationSet{TableName: netmapMigrationsTable}
+	_, err = netmapMigrations.Exec(sqlDb, "postgres", migrate.EmbedFileSystemMigrationSource{
+		FileSystem: pgNetmapMigrations,
+		Root:       "migrations/postgres",
+	}, migrate.Down)
+	if err != nil {
+		panic(err)
+	}
+
 	_, err = migrate.Exec(sqlDb, "postgres", migrationsSource, migrate.Down)
 	if err != nil {
 		panic(err)
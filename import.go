@@ -0,0 +1,261 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+)
+
+// ImportReport summarizes the outcome of importing scan results into the graph.
+type ImportReport struct {
+	// Lines is the number of non-empty lines read from the input.
+	Lines int
+	// Records is the number of records upserted into the graph.
+	Records int
+	// Duplicates is the number of records skipped since they were already imported from the same input.
+	Duplicates int
+	// Skipped contains the lines that could not be parsed or upserted.
+	Skipped []*SkippedLine
+}
+
+// SkippedLine identifies a line of the input that was not imported and the reason why.
+type SkippedLine struct {
+	Line   int
+	Reason string
+}
+
+func (r *ImportReport) skip(line int, err error) {
+	r.Skipped = append(r.Skipped, &SkippedLine{Line: line, Reason: err.Error()})
+}
+
+type massdnsLine struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Data   struct {
+		Answers []massdnsAnswer `json:"answers"`
+	} `json:"data"`
+}
+
+type massdnsAnswer struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// ImportMassDNS reads the NDJSON output of massdns ("-o J") and upserts each answer record into the graph.
+// Malformed lines and unsupported records are skipped and listed in the returned report.
+func (g *Graph) ImportMassDNS(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	report := new(ImportReport)
+	seen := make(map[string]struct{})
+
+	err := scanLines(ctx, r, func(n int, line []byte) {
+		report.Lines++
+
+		var entry massdnsLine
+		if err := json.Unmarshal(line, &entry); err != nil {
+			report.skip(n, err)
+			return
+		} else if entry.Name == "" {
+			report.skip(n, errors.New("the line does not contain a name"))
+			return
+		}
+
+		for _, ans := range entry.Data.Answers {
+			name := cleanName(ans.Name)
+			key := strings.Join([]string{ans.Type, name, ans.Data}, "|")
+			if _, found := seen[key]; found {
+				report.Duplicates++
+				continue
+			}
+
+			if err := g.upsertRecord(ctx, name, ans.Type, ans.Data, time.Time{}); err != nil {
+				report.skip(n, err)
+				continue
+			}
+			seen[key] = struct{}{}
+			report.Records++
+		}
+	})
+	return report, err
+}
+
+// upsertRecord adds the DNS resource record, provided in presentation format, to the graph.
+// When at is not zero, it is used as the time the record was observed.
+func (g *Graph) upsertRecord(ctx context.Context, name, rrtype, data string, at time.Time) error {
+	relation, target, err := parseRecord(name, rrtype, data)
+	if err != nil {
+		return err
+	}
+
+	from, err := g.UpsertFQDNAt(ctx, name, at)
+	if err != nil {
+		return err
+	}
+
+	var to *types.Asset
+	if relation == "a_record" || relation == "aaaa_record" {
+		to, err = g.UpsertAddressAt(ctx, target, at)
+	} else {
+		to, err = g.UpsertFQDNAt(ctx, target, at)
+	}
+	if err != nil {
+		return err
+	}
+
+	return g.linkAt(ctx, from, relation, to, at)
+}
+
+// parseRecord returns the relation and the target of the DNS resource record provided in presentation format.
+func parseRecord(name, rrtype, data string) (string, string, error) {
+	fields := strings.Fields(data)
+	if name == "" || len(fields) == 0 {
+		return "", "", invalidInput("the %s record for %s is missing data", rrtype, name)
+	}
+
+	var target string
+	var relation string
+	switch strings.ToUpper(rrtype) {
+	case "A":
+		relation = "a_record"
+	case "AAAA":
+		relation = "aaaa_record"
+	case "CNAME":
+		relation = "cname_record"
+	case "NS":
+		relation = "ns_record"
+	case "PTR":
+		relation = "ptr_record"
+	case "MX":
+		if len(fields) != 2 {
+			return "", "", invalidInput("the MX record for %s is malformed: %s", name, data)
+		}
+		relation = "mx_record"
+		target = fields[1]
+	case "SRV":
+		if len(fields) != 4 {
+			return "", "", invalidInput("the SRV record for %s is malformed: %s", name, data)
+		}
+		relation = "srv_record"
+		target = fields[3]
+	default:
+		return "", "", invalidInput("the %s record type is not supported", rrtype)
+	}
+	if target == "" {
+		target = fields[0]
+	}
+
+	if relation != "a_record" && relation != "aaaa_record" {
+		target = cleanName(target)
+	}
+	return relation, target, nil
+}
+
+type amassLine struct {
+	Name      string `json:"name"`
+	Domain    string `json:"domain"`
+	Addresses []struct {
+		IP   string `json:"ip"`
+		CIDR string `json:"cidr"`
+		ASN  int    `json:"asn"`
+		Desc string `json:"desc"`
+	} `json:"addresses"`
+}
+
+// ImportAmass reads the JSON enumeration output of Amass and upserts the names, addresses and
+// infrastructure into the graph. Malformed lines are skipped and listed in the returned report.
+func (g *Graph) ImportAmass(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	report := new(ImportReport)
+	seen := make(map[string]struct{})
+
+	err := scanLines(ctx, r, func(n int, line []byte) {
+		report.Lines++
+
+		var entry amassLine
+		if err := json.Unmarshal(line, &entry); err != nil {
+			report.skip(n, err)
+			return
+		}
+
+		name := cleanName(entry.Name)
+		if name == "" {
+			report.skip(n, errors.New("the line does not contain a name"))
+			return
+		}
+
+		if len(entry.Addresses) == 0 {
+			if _, found := seen[name]; found {
+				report.Duplicates++
+				return
+			}
+			if _, err := g.UpsertFQDN(ctx, name); err != nil {
+				report.skip(n, err)
+				return
+			}
+			seen[name] = struct{}{}
+			report.Records++
+			return
+		}
+
+		for _, addr := range entry.Addresses {
+			key := name + "|" + addr.IP
+			if _, found := seen[key]; found {
+				report.Duplicates++
+				continue
+			}
+
+			rrtype := "A"
+			if strings.Contains(addr.IP, ":") {
+				rrtype = "AAAA"
+			}
+			if err := g.upsertRecord(ctx, name, rrtype, addr.IP, time.Time{}); err != nil {
+				report.skip(n, err)
+				continue
+			}
+
+			if addr.ASN != 0 && addr.CIDR != "" {
+				if err := g.UpsertInfrastructure(ctx, addr.ASN, addr.Desc, addr.IP, addr.CIDR); err != nil {
+					report.skip(n, err)
+					continue
+				}
+			}
+			seen[key] = struct{}{}
+			report.Records++
+		}
+	})
+	return report, err
+}
+
+// scanLines calls fn for each non-empty line read from r, along with the line number.
+func scanLines(ctx context.Context, r io.Reader, fn func(n int, line []byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	var n int
+	for scanner.Scan() {
+		n++
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if line := scanner.Bytes(); len(strings.TrimSpace(string(line))) > 0 {
+			fn(n, line)
+		}
+	}
+	return scanner.Err()
+}
+
+// cleanName returns the name in lowercase and without the trailing dot.
+func cleanName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
@@ -0,0 +1,77 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestImportMassDNS(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	f, err := os.Open("testdata/massdns.ndjson")
+	if err != nil {
+		t.Fatalf("failed to open the fixture: %v", err)
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	report, err := g.ImportMassDNS(ctx, f)
+	if err != nil {
+		t.Fatalf("failed to import the massdns output: %v", err)
+	}
+	if report.Lines != 7 || report.Records != 5 || report.Duplicates != 2 || len(report.Skipped) != 2 {
+		t.Errorf("unexpected report: lines %d, records %d, duplicates %d, skipped %d",
+			report.Lines, report.Records, report.Duplicates, len(report.Skipped))
+	}
+
+	if pairs, err := g.NamesToAddrs(ctx, time.Time{}, "owasp.org"); err != nil || len(pairs) != 2 {
+		t.Errorf("failed to obtain the name / address pairs: %v", err)
+	}
+	if !g.IsCNAMENode(ctx, "www.owasp.org", time.Time{}) {
+		t.Error("the CNAME record was not imported")
+	}
+	if !g.IsMXNode(ctx, "aspmx.l.google.com", time.Time{}) {
+		t.Error("the MX record was not imported")
+	}
+	if !g.IsNSNode(ctx, "lucy.ns.cloudflare.com", time.Time{}) {
+		t.Error("the NS record was not imported")
+	}
+}
+
+func TestImportAmass(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	f, err := os.Open("testdata/amass.json")
+	if err != nil {
+		t.Fatalf("failed to open the fixture: %v", err)
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	report, err := g.ImportAmass(ctx, f)
+	if err != nil {
+		t.Fatalf("failed to import the Amass output: %v", err)
+	}
+	if report.Lines != 5 || report.Records != 3 || report.Duplicates != 1 || len(report.Skipped) != 2 {
+		t.Errorf("unexpected report: lines %d, records %d, duplicates %d, skipped %d",
+			report.Lines, report.Records, report.Duplicates, len(report.Skipped))
+	}
+
+	if pairs, err := g.NamesToAddrs(ctx, time.Time{}, "www.owasp.org"); err != nil || len(pairs) != 2 {
+		t.Errorf("failed to obtain the name / address pairs: %v", err)
+	}
+	if desc, _ := g.ReadASDescription(ctx, 13335, time.Time{}); desc != "CLOUDFLARENET - Cloudflare, Inc." {
+		t.Errorf("the infrastructure was not imported: %s", desc)
+	}
+	if prefixes, _ := g.ReadASPrefixes(ctx, 13335, time.Time{}); len(prefixes) != 2 {
+		t.Errorf("expected two announced prefixes, got %v", prefixes)
+	}
+}
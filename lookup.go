@@ -0,0 +1,137 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+// AddressLookup is the netblock and autonomous system covering an address in the graph.
+type AddressLookup struct {
+	// Netblock is the most specific announced netblock containing the address, or the most specific
+	// netblock containing the address when none of them are announced.
+	Netblock netip.Prefix
+	// ASN is the autonomous system that announces the netblock, or zero when it is not announced.
+	ASN int
+	// Description is the description of the autonomous system.
+	Description string
+}
+
+// LookupAddress performs a longest-prefix match of the address against the netblocks in the graph and returns
+// the netblock and the autonomous system that announces it. The address does not need to be in the graph.
+func (g *Graph) LookupAddress(ctx context.Context, addr string) (*AddressLookup, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, inputError(err)
+	}
+	ip = ip.Unmap()
+
+	if err := g.loadIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	g.index.RLock()
+	matches := g.index.match(ip)
+	g.index.RUnlock()
+	if len(matches) == 0 {
+		return nil, notFound("a netblock containing %s", addr)
+	}
+
+	ids := make([]uint64, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m.id)
+	}
+
+	var rows []struct {
+		Netblock uint64
+		ASN      int
+	}
+	if err := g.db.WithContext(ctx).Raw(`SELECT relations.to_asset_id AS netblock, asns.content->>'number' AS asn
+		FROM relations INNER JOIN assets AS asns ON relations.from_asset_id = asns.id
+		WHERE relations.type = 'announces' AND asns.type = 'ASN' AND relations.to_asset_id IN ?
+		ORDER BY relations.last_seen DESC, relations.id DESC`, ids).Scan(&rows).Error; err != nil {
+		return nil, backendError(err)
+	}
+
+	announcers := make(map[uint64]int, len(rows))
+	for _, row := range rows {
+		if _, found := announcers[row.Netblock]; !found {
+			announcers[row.Netblock] = row.ASN
+		}
+	}
+
+	result := &AddressLookup{Netblock: matches[len(matches)-1].prefix}
+	for i := len(matches) - 1; i >= 0; i-- {
+		if asn, found := announcers[matches[i].id]; found {
+			result.Netblock, result.ASN = matches[i].prefix, asn
+			break
+		}
+	}
+	if result.ASN == 0 {
+		return result, nil
+	}
+
+	desc, err := g.ReadASDescription(ctx, result.ASN, time.Time{})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	result.Description = desc
+	return result, nil
+}
+
+// loadIndex builds the netblock index from the database when it has not been loaded.
+func (g *Graph) loadIndex(ctx context.Context) error {
+	g.index.RLock()
+	loaded := g.index.loaded
+	g.index.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	// The lock is held while reading, so netblocks upserted meanwhile are not missed
+	g.index.Lock()
+	defer g.index.Unlock()
+	if g.index.loaded {
+		return nil
+	}
+
+	var rows []containedRow
+	if err := g.db.WithContext(ctx).Raw("SELECT id, type, content->>'cidr' AS cidr FROM assets" +
+		" WHERE type = 'Netblock'").Scan(&rows).Error; err != nil {
+		return backendError(err)
+	}
+
+	for i := range rows {
+		if prefix, ok := rows[i].prefix(); ok {
+			g.index.insert(prefix, rows[i].ID)
+		}
+	}
+	g.index.loaded = true
+	return nil
+}
+
+// indexNetblock keeps the netblock index in sync with the upserted netblock.
+func (g *Graph) indexNetblock(a *types.Asset, nb *network.Netblock) error {
+	id, err := strconv.ParseUint(a.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	g.index.Lock()
+	defer g.index.Unlock()
+
+	// An index that has not been loaded will read the netblock from the database
+	if g.index.loaded {
+		g.index.insert(nb.Cidr, id)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+func TestLookupAddress(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertInfrastructure(ctx, 64496, "EXAMPLE-AS", "198.51.100.1", "198.51.0.0/16")
+	_ = g.UpsertInfrastructure(ctx, 64497, "EXAMPLE-CUSTOMER", "198.51.100.2", "198.51.100.0/24")
+	_, _ = g.UpsertNetblock(ctx, "198.51.100.128/25")
+	_ = g.UpsertInfrastructure(ctx, 64498, "EXAMPLE-V6", "2001:db8::1", "2001:db8::/32")
+
+	tests := []struct {
+		addr     string
+		netblock string
+		asn      int
+		desc     string
+	}{
+		{"198.51.100.200", "198.51.100.0/24", 64497, "EXAMPLE-CUSTOMER"},
+		{"198.51.100.7", "198.51.100.0/24", 64497, "EXAMPLE-CUSTOMER"},
+		{"198.51.7.7", "198.51.0.0/16", 64496, "EXAMPLE-AS"},
+		{"2001:db8:ffff::1", "2001:db8::/32", 64498, "EXAMPLE-V6"},
+	}
+	for _, test := range tests {
+		got, err := g.LookupAddress(ctx, test.addr)
+		if err != nil {
+			t.Errorf("failed to look up %s: %v", test.addr, err)
+		} else if got.Netblock.String() != test.netblock || got.ASN != test.asn || got.Description != test.desc {
+			t.Errorf("unexpected result for %s: %+v", test.addr, got)
+		}
+	}
+
+	if _, err := g.LookupAddress(ctx, "203.0.113.1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an address outside the netblocks: %v", err)
+	}
+	if _, err := g.LookupAddress(ctx, "203.0.113"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a malformed address: %v", err)
+	}
+
+	// The loaded index is kept in sync with the netblocks upserted and removed afterwards
+	_ = g.UpsertInfrastructure(ctx, 64499, "EXAMPLE-MORE-SPECIFIC", "198.51.100.201", "198.51.100.192/26")
+	if got, err := g.LookupAddress(ctx, "198.51.100.200"); err != nil || got.ASN != 64499 {
+		t.Errorf("the index did not include the upserted netblock: %+v, %v", got, err)
+	}
+	if err := g.RemoveNetblock(ctx, "198.51.100.192/26", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := g.LookupAddress(ctx, "198.51.100.200"); err != nil || got.ASN != 64497 {
+		t.Errorf("the index included the removed netblock: %+v, %v", got, err)
+	}
+
+	// A netblock that is not announced is returned when no covering netblock is announced
+	_, _ = g.UpsertNetblock(ctx, "203.0.113.0/24")
+	if got, err := g.LookupAddress(ctx, "203.0.113.1"); err != nil || got.Netblock.String() != "203.0.113.0/24" || got.ASN != 0 {
+		t.Errorf("unexpected result for an unannounced netblock: %+v, %v", got, err)
+	}
+}
+
+func TestPrefixIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	var prefixes []netip.Prefix
+	idx := newPrefixIndex()
+	for i := 0; i < 2000; i++ {
+		var b [4]byte
+		rng.Read(b[:])
+		// Keep the prefixes within a small range, so many of them are nested
+		b[0] = 10
+		b[1] &= 0x3
+
+		p := netip.PrefixFrom(netip.AddrFrom4(b), 8+rng.Intn(25)).Masked()
+		prefixes = append(prefixes, p)
+		idx.insert(p, uint64(i+1))
+	}
+	for _, p := range prefixes[:200] {
+		idx.remove(p)
+	}
+	removed := make(map[netip.Prefix]bool)
+	for _, p := range prefixes[:200] {
+		removed[p] = true
+	}
+
+	for i := 0; i < 2000; i++ {
+		var b [4]byte
+		rng.Read(b[:])
+		b[0] = 10
+		b[1] &= 0x3
+		addr := netip.AddrFrom4(b)
+
+		var want netip.Prefix
+		for _, p := range prefixes {
+			if !removed[p] && p.Contains(addr) && (!want.IsValid() || p.Bits() > want.Bits()) {
+				want = p
+			}
+		}
+
+		matches := idx.match(addr)
+		if !want.IsValid() {
+			if len(matches) != 0 {
+				t.Fatalf("unexpected match for %s: %s", addr, matches[len(matches)-1].prefix)
+			}
+			continue
+		}
+		if len(matches) == 0 || matches[len(matches)-1].prefix != want {
+			t.Fatalf("the longest prefix match for %s was not %s", addr, want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+-- +migrate Up
+
+CREATE TABLE IF NOT EXISTS sessions(
+    id VARCHAR(255) PRIMARY KEY,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP);
+
+CREATE TABLE IF NOT EXISTS session_tags(
+    session_id VARCHAR(255) NOT NULL,
+    tag VARCHAR(255) NOT NULL,
+    PRIMARY KEY(session_id, tag),
+    CONSTRAINT fk_session
+        FOREIGN KEY (session_id)
+        REFERENCES sessions(id)
+        ON DELETE CASCADE);
+
+CREATE INDEX idx_sessions_started_at ON sessions (started_at);
+
+-- +migrate Down
+
+DROP INDEX idx_sessions_started_at;
+DROP TABLE session_tags;
+DROP TABLE sessions;
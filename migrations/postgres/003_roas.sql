@@ -0,0 +1,16 @@
+-- +migrate Up
+
+CREATE TABLE IF NOT EXISTS roas(
+    id SERIAL PRIMARY KEY,
+    asn BIGINT NOT NULL,
+    prefix VARCHAR(255) NOT NULL,
+    max_length INT NOT NULL,
+    trust_anchor VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+
+CREATE INDEX idx_roas_prefix ON roas (prefix);
+
+-- +migrate Down
+
+DROP INDEX idx_roas_prefix;
+DROP TABLE roas;
@@ -0,0 +1,33 @@
+-- +migrate Up
+
+CREATE TABLE IF NOT EXISTS asset_sources(
+    asset_id INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    event TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(asset_id, source, event),
+    FOREIGN KEY(asset_id) REFERENCES assets(id) ON DELETE CASCADE);
+
+CREATE TABLE IF NOT EXISTS relation_sources(
+    relation_id INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    event TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(relation_id, source, event),
+    FOREIGN KEY(relation_id) REFERENCES relations(id) ON DELETE CASCADE);
+
+CREATE INDEX idx_asset_sources_source ON asset_sources (source);
+CREATE INDEX idx_asset_sources_event ON asset_sources (event);
+CREATE INDEX idx_relation_sources_source ON relation_sources (source);
+CREATE INDEX idx_relation_sources_event ON relation_sources (event);
+
+-- +migrate Down
+
+DROP INDEX idx_relation_sources_event;
+DROP INDEX idx_relation_sources_source;
+DROP INDEX idx_asset_sources_event;
+DROP INDEX idx_asset_sources_source;
+DROP TABLE relation_sources;
+DROP TABLE asset_sources;
@@ -0,0 +1,20 @@
+-- +migrate Up
+
+CREATE TABLE IF NOT EXISTS sessions(
+    id TEXT PRIMARY KEY,
+    started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    finished_at DATETIME);
+
+CREATE TABLE IF NOT EXISTS session_tags(
+    session_id TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY(session_id, tag),
+    FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE);
+
+CREATE INDEX idx_sessions_started_at ON sessions (started_at);
+
+-- +migrate Down
+
+DROP INDEX idx_sessions_started_at;
+DROP TABLE session_tags;
+DROP TABLE sessions;
@@ -0,0 +1,16 @@
+-- +migrate Up
+
+CREATE TABLE IF NOT EXISTS roas(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    asn BIGINT NOT NULL,
+    prefix TEXT NOT NULL,
+    max_length INTEGER NOT NULL,
+    trust_anchor TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+
+CREATE INDEX idx_roas_prefix ON roas (prefix);
+
+-- +migrate Down
+
+DROP INDEX idx_roas_prefix;
+DROP TABLE roas;
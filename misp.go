@@ -0,0 +1,179 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MISPEvent is the JSON document accepted by the MISP events API.
+type MISPEvent struct {
+	Event MISPEventBody `json:"Event"`
+}
+
+// MISPEventBody contains the properties, attributes and objects of a MISP event.
+type MISPEventBody struct {
+	UUID          string           `json:"uuid"`
+	Info          string           `json:"info"`
+	Date          string           `json:"date"`
+	Timestamp     string           `json:"timestamp"`
+	ThreatLevelID string           `json:"threat_level_id"`
+	Analysis      string           `json:"analysis"`
+	Distribution  string           `json:"distribution"`
+	Published     bool             `json:"published"`
+	Attributes    []*MISPAttribute `json:"Attribute"`
+	Objects       []*MISPObject    `json:"Object"`
+}
+
+// MISPAttribute is a single MISP attribute, either on the event or within an object.
+type MISPAttribute struct {
+	UUID           string `json:"uuid"`
+	Type           string `json:"type"`
+	Category       string `json:"category"`
+	Value          string `json:"value"`
+	ObjectRelation string `json:"object_relation,omitempty"`
+	Comment        string `json:"comment,omitempty"`
+	ToIDS          bool   `json:"to_ids"`
+	Timestamp      string `json:"timestamp"`
+}
+
+// MISPObject is a MISP object built from one of the default object templates.
+type MISPObject struct {
+	UUID         string           `json:"uuid"`
+	Name         string           `json:"name"`
+	MetaCategory string           `json:"meta-category"`
+	Description  string           `json:"description"`
+	Timestamp    string           `json:"timestamp"`
+	Attributes   []*MISPAttribute `json:"Attribute"`
+}
+
+// BuildMISPEvent returns a MISP event containing a domain|ip attribute for each name / address pair
+// returned by NamesToAddrs, and an asn object for each autonomous system announcing those addresses.
+func (g *Graph) BuildMISPEvent(ctx context.Context, info string, since time.Time, names ...string) (*MISPEvent, error) {
+	pairs, err := g.NamesToAddrs(ctx, since, names...)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	event := &MISPEvent{
+		Event: MISPEventBody{
+			UUID:          uuid.New().String(),
+			Info:          info,
+			Date:          now.Format("2006-01-02"),
+			Timestamp:     ts,
+			ThreatLevelID: "4",
+			Analysis:      "2",
+			Distribution:  "0",
+		},
+	}
+
+	infos := make(map[string]*addrInfo)
+	asnNetblocks := make(map[int]map[string]struct{})
+	for _, p := range pairs {
+		addr := p.Addr.Address.String()
+
+		event.Event.Attributes = append(event.Event.Attributes, &MISPAttribute{
+			UUID:      uuid.New().String(),
+			Type:      "domain|ip",
+			Category:  "Network activity",
+			Value:     p.FQDN.Name + "|" + addr,
+			Timestamp: ts,
+		})
+
+		if _, found := infos[addr]; found {
+			continue
+		}
+		ai, err := g.readAddrInfo(ctx, p.Addr, since)
+		if err != nil {
+			return nil, err
+		}
+		infos[addr] = ai
+
+		if ai.asn == 0 {
+			continue
+		}
+		if _, found := asnNetblocks[ai.asn]; !found {
+			asnNetblocks[ai.asn] = make(map[string]struct{})
+		}
+		if ai.netblock != "" {
+			asnNetblocks[ai.asn][ai.netblock] = struct{}{}
+		}
+	}
+
+	asns := make([]int, 0, len(asnNetblocks))
+	for asn := range asnNetblocks {
+		asns = append(asns, asn)
+	}
+	sort.Ints(asns)
+
+	for _, asn := range asns {
+		obj := &MISPObject{
+			UUID:         uuid.New().String(),
+			Name:         "asn",
+			MetaCategory: "network",
+			Description:  "Autonomous system",
+			Timestamp:    ts,
+		}
+		obj.Attributes = append(obj.Attributes, &MISPAttribute{
+			UUID:           uuid.New().String(),
+			Type:           "AS",
+			Category:       "Network activity",
+			Value:          "AS" + strconv.Itoa(asn),
+			ObjectRelation: "asn",
+			Timestamp:      ts,
+		})
+
+		if desc, err := g.ReadASDescription(ctx, asn, since); err == nil && desc != "" {
+			obj.Attributes = append(obj.Attributes, &MISPAttribute{
+				UUID:           uuid.New().String(),
+				Type:           "text",
+				Category:       "Other",
+				Value:          desc,
+				ObjectRelation: "description",
+				Timestamp:      ts,
+			})
+		}
+
+		netblocks := make([]string, 0, len(asnNetblocks[asn]))
+		for netblock := range asnNetblocks[asn] {
+			netblocks = append(netblocks, netblock)
+		}
+		sort.Strings(netblocks)
+
+		for _, netblock := range netblocks {
+			obj.Attributes = append(obj.Attributes, &MISPAttribute{
+				UUID:           uuid.New().String(),
+				Type:           "ip-src",
+				Category:       "Network activity",
+				Value:          netblock,
+				ObjectRelation: "subnet-announced",
+				Timestamp:      ts,
+			})
+		}
+		event.Event.Objects = append(event.Event.Objects, obj)
+	}
+	return event, nil
+}
+
+// ExportMISP writes the MISP event produced by BuildMISPEvent to w as JSON.
+func (g *Graph) ExportMISP(ctx context.Context, w io.Writer, info string, since time.Time, names ...string) error {
+	event, err := g.BuildMISPEvent(ctx, info, since, names...)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(event)
+}
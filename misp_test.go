@@ -0,0 +1,92 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMISP(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.owasp.org", "192.168.1.1")
+	_ = g.UpsertA(ctx, "mail.owasp.org", "192.168.1.2")
+	_ = g.UpsertInfrastructure(ctx, 667, "Great AS", "192.168.1.1", "192.168.1.0/24")
+	_ = g.UpsertInfrastructure(ctx, 667, "Great AS", "192.168.1.2", "192.168.1.0/24")
+
+	var buf bytes.Buffer
+	if err := g.ExportMISP(ctx, &buf, "OWASP infrastructure", time.Time{}, "www.owasp.org", "mail.owasp.org"); err != nil {
+		t.Fatalf("failed to export the MISP event: %v", err)
+	}
+
+	var event MISPEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("failed to unmarshal the MISP event: %v", err)
+	}
+	if event.Event.Info != "OWASP infrastructure" || event.Event.UUID == "" {
+		t.Errorf("the event properties were not populated: %+v", event.Event)
+	}
+
+	values := make(map[string]struct{})
+	for _, attr := range event.Event.Attributes {
+		if attr.Type != "domain|ip" {
+			t.Errorf("unexpected attribute type: %s", attr.Type)
+		}
+		values[attr.Value] = struct{}{}
+	}
+	for _, want := range []string{"www.owasp.org|192.168.1.1", "mail.owasp.org|192.168.1.2"} {
+		if _, found := values[want]; !found {
+			t.Errorf("the %s attribute was missing", want)
+		}
+	}
+
+	if len(event.Event.Objects) != 1 {
+		t.Fatalf("expected one asn object, got %d", len(event.Event.Objects))
+	}
+	relations := make(map[string]string)
+	for _, attr := range event.Event.Objects[0].Attributes {
+		relations[attr.ObjectRelation] = attr.Value
+	}
+	if relations["asn"] != "AS667" || relations["description"] != "Great AS" ||
+		relations["subnet-announced"] != "192.168.1.0/24" {
+		t.Errorf("the asn object was not populated properly: %v", relations)
+	}
+
+	if _, err := g.BuildMISPEvent(ctx, "", time.Time{}, "doesnot.exist"); err == nil {
+		t.Error("did not return an error when provided a name not existing in the graph")
+	}
+}
+
+func TestMISPNestedNetblock(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.example.com", "10.0.0.1")
+	_ = g.UpsertInfrastructure(ctx, 64500, "EXAMPLE", "10.0.0.1", "10.0.0.0/16")
+	_, _ = g.UpsertNetblock(ctx, "10.0.0.0/24")
+
+	event, err := g.BuildMISPEvent(ctx, "nested", time.Time{}, "www.example.com")
+	if err != nil {
+		t.Fatalf("failed to build the MISP event: %v", err)
+	}
+	if len(event.Event.Objects) != 1 {
+		t.Fatalf("expected one asn object, got %d", len(event.Event.Objects))
+	}
+
+	relations := make(map[string]string)
+	for _, attr := range event.Event.Objects[0].Attributes {
+		relations[attr.ObjectRelation] = attr.Value
+	}
+	if relations["asn"] != "AS64500" || relations["subnet-announced"] != "10.0.0.0/16" {
+		t.Errorf("the asn object did not use the announcing supernet: %v", relations)
+	}
+}
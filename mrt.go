@@ -0,0 +1,380 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// The MRT record types and subtypes of RFC 6396 and RFC 8050 read by ImportMRT.
+const (
+	mrtTableDumpV2 = 13
+
+	mrtPeerIndexTable        = 1
+	mrtRIBIPv4Unicast        = 2
+	mrtRIBIPv6Unicast        = 4
+	mrtRIBIPv4UnicastAddPath = 8
+	mrtRIBIPv6UnicastAddPath = 10
+)
+
+// The BGP path attribute and AS_PATH segment types of RFC 4271.
+const (
+	bgpAttrASPath        = 2
+	bgpAttrExtendedLen   = 0x10
+	bgpSegmentASSet      = 1
+	bgpSegmentASSequence = 2
+)
+
+// mrtMaxRecordLen limits the memory allocated for a single record of a malformed file.
+const mrtMaxRecordLen = 16 * 1024 * 1024
+
+// MRTReport summarizes the outcome of importing an MRT RIB dump into the graph.
+type MRTReport struct {
+	// DumpTime is the time the RIB was dumped, which is used as the observation time.
+	DumpTime time.Time
+	// Records is the number of MRT records read from the input.
+	Records int
+	// Skipped is the number of records that are not TABLE_DUMP_V2 unicast RIB entries.
+	Skipped int
+	// Peers is the number of peers listed in the peer index table.
+	Peers int
+	// Routes is the number of RIB entries, which is one per peer for each prefix.
+	Routes int
+	// Prefixes is the number of distinct prefixes in the dump.
+	Prefixes int
+	// Announcements is the number of distinct prefix and origin AS pairs upserted into the graph.
+	Announcements int
+	// Adjacencies is the number of distinct AS pairs found next to each other in the AS paths.
+	Adjacencies int
+}
+
+// mrtRoute is a prefix and the AS path of a RIB entry.
+type mrtRoute struct {
+	prefix netip.Prefix
+	// segments of the AS path, where AS_SET segments are marked by set.
+	segments []mrtSegment
+}
+
+type mrtSegment struct {
+	set  bool
+	asns []int
+}
+
+// ImportMRT reads a TABLE_DUMP_V2 MRT RIB dump, such as those published by RouteViews and RIPE RIS, and upserts
+// the netblocks, announces edges from their origin autonomous systems and adjacent_to edges between the autonomous
+// systems found next to each other in the AS paths. The dump time is used as the observation time. The input can
+// be compressed with gzip or bzip2. Records of other types are skipped.
+func (g *Graph) ImportMRT(ctx context.Context, r io.Reader) (*MRTReport, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(3); err == nil {
+		switch {
+		case magic[0] == 0x1f && magic[1] == 0x8b:
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				return nil, invalidInput("the gzip stream is malformed: %v", err)
+			}
+			defer zr.Close()
+			br = bufio.NewReader(zr)
+		case string(magic) == "BZh":
+			br = bufio.NewReader(bzip2.NewReader(br))
+		}
+	}
+
+	report := new(MRTReport)
+	origins := make(map[netip.Prefix]map[int]struct{})
+	adjacent := make(map[[2]int]struct{})
+
+	header := make([]byte, 12)
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			break
+		} else if err != nil {
+			return report, invalidInput("the MRT record header is truncated: %v", err)
+		}
+
+		ts := time.Unix(int64(binary.BigEndian.Uint32(header[0:4])), 0).UTC()
+		rtype := binary.BigEndian.Uint16(header[4:6])
+		subtype := binary.BigEndian.Uint16(header[6:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		if length > mrtMaxRecordLen {
+			return report, invalidInput("the MRT record %d is %d bytes long", report.Records+1, length)
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return report, invalidInput("the MRT record %d is truncated: %v", report.Records+1, err)
+		}
+		report.Records++
+
+		if rtype != mrtTableDumpV2 {
+			report.Skipped++
+			continue
+		}
+
+		var err error
+		switch subtype {
+		case mrtPeerIndexTable:
+			report.Peers, err = mrtPeerCount(body)
+			report.DumpTime = ts
+		case mrtRIBIPv4Unicast, mrtRIBIPv6Unicast, mrtRIBIPv4UnicastAddPath, mrtRIBIPv6UnicastAddPath:
+			if report.DumpTime.IsZero() {
+				report.DumpTime = ts
+			}
+
+			var routes []*mrtRoute
+			routes, err = mrtRIB(body, subtype)
+			for _, route := range routes {
+				report.Routes++
+				addMRTRoute(route, origins, adjacent)
+			}
+		default:
+			report.Skipped++
+		}
+		if err != nil {
+			return report, invalidInput("the MRT record %d is malformed: %v", report.Records, err)
+		}
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(origins))
+	for prefix := range origins {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return comparePrefixes(prefixes[i], prefixes[j]) < 0 })
+	report.Prefixes = len(prefixes)
+
+	imp := g.newDatasetImporter(ctx, &ImportReport{}, report.DumpTime)
+	for _, prefix := range prefixes {
+		asns := sortedASNs(origins[prefix])
+		imp.add(&datasetEntry{prefix: prefix, asns: asns})
+		report.Announcements += len(asns)
+	}
+
+	pairs := make([][2]int, 0, len(adjacent))
+	for pair := range adjacent {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	for _, pair := range pairs {
+		imp.add(&datasetEntry{asns: []int{pair[0]}, adjacent: []int{pair[1]}})
+	}
+	report.Adjacencies = len(pairs)
+
+	return report, imp.close(ctx.Err())
+}
+
+// addMRTRoute records the origin autonomous systems of the route's prefix and the adjacent autonomous systems of
+// its AS path. The origins are the last AS of the path, or each AS of an AS_SET ending the path.
+func addMRTRoute(route *mrtRoute, origins map[netip.Prefix]map[int]struct{}, adjacent map[[2]int]struct{}) {
+	if len(route.segments) == 0 {
+		return
+	}
+
+	var originASNs []int
+	if last := route.segments[len(route.segments)-1]; last.set {
+		originASNs = last.asns
+	} else if len(last.asns) > 0 {
+		originASNs = last.asns[len(last.asns)-1:]
+	}
+	for _, asn := range originASNs {
+		if _, found := origins[route.prefix]; !found {
+			origins[route.prefix] = make(map[int]struct{})
+		}
+		origins[route.prefix][asn] = struct{}{}
+	}
+
+	for _, seg := range route.segments {
+		if seg.set {
+			continue
+		}
+		// Prepending repeats an AS, which is not an adjacency
+		for i := 1; i < len(seg.asns); i++ {
+			if a, b := seg.asns[i-1], seg.asns[i]; a != b {
+				adjacent[[2]int{a, b}] = struct{}{}
+			}
+		}
+	}
+}
+
+// mrtPeerCount returns the number of peers in the PEER_INDEX_TABLE record.
+func mrtPeerCount(body []byte) (int, error) {
+	d := &mrtDecoder{buf: body}
+
+	d.skip(4) // Collector BGP ID
+	d.skip(int(d.uint16()))
+	count := int(d.uint16())
+
+	for i := 0; i < count && d.err == nil; i++ {
+		ptype := d.uint8()
+		d.skip(4) // Peer BGP ID
+		if ptype&0x01 != 0 {
+			d.skip(16)
+		} else {
+			d.skip(4)
+		}
+		if ptype&0x02 != 0 {
+			d.skip(4)
+		} else {
+			d.skip(2)
+		}
+	}
+	return count, d.err
+}
+
+// mrtRIB returns the routes of the RIB_IPV4_UNICAST or RIB_IPV6_UNICAST record, and their ADD-PATH variants.
+func mrtRIB(body []byte, subtype uint16) ([]*mrtRoute, error) {
+	d := &mrtDecoder{buf: body}
+	d.skip(4) // Sequence number
+
+	bits := int(d.uint8())
+	addrLen := 4
+	if subtype == mrtRIBIPv6Unicast || subtype == mrtRIBIPv6UnicastAddPath {
+		addrLen = 16
+	}
+	if bits > addrLen*8 {
+		return nil, fmt.Errorf("the prefix length %d is too long", bits)
+	}
+
+	addr := make([]byte, addrLen)
+	copy(addr, d.bytes((bits+7)/8))
+	ip, _ := netip.AddrFromSlice(addr)
+	prefix := netip.PrefixFrom(ip, bits).Masked()
+
+	count := int(d.uint16())
+	var routes []*mrtRoute
+	for i := 0; i < count && d.err == nil; i++ {
+		d.skip(2) // Peer index
+		d.skip(4) // Originated time
+		if subtype == mrtRIBIPv4UnicastAddPath || subtype == mrtRIBIPv6UnicastAddPath {
+			d.skip(4) // Path identifier
+		}
+
+		attrs := d.bytes(int(d.uint16()))
+		if d.err != nil {
+			break
+		}
+
+		segments, err := mrtASPath(attrs)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, &mrtRoute{prefix: prefix, segments: segments})
+	}
+	return routes, d.err
+}
+
+// mrtASPath returns the segments of the AS_PATH attribute, which always holds four byte AS numbers in TABLE_DUMP_V2.
+func mrtASPath(attrs []byte) ([]mrtSegment, error) {
+	d := &mrtDecoder{buf: attrs}
+
+	for d.err == nil && len(d.buf) > 0 {
+		flags := d.uint8()
+		atype := d.uint8()
+
+		var length int
+		if flags&bgpAttrExtendedLen != 0 {
+			length = int(d.uint16())
+		} else {
+			length = int(d.uint8())
+		}
+
+		value := d.bytes(length)
+		if d.err != nil || atype != bgpAttrASPath {
+			continue
+		}
+
+		var segments []mrtSegment
+		p := &mrtDecoder{buf: value}
+		for p.err == nil && len(p.buf) > 0 {
+			stype := p.uint8()
+			n := int(p.uint8())
+
+			seg := mrtSegment{set: stype == bgpSegmentASSet}
+			for i := 0; i < n && p.err == nil; i++ {
+				seg.asns = append(seg.asns, int(p.uint32()))
+			}
+			// The confederation segments are internal to the confederation
+			if stype == bgpSegmentASSet || stype == bgpSegmentASSequence {
+				segments = append(segments, seg)
+			}
+		}
+		return segments, p.err
+	}
+	return nil, d.err
+}
+
+// mrtDecoder reads big-endian values from the buffer, recording an error once the buffer is exhausted.
+type mrtDecoder struct {
+	buf []byte
+	err error
+}
+
+var errMRTTruncated = errors.New("the record is truncated")
+
+func (d *mrtDecoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.err = errMRTTruncated
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *mrtDecoder) skip(n int) {
+	_ = d.bytes(n)
+}
+
+func (d *mrtDecoder) uint8() uint8 {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *mrtDecoder) uint16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *mrtDecoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func sortedASNs(set map[int]struct{}) []int {
+	asns := make([]int, 0, len(set))
+	for asn := range set {
+		asns = append(asns, asn)
+	}
+	sort.Ints(asns)
+	return asns
+}
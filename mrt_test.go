@@ -0,0 +1,123 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportMRT(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	report := importMRT(t, g, "testdata/mrt/rib.ipv4.mrt")
+
+	dumped := time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC)
+	if !report.DumpTime.Equal(dumped) || report.Records != 5 || report.Skipped != 1 || report.Peers != 2 ||
+		report.Routes != 4 || report.Prefixes != 3 || report.Announcements != 4 || report.Adjacencies != 4 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	for asn, want := range map[int]string{
+		64510: "192.0.2.0/24",
+		64511: "198.51.100.0/24",
+		64512: "198.51.100.0/24",
+		64501: "198.51.100.0/22",
+	} {
+		if got, err := g.ReadASPrefixes(ctx, asn, time.Time{}); err != nil || strings.Join(got, " ") != want {
+			t.Errorf("unexpected prefixes originated by AS%d: %v, %v", asn, got, err)
+		}
+	}
+	// The transit autonomous systems do not announce the prefixes
+	if got, err := g.ReadASPrefixes(ctx, 64500, time.Time{}); err != nil || len(got) != 0 {
+		t.Errorf("the transit AS announced prefixes: %v, %v", got, err)
+	}
+
+	// The announcements were observed when the RIB was dumped
+	if got, _ := g.ReadASPrefixesInRange(ctx, 64510, TimeRange{Until: dumped.Add(-time.Minute)}); len(got) != 0 {
+		t.Errorf("the announcements were observed before the dump time: %v", got)
+	}
+	if got, err := g.ReadASPrefixesInRange(ctx, 64510, TimeRange{Since: dumped.Add(-time.Minute),
+		Until: dumped}); err != nil || len(got) != 1 {
+		t.Errorf("the announcements were not observed at the dump time: %v, %v", got, err)
+	}
+
+	want := "4200000000 64500, 64496 64500, 64496 64501, 64500 64510"
+	if got := adjacencies(t, g); strings.Join(got, ", ") != want {
+		t.Errorf("unexpected adjacencies: %v", got)
+	}
+	if got, err := g.ReadSubnets(ctx, "198.51.100.0/22", time.Time{}); err != nil || len(got) != 1 {
+		t.Errorf("the netblocks were not placed in the hierarchy: %v, %v", got, err)
+	}
+}
+
+func TestImportMRTCompressedAddPath(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	report := importMRT(t, g, "testdata/mrt/rib.ipv6.addpath.mrt.gz")
+	if report.Records != 2 || report.Routes != 2 || report.Prefixes != 1 || report.Adjacencies != 3 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	if got, err := g.LookupAddress(context.Background(), "2001:db8::1"); err != nil || got.ASN != 64513 {
+		t.Errorf("unexpected lookup result: %+v, %v", got, err)
+	}
+}
+
+func TestImportMRTTruncated(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	f, err := os.Open("testdata/mrt/truncated.mrt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := g.ImportMRT(context.Background(), f); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a truncated dump: %v", err)
+	}
+}
+
+func importMRT(t *testing.T, g *Graph, path string) *MRTReport {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	report, err := g.ImportMRT(context.Background(), f)
+	if err != nil {
+		t.Fatalf("failed to import %s: %v", path, err)
+	}
+	return report
+}
+
+func adjacencies(t *testing.T, g *Graph) []string {
+	var rows []struct {
+		From string
+		To   string
+	}
+	if err := g.db.Raw(`SELECT a.content->>'number' AS "from", b.content->>'number' AS "to" FROM relations
+		INNER JOIN assets AS a ON relations.from_asset_id = a.id INNER JOIN assets AS b ON relations.to_asset_id = b.id
+		WHERE relations.type = 'adjacent_to'`).Scan(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var pairs []string
+	for _, r := range rows {
+		pairs = append(pairs, r.From+" "+r.To)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
@@ -0,0 +1,259 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/owasp-amass/asset-db/repository"
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+const (
+	// StreamAsset is the kind of a StreamRecord that holds an asset.
+	StreamAsset = "asset"
+	// StreamRelation is the kind of a StreamRecord that holds a relation between two assets.
+	StreamRelation = "relation"
+)
+
+// streamPageSize is the number of rows read from the database per query during an export.
+const streamPageSize = 1000
+
+// StreamRecord is a single line of the newline-delimited JSON stream produced by ExportNDJSON.
+// Relations reference assets by the IDs used earlier in the same stream.
+type StreamRecord struct {
+	Kind      string          `json:"kind"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	FromID    string          `json:"from_id,omitempty"`
+	ToID      string          `json:"to_id,omitempty"`
+	FirstSeen time.Time       `json:"first_seen"`
+	LastSeen  time.Time       `json:"last_seen"`
+}
+
+// ProgressFunc is called during streaming operations with the number of assets and relations processed so far.
+type ProgressFunc func(assets, relations int)
+
+type relationRow struct {
+	ID          uint64
+	CreatedAt   time.Time
+	LastSeen    time.Time
+	Type        string
+	FromAssetID uint64
+	ToAssetID   uint64
+}
+
+// ExportNDJSON writes every asset in the graph, followed by every relation, to w as newline-delimited JSON.
+// The progress callback is optional and is invoked after each page of records has been written.
+func (g *Graph) ExportNDJSON(ctx context.Context, w io.Writer, progress ProgressFunc) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	last := "0"
+	var nassets, nrels int
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		assets, err := g.assetQuery(ctx, fmt.Sprintf("assets WHERE assets.id > %s ORDER BY assets.id LIMIT %d", last, streamPageSize))
+		if err != nil {
+			return err
+		} else if len(assets) == 0 {
+			break
+		}
+
+		for _, a := range assets {
+			content, err := a.Asset.JSON()
+			if err != nil {
+				return err
+			}
+
+			if err := enc.Encode(&StreamRecord{
+				Kind:      StreamAsset,
+				ID:        a.ID,
+				Type:      string(a.Asset.AssetType()),
+				Content:   content,
+				FirstSeen: a.CreatedAt.UTC(),
+				LastSeen:  a.LastSeen.UTC(),
+			}); err != nil {
+				return err
+			}
+			last = a.ID
+		}
+
+		nassets += len(assets)
+		if progress != nil {
+			progress(nassets, nrels)
+		}
+	}
+
+	var lastrel uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var rows []relationRow
+		query := fmt.Sprintf(`SELECT id, created_at, last_seen, type, from_asset_id, to_asset_id
+			FROM relations WHERE id > %d ORDER BY id LIMIT %d`, lastrel, streamPageSize)
+		if err := g.rawQuery(ctx, query, &rows); err != nil {
+			return err
+		} else if len(rows) == 0 {
+			break
+		}
+
+		for _, r := range rows {
+			if err := enc.Encode(&StreamRecord{
+				Kind:      StreamRelation,
+				ID:        fmt.Sprint(r.ID),
+				Type:      r.Type,
+				FromID:    fmt.Sprint(r.FromAssetID),
+				ToID:      fmt.Sprint(r.ToAssetID),
+				FirstSeen: r.CreatedAt.UTC(),
+				LastSeen:  r.LastSeen.UTC(),
+			}); err != nil {
+				return err
+			}
+			lastrel = r.ID
+		}
+
+		nrels += len(rows)
+		if progress != nil {
+			progress(nassets, nrels)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ImportNDJSON reads a stream produced by ExportNDJSON and upserts the assets and relations into the graph,
+// observed at their first seen and last seen times. Importing the same stream more than once does not create
+// duplicate assets or relations.
+// The progress callback is optional and is invoked after each page of records has been processed.
+func (g *Graph) ImportNDJSON(ctx context.Context, r io.Reader, progress ProgressFunc) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	ids := make(map[string]*types.Asset)
+	var line, nassets, nrels int
+	for scanner.Scan() {
+		line++
+		if line%streamPageSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(nassets, nrels)
+			}
+		}
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var rec StreamRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+
+		switch rec.Kind {
+		case StreamAsset:
+			a, err := g.importAsset(ctx, &rec)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			ids[rec.ID] = a
+			nassets++
+		case StreamRelation:
+			from, found := ids[rec.FromID]
+			if !found {
+				return fmt.Errorf("line %d: relation references unknown asset %s", line, rec.FromID)
+			}
+			to, found := ids[rec.ToID]
+			if !found {
+				return fmt.Errorf("line %d: relation references unknown asset %s", line, rec.ToID)
+			}
+			for _, at := range rec.observations() {
+				if err := g.linkAt(ctx, from, rec.Type, to, at); err != nil {
+					return fmt.Errorf("line %d: %v", line, err)
+				}
+			}
+			nrels++
+		default:
+			return fmt.Errorf("line %d: unknown record kind %q", line, rec.Kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if progress != nil {
+		progress(nassets, nrels)
+	}
+	return nil
+}
+
+// importAsset upserts the asset of the record observed at its first seen and last seen times, so the
+// range stored in the graph is rebuilt from the stream.
+func (g *Graph) importAsset(ctx context.Context, rec *StreamRecord) (*types.Asset, error) {
+	asset, err := parseAsset(rec.Type, rec.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	var a *types.Asset
+	for _, at := range rec.observations() {
+		switch v := asset.(type) {
+		case *network.IPAddress:
+			a, err = g.UpsertAddressAt(ctx, v.Address.String(), at)
+		case *network.Netblock:
+			a, err = g.UpsertNetblockAt(ctx, v.Cidr.String(), at)
+		default:
+			// The apex domain names are records of the stream, so they are not upserted along with the FQDNs
+			a, err = g.upsertAssetAt(ctx, asset, at)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// observations returns the times the record was observed at. A record without timestamps is observed now.
+func (rec *StreamRecord) observations() []time.Time {
+	var times []time.Time
+	if !rec.FirstSeen.IsZero() {
+		times = append(times, rec.FirstSeen)
+	}
+	if !rec.LastSeen.IsZero() && !rec.LastSeen.Equal(rec.FirstSeen) {
+		times = append(times, rec.LastSeen)
+	}
+	if len(times) == 0 {
+		times = append(times, time.Time{})
+	}
+	return times
+}
+
+func parseAsset(atype string, content json.RawMessage) (oam.Asset, error) {
+	if len(content) == 0 {
+		return nil, errors.New("the asset content is empty")
+	}
+
+	a := &repository.Asset{
+		Type:    atype,
+		Content: []byte(content),
+	}
+	return a.Parse()
+}
@@ -0,0 +1,129 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNDJSON(t *testing.T) {
+	src := NewGraph("memory", "", "")
+	defer src.Remove()
+
+	ctx := context.Background()
+	first := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+	last := first.Add(30 * 24 * time.Hour)
+	_ = src.UpsertAAt(ctx, "www.owasp.org", "192.168.1.1", first)
+	_ = src.UpsertAAt(ctx, "www.owasp.org", "192.168.1.1", last)
+	_ = src.UpsertCNAMEAt(ctx, "owasp.org", "www.owasp.org", first)
+	_ = src.UpsertInfrastructureAt(ctx, 667, "Great AS", "192.168.1.1", "192.168.1.0/24", last)
+
+	var buf bytes.Buffer
+	var calls int
+	if err := src.ExportNDJSON(ctx, &buf, func(assets, relations int) { calls++ }); err != nil {
+		t.Fatalf("failed to export the graph: %v", err)
+	}
+	if calls == 0 {
+		t.Error("the progress callback was never called")
+	}
+
+	var nassets, nrels int
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var rec StreamRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("failed to unmarshal the line %s: %v", scanner.Text(), err)
+		}
+		if rec.Kind == StreamAsset {
+			nassets++
+		} else if rec.Kind == StreamRelation {
+			nrels++
+		}
+		if rec.FirstSeen.IsZero() || rec.LastSeen.IsZero() {
+			t.Errorf("the record is missing timestamps: %s", scanner.Text())
+		}
+	}
+	if nassets != 6 || nrels != 5 {
+		t.Errorf("expected 6 assets and 5 relations, got %d assets and %d relations", nassets, nrels)
+	}
+
+	dst := NewGraph("memory", "", "")
+	defer dst.Remove()
+
+	for i := 0; i < 2; i++ {
+		var gotAssets, gotRels int
+		err := dst.ImportNDJSON(ctx, bytes.NewReader(buf.Bytes()), func(assets, relations int) {
+			gotAssets, gotRels = assets, relations
+		})
+		if err != nil {
+			t.Fatalf("failed to import the stream: %v", err)
+		}
+		if gotAssets != nassets || gotRels != nrels {
+			t.Errorf("progress reported %d assets and %d relations", gotAssets, gotRels)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := dst.ExportNDJSON(ctx, &out, nil); err != nil {
+		t.Fatalf("failed to export the imported graph: %v", err)
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != nassets+nrels {
+		t.Errorf("the import was not idempotent: expected %d records, got %d", nassets+nrels, lines)
+	}
+
+	want, got := streamTimes(t, buf.Bytes()), streamTimes(t, out.Bytes())
+	for key, w := range want {
+		if g, found := got[key]; !found || !g[0].Equal(w[0]) || !g[1].Equal(w[1]) {
+			t.Errorf("%s: expected first and last seen %v, got %v", key, w, g)
+		}
+	}
+	if w := want["relation a_record www.owasp.org 192.168.1.1"]; !w[0].Equal(first) || !w[1].Equal(last) {
+		t.Errorf("unexpected first and last seen of the A record: %v", w)
+	}
+
+	if pairs, err := dst.NamesToAddrs(ctx, time.Time{}, "www.owasp.org"); err != nil || len(pairs) != 1 {
+		t.Errorf("failed to obtain the name / address pairs from the imported graph: %v", err)
+	}
+	if desc, _ := dst.ReadASDescription(ctx, 667, time.Time{}); desc != "Great AS" {
+		t.Errorf("expected: Great AS, got: %s", desc)
+	}
+
+	if err := dst.ImportNDJSON(ctx, bytes.NewBufferString("{\"kind\":\"relation\",\"from_id\":\"99\",\"to_id\":\"100\"}\n"), nil); err == nil {
+		t.Error("did not return an error for a relation referencing unknown assets")
+	}
+}
+
+// streamTimes returns the first seen and last seen times of the records in the stream,
+// keyed by the content of the assets, so streams of different graphs can be compared.
+func streamTimes(t *testing.T, data []byte) map[string][2]time.Time {
+	names := make(map[string]string)
+	times := make(map[string][2]time.Time)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var rec StreamRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("failed to unmarshal the line %s: %v", scanner.Text(), err)
+		}
+
+		key := "asset " + rec.Type + " " + string(rec.Content)
+		if rec.Kind == StreamAsset {
+			a, err := parseAsset(rec.Type, rec.Content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names[rec.ID] = assetString(a)
+		} else {
+			key = "relation " + rec.Type + " " + names[rec.FromID] + " " + names[rec.ToID]
+		}
+		times[key] = [2]time.Time{rec.FirstSeen, rec.LastSeen}
+	}
+	return times
+}
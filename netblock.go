@@ -6,8 +6,9 @@ package netmap
 
 import (
 	"context"
-	"fmt"
 	"net/netip"
+	"sort"
+	"time"
 
 	"github.com/owasp-amass/asset-db/types"
 	"github.com/owasp-amass/open-asset-model/network"
@@ -15,10 +16,18 @@ import (
 
 // UpsertNetblock adds a netblock/CIDR to the graph.
 func (g *Graph) UpsertNetblock(ctx context.Context, cidr string) (*types.Asset, error) {
+	return g.UpsertNetblockAt(ctx, cidr, time.Time{})
+}
+
+// UpsertNetblockAt adds a netblock/CIDR to the graph that was observed at the provided time.
+// The first seen / last seen range of the netblock is extended to include the observation.
+// A new netblock takes over the addresses and netblocks it covers from less specific netblocks.
+func (g *Graph) UpsertNetblockAt(ctx context.Context, cidr string, at time.Time) (*types.Asset, error) {
 	prefix, err := netip.ParsePrefix(cidr)
 	if err != nil {
-		return nil, err
+		return nil, inputError(err)
 	}
+	prefix = prefix.Masked()
 
 	var t string
 	ip := prefix.Addr()
@@ -27,11 +36,229 @@ func (g *Graph) UpsertNetblock(ctx context.Context, cidr string) (*types.Asset,
 	} else if ip.Is6() {
 		t = "IPv6"
 	} else {
-		return nil, fmt.Errorf("%s is not a valid IPv4 or IPv6 IP address", ip.String())
+		return nil, invalidInput("%s is not a valid IPv4 or IPv6 IP address", ip.String())
 	}
 
-	return g.DB.Create(nil, "", &network.Netblock{
+	return g.upsertAssetAt(ctx, &network.Netblock{
 		Cidr: prefix,
 		Type: t,
+	}, at)
+}
+
+// ReadSupernets returns the chain of netblocks in the graph containing the netblock, starting with the immediate
+// supernet and ending with the least specific netblock.
+func (g *Graph) ReadSupernets(ctx context.Context, cidr string, since time.Time) ([]string, error) {
+	return g.ReadSupernetsInRange(ctx, cidr, TimeRange{Since: since})
+}
+
+// ReadSupernetsInRange returns the chain of netblocks containing the netblock during the time range, starting
+// with the immediate supernet and ending with the least specific netblock.
+func (g *Graph) ReadSupernetsInRange(ctx context.Context, cidr string, tr TimeRange) ([]string, error) {
+	nb, err := g.findNetblock(ctx, cidr, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	var supernets []string
+	seen := map[string]struct{}{nb.ID: {}}
+	for {
+		rels, err := g.relationQuery(ctx, "relations INNER JOIN assets ON relations.from_asset_id = assets.id"+
+			" WHERE relations.to_asset_id = "+nb.ID+" AND relations.type = 'contains' AND assets.type = 'Netblock'"+
+			tr.constraint("relations")+tr.constraint("assets")+" ORDER BY relations.last_seen DESC LIMIT 1")
+		if err != nil {
+			return nil, err
+		} else if len(rels) == 0 {
+			return supernets, nil
+		}
+
+		nb = rels[0].FromAsset
+		if _, found := seen[nb.ID]; found {
+			return supernets, nil
+		}
+		seen[nb.ID] = struct{}{}
+
+		if parent, ok := nb.Asset.(*network.Netblock); ok {
+			supernets = append(supernets, parent.Cidr.String())
+		}
+	}
+}
+
+// ReadSubnets returns the netblocks in the graph immediately contained by the netblock.
+func (g *Graph) ReadSubnets(ctx context.Context, cidr string, since time.Time) ([]string, error) {
+	return g.ReadSubnetsInRange(ctx, cidr, TimeRange{Since: since})
+}
+
+// ReadSubnetsInRange returns the netblocks immediately contained by the netblock during the time range.
+func (g *Graph) ReadSubnetsInRange(ctx context.Context, cidr string, tr TimeRange) ([]string, error) {
+	nb, err := g.findNetblock(ctx, cidr, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	rels, err := g.relationQuery(ctx, "relations INNER JOIN assets ON relations.to_asset_id = assets.id"+
+		" WHERE relations.from_asset_id = "+nb.ID+" AND relations.type = 'contains' AND assets.type = 'Netblock'"+
+		tr.constraint("relations")+tr.constraint("assets"))
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []netip.Prefix
+	for _, rel := range rels {
+		if subnet, ok := rel.ToAsset.Asset.(*network.Netblock); ok {
+			subnets = append(subnets, subnet.Cidr)
+		}
+	}
+	sort.Slice(subnets, func(i, j int) bool { return comparePrefixes(subnets[i], subnets[j]) < 0 })
+
+	results := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		results = append(results, subnet.String())
+	}
+	return results, nil
+}
+
+// AnnouncementOverlap is a netblock announced by an autonomous system that is also covered by an equal or less
+// specific netblock announced by another autonomous system, which can indicate a hijack or a MOAS conflict.
+type AnnouncementOverlap struct {
+	// Netblock is the netblock announced by ASN.
+	Netblock string
+	ASN      int
+	// Covering is the netblock announced by CoveringASN that contains Netblock.
+	Covering    string
+	CoveringASN int
+}
+
+// MOAS returns true when the autonomous systems announce the same netblock (Multiple Origin AS).
+func (o *AnnouncementOverlap) MOAS() bool {
+	return o.Netblock == o.Covering
+}
+
+// AnnouncementOverlaps returns the netblocks announced by different autonomous systems that overlap.
+// A netblock announced by multiple autonomous systems is reported once for each pair of them.
+func (g *Graph) AnnouncementOverlaps(ctx context.Context, since time.Time) ([]*AnnouncementOverlap, error) {
+	return g.AnnouncementOverlapsInRange(ctx, TimeRange{Since: since})
+}
+
+// AnnouncementOverlapsInRange returns the netblocks announced by different autonomous systems that overlap,
+// using only the announcements seen during the time range.
+func (g *Graph) AnnouncementOverlapsInRange(ctx context.Context, tr TimeRange) ([]*AnnouncementOverlap, error) {
+	if err := tr.Validate(); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Cidr string
+		ASN  int
+	}
+	if err := g.rawQuery(ctx, `SELECT DISTINCT nbs.content->>'cidr' AS cidr, asns.content->>'number' AS asn
+		FROM ((relations INNER JOIN assets AS asns ON relations.from_asset_id = asns.id)
+		INNER JOIN assets AS nbs ON relations.to_asset_id = nbs.id)
+		WHERE relations.type = 'announces' AND asns.type = 'ASN' AND nbs.type = 'Netblock'`+
+		tr.constraint("relations"), &rows); err != nil {
+		return nil, err
+	}
+
+	type announced struct {
+		prefix netip.Prefix
+		asns   []int
+	}
+	var prefixes []*announced
+	byPrefix := make(map[netip.Prefix]*announced)
+	for _, row := range rows {
+		prefix, err := netip.ParsePrefix(row.Cidr)
+		if err != nil {
+			continue
+		}
+
+		a, found := byPrefix[prefix]
+		if !found {
+			a = &announced{prefix: prefix}
+			byPrefix[prefix] = a
+			prefixes = append(prefixes, a)
+		}
+		a.asns = append(a.asns, row.ASN)
+	}
+
+	idx := newPrefixIndex()
+	for i, a := range prefixes {
+		sort.Ints(a.asns)
+		idx.insert(a.prefix, uint64(i))
+	}
+
+	var overlaps []*AnnouncementOverlap
+	for _, a := range prefixes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		for _, m := range idx.match(a.prefix.Addr()) {
+			covering := prefixes[m.id]
+			if covering.prefix.Bits() > a.prefix.Bits() {
+				continue
+			}
+
+			for _, asn := range a.asns {
+				for _, casn := range covering.asns {
+					// The pairs of a MOAS conflict are only reported once
+					if asn == casn || (covering == a && asn > casn) {
+						continue
+					}
+					overlaps = append(overlaps, &AnnouncementOverlap{
+						Netblock:    a.prefix.String(),
+						ASN:         asn,
+						Covering:    covering.prefix.String(),
+						CoveringASN: casn,
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(overlaps, func(i, j int) bool {
+		a, b := overlaps[i], overlaps[j]
+		if c := comparePrefixes(netip.MustParsePrefix(a.Netblock), netip.MustParsePrefix(b.Netblock)); c != 0 {
+			return c < 0
+		}
+		if a.ASN != b.ASN {
+			return a.ASN < b.ASN
+		}
+		if c := comparePrefixes(netip.MustParsePrefix(a.Covering), netip.MustParsePrefix(b.Covering)); c != 0 {
+			return c < 0
+		}
+		return a.CoveringASN < b.CoveringASN
 	})
+	return overlaps, nil
+}
+
+// findNetblock returns the netblock asset when it was seen during the time range.
+func (g *Graph) findNetblock(ctx context.Context, cidr string, tr TimeRange) (*types.Asset, error) {
+	if err := tr.Validate(); err != nil {
+		return nil, err
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, inputError(err)
+	}
+	prefix = prefix.Masked()
+
+	assets, err := g.findByContent(ctx, &network.Netblock{Cidr: prefix, Type: addrType(prefix.Addr())}, tr.Since)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range assets {
+		if tr.includesAsset(a) {
+			return a, nil
+		}
+	}
+	return nil, notFound("the netblock %s", prefix)
+}
+
+// comparePrefixes orders the prefixes by address family, then address, then length.
+func comparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
 }
@@ -6,7 +6,11 @@ package netmap
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/owasp-amass/open-asset-model/network"
 )
@@ -26,3 +30,94 @@ func TestNetblock(t *testing.T) {
 		}
 	})
 }
+
+func TestNetblockHierarchy(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	for _, cidr := range []string{"10.1.2.0/24", "10.0.0.0/8", "10.1.3.0/24", "10.1.0.0/16", "10.1.2.128/25"} {
+		if _, err := g.UpsertNetblock(ctx, cidr); err != nil {
+			t.Fatalf("failed to upsert %s: %v", cidr, err)
+		}
+	}
+	_, _ = g.UpsertAddress(ctx, "10.1.2.1")
+
+	if got, err := g.ReadSupernets(ctx, "10.1.2.128/25", time.Time{}); err != nil ||
+		strings.Join(got, " ") != "10.1.2.0/24 10.1.0.0/16 10.0.0.0/8" {
+		t.Errorf("unexpected supernet chain: %v, %v", got, err)
+	}
+	if got, err := g.ReadSupernets(ctx, "10.0.0.0/8", time.Time{}); err != nil || len(got) != 0 {
+		t.Errorf("unexpected supernets of the least specific netblock: %v, %v", got, err)
+	}
+	if got, err := g.ReadSubnets(ctx, "10.1.0.0/16", time.Time{}); err != nil ||
+		strings.Join(got, " ") != "10.1.2.0/24 10.1.3.0/24" {
+		t.Errorf("unexpected immediate subnets: %v, %v", got, err)
+	}
+	if _, err := g.ReadSubnets(ctx, "192.0.2.0/24", time.Time{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing netblock: %v", err)
+	}
+
+	// The contents of a removed netblock are moved to its supernet
+	if err := g.RemoveNetblock(ctx, "10.1.2.0/24", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := g.ReadSubnets(ctx, "10.1.0.0/16", time.Time{}); err != nil ||
+		strings.Join(got, " ") != "10.1.2.128/25 10.1.3.0/24" {
+		t.Errorf("unexpected immediate subnets after the removal: %v, %v", got, err)
+	}
+	if got := containsEdges(t, g); !contains(got, "10.1.0.0/16 -> 10.1.2.1") {
+		t.Errorf("the address was not moved to the supernet: %v", got)
+	}
+}
+
+func TestAnnouncementOverlaps(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	for _, ann := range []struct {
+		asn  int
+		addr string
+		cidr string
+	}{
+		{64496, "198.51.100.1", "198.51.0.0/16"},
+		{64496, "198.51.100.2", "198.51.100.0/24"},
+		{64497, "198.51.100.3", "198.51.100.0/24"},
+		{64498, "198.51.100.129", "198.51.100.128/25"},
+		{64499, "203.0.113.1", "203.0.113.0/24"},
+	} {
+		if err := g.UpsertInfrastructure(ctx, ann.asn, fmt.Sprintf("AS%d", ann.asn), ann.addr, ann.cidr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overlaps, err := g.AnnouncementOverlaps(ctx, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, o := range overlaps {
+		got = append(got, fmt.Sprintf("%s AS%d %s AS%d %t", o.Netblock, o.ASN, o.Covering, o.CoveringASN, o.MOAS()))
+	}
+	want := []string{
+		"198.51.100.0/24 AS64496 198.51.100.0/24 AS64497 true",
+		"198.51.100.0/24 AS64497 198.51.0.0/16 AS64496 false",
+		"198.51.100.128/25 AS64498 198.51.0.0/16 AS64496 false",
+		"198.51.100.128/25 AS64498 198.51.100.0/24 AS64496 false",
+		"198.51.100.128/25 AS64498 198.51.100.0/24 AS64497 false",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected overlaps:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
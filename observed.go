@@ -0,0 +1,150 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+)
+
+// sqlTimeFormat is the layout used for timestamps written to and compared against the database.
+const sqlTimeFormat = "2006-01-02 15:04:05"
+
+// upsertAssetAt adds the asset to the graph and extends its first seen / last seen range to include the
+// observation time. When at is zero, the current time is used as the observation time.
+// The observation is attributed to the provenance carried by the context. IP addresses and netblocks
+// are placed within the netblock hierarchy of the graph.
+func (g *Graph) upsertAssetAt(ctx context.Context, asset oam.Asset, at time.Time) (*types.Asset, error) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	at = at.UTC().Truncate(time.Second)
+
+	assets, err := g.findByContent(ctx, asset, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(assets) == 0 {
+		content, err := asset.JSON()
+		if err != nil {
+			return nil, err
+		}
+
+		var id uint64
+		ts := at.Format(sqlTimeFormat)
+		if err := g.db.WithContext(ctx).Raw(`INSERT INTO assets (type, content, created_at, last_seen)
+			VALUES (?, ?, ?, ?) RETURNING id`, string(asset.AssetType()), string(content), ts, ts).Scan(&id).Error; err != nil {
+			return nil, backendError(err)
+		}
+
+		a := &types.Asset{ID: strconv.FormatUint(id, 10), CreatedAt: at, LastSeen: at, Asset: asset}
+		if err := g.attributeAsset(ctx, a, at); err != nil {
+			return a, err
+		}
+		return a, g.place(ctx, a, true, at)
+	}
+
+	existing := assets[0]
+	first, last := extendRange(existing.CreatedAt, existing.LastSeen, at)
+	err = g.db.WithContext(ctx).Exec("UPDATE assets SET created_at = ?, last_seen = ? WHERE id = ?",
+		first.Format(sqlTimeFormat), last.Format(sqlTimeFormat), existing.ID).Error
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	existing.CreatedAt, existing.LastSeen = first, last
+	if err := g.attributeAsset(ctx, existing, at); err != nil {
+		return existing, err
+	}
+	return existing, g.place(ctx, existing, false, at)
+}
+
+// linkAt creates the relation between the assets and extends its first seen / last seen range to include
+// the observation time. When at is zero, the current time is used as the observation time.
+// The observation is attributed to the provenance carried by the context.
+func (g *Graph) linkAt(ctx context.Context, from *types.Asset, relation string, to *types.Asset, at time.Time) error {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	at = at.UTC().Truncate(time.Second)
+
+	fromID, err := strconv.ParseUint(from.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	toID, err := strconv.ParseUint(to.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	var rows []relationRow
+	if err := g.db.WithContext(ctx).Raw(`SELECT id, created_at, last_seen FROM relations
+		WHERE from_asset_id = ? AND to_asset_id = ? AND type = ? ORDER BY id LIMIT 1`,
+		fromID, toID, relation).Scan(&rows).Error; err != nil {
+		return backendError(err)
+	}
+
+	if len(rows) == 0 {
+		srctype := from.Asset.AssetType()
+		destype := to.Asset.AssetType()
+		if !validRelationship(srctype, relation, destype) {
+			return invalidInput("%s -%s-> %s is not valid in the taxonomy", srctype, relation, destype)
+		}
+
+		var id uint64
+		ts := at.Format(sqlTimeFormat)
+		if err := g.db.WithContext(ctx).Raw(`INSERT INTO relations (type, from_asset_id, to_asset_id, created_at, last_seen)
+			VALUES (?, ?, ?, ?, ?) RETURNING id`, relation, fromID, toID, ts, ts).Scan(&id).Error; err != nil {
+			return backendError(err)
+		}
+		return g.attributeRelation(ctx, id, at)
+	}
+
+	first, last := extendRange(rows[0].CreatedAt, rows[0].LastSeen, at)
+	err = g.db.WithContext(ctx).Exec("UPDATE relations SET created_at = ?, last_seen = ? WHERE id = ?",
+		first.Format(sqlTimeFormat), last.Format(sqlTimeFormat), rows[0].ID).Error
+	if err != nil {
+		return backendError(err)
+	}
+	return g.attributeRelation(ctx, rows[0].ID, at)
+}
+
+// graphRelations are the relationships supported by the graph in addition to the open asset model taxonomy.
+var graphRelations = map[oam.AssetType]map[string][]oam.AssetType{
+	oam.Netblock: {"contains": {oam.Netblock}},
+	oam.ASN:      {"adjacent_to": {oam.ASN}, "peers_with": {oam.ASN}, "provider_of": {oam.ASN}},
+}
+
+// validRelationship returns true when the relationship is in the open asset model taxonomy or supported by the graph.
+func validRelationship(src oam.AssetType, relation string, dest oam.AssetType) bool {
+	if oam.ValidRelationship(src, relation, dest) {
+		return true
+	}
+
+	for _, t := range graphRelations[src][relation] {
+		if t == dest {
+			return true
+		}
+	}
+	return false
+}
+
+// extendRange returns the first seen / last seen range widened to include the observation time.
+func extendRange(first, last, at time.Time) (time.Time, time.Time) {
+	first, last = first.UTC(), last.UTC()
+
+	if first.IsZero() || at.Before(first) {
+		first = at
+	}
+	if at.After(last) {
+		last = at
+	}
+	return first, last
+}
@@ -0,0 +1,72 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+func TestObservedAt(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	first := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	middle := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+	last := time.Date(2023, time.September, 1, 12, 0, 0, 0, time.UTC)
+
+	// The observations are provided out of order to ensure the ranges are extended and not overwritten
+	for _, at := range []time.Time{middle, last, first} {
+		if err := g.UpsertAAt(ctx, "www.owasp.org", "104.16.0.1", at); err != nil {
+			t.Fatalf("failed to insert the A record: %v", err)
+		}
+		if err := g.UpsertCNAMEAt(ctx, "owasp.net", "www.owasp.org", at); err != nil {
+			t.Fatalf("failed to insert the CNAME record: %v", err)
+		}
+	}
+	if err := g.UpsertInfrastructureAt(ctx, 13335, "CLOUDFLARENET", "104.16.0.1", "104.16.0.0/13", middle); err != nil {
+		t.Fatalf("failed to insert the infrastructure: %v", err)
+	}
+
+	assets, err := g.DB.FindByContent(&domain.FQDN{Name: "www.owasp.org"}, time.Time{})
+	if err != nil || len(assets) != 1 {
+		t.Fatalf("failed to find the FQDN: %v", err)
+	}
+	if !assets[0].CreatedAt.Equal(first) || !assets[0].LastSeen.Equal(last) {
+		t.Errorf("expected the range %v to %v, got %v to %v", first, last, assets[0].CreatedAt, assets[0].LastSeen)
+	}
+
+	rels, err := g.DB.RelationQuery("relations WHERE relations.type = 'a_record'")
+	if err != nil || len(rels) != 1 {
+		t.Fatalf("failed to find the A record: %v", err)
+	}
+	if !rels[0].CreatedAt.Equal(first) || !rels[0].LastSeen.Equal(last) {
+		t.Errorf("expected the range %v to %v, got %v to %v", first, last, rels[0].CreatedAt, rels[0].LastSeen)
+	}
+
+	if !g.IsCNAMENode(ctx, "owasp.net", last.Add(-time.Hour)) {
+		t.Error("the CNAME record was not seen after the last observation")
+	}
+	if g.IsCNAMENode(ctx, "owasp.net", last.Add(time.Hour)) {
+		t.Error("the CNAME record was seen after the last observation")
+	}
+
+	assets, err = g.DB.FindByContent(&network.Netblock{Cidr: netip.MustParsePrefix("104.16.0.0/13")}, time.Time{})
+	if err != nil || len(assets) != 1 {
+		t.Fatalf("failed to find the netblock: %v", err)
+	}
+	if !assets[0].CreatedAt.Equal(middle) || !assets[0].LastSeen.Equal(middle) {
+		t.Errorf("expected the netblock to be seen at %v, got %v to %v", middle, assets[0].CreatedAt, assets[0].LastSeen)
+	}
+	if desc, _ := g.ReadASDescription(ctx, 13335, time.Time{}); desc != "CLOUDFLARENET" {
+		t.Errorf("expected the AS description CLOUDFLARENET, got %s", desc)
+	}
+}
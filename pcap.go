@@ -0,0 +1,643 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// PassiveReport summarizes the DNS traffic ingested into the graph from passive observations.
+type PassiveReport struct {
+	// Packets is the number of packets or frames read from the input.
+	Packets int
+	// Messages is the number of DNS responses decoded.
+	Messages int
+	// Records is the number of answer records upserted into the graph.
+	Records int
+	// Errors is the number of DNS messages and answer records that could not be processed.
+	Errors int
+	// FailedStreams is the number of dnstap connections that ended with a decoding or I/O error.
+	FailedStreams int
+}
+
+// Link-layer header types supported in packet captures.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+const (
+	pcapMagicMicro   = 0xa1b2c3d4
+	pcapMagicNano    = 0xa1b23c4d
+	pcapngBlockSHB   = 0x0a0d0d0a
+	pcapngBlockIDB   = 0x00000001
+	pcapngBlockPB    = 0x00000002
+	pcapngBlockEPB   = 0x00000006
+	pcapngByteOrder  = 0x1a2b3c4d
+	pcapMaxBlockSize = 16 * 1024 * 1024
+)
+
+// capturedPacket is a single packet read from a capture file.
+type capturedPacket struct {
+	ts       time.Time
+	linkType uint32
+	data     []byte
+}
+
+// IngestPcap reads a pcap or pcapng capture, decodes the DNS responses carried over UDP and TCP,
+// and upserts every answer record into the graph using the packet timestamp as the observation time.
+func (g *Graph) IngestPcap(ctx context.Context, r io.Reader) (*PassiveReport, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the capture header: %v", err)
+	}
+
+	var next func() (*capturedPacket, error)
+	if binary.LittleEndian.Uint32(magic) == pcapngBlockSHB {
+		next = newPcapngReader(br).next
+	} else if pr, err := newPcapReader(br); err == nil {
+		next = pr.next
+	} else {
+		return nil, err
+	}
+
+	var swept time.Time
+	report := new(PassiveReport)
+	streams := make(map[string]*tcpStream)
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		pkt, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return report, err
+		}
+		report.Packets++
+
+		// The connections captured without their FIN or RST are dropped once they have been idle
+		if pkt.ts.Sub(swept) >= tcpStreamTimeout {
+			evictStreams(streams, pkt.ts.Add(-tcpStreamTimeout))
+			swept = pkt.ts
+		}
+		for _, msg := range extractDNS(pkt, streams) {
+			g.ingestDNSMessage(ctx, msg, pkt.ts, report)
+		}
+	}
+	return report, nil
+}
+
+// dnsRecord is an answer record in presentation format.
+type dnsRecord struct {
+	name   string
+	rrtype string
+	data   string
+}
+
+// ingestDNSMessage upserts the answer records from a DNS response into the graph.
+func (g *Graph) ingestDNSMessage(ctx context.Context, msg []byte, at time.Time, report *PassiveReport) {
+	records, response, err := parseDNSResponse(msg)
+	if response {
+		report.Messages++
+	}
+	if err != nil {
+		report.Errors++
+		return
+	}
+
+	for _, rec := range records {
+		if err := g.upsertRecord(ctx, rec.name, rec.rrtype, rec.data, at); err != nil {
+			report.Errors++
+			continue
+		}
+		report.Records++
+	}
+}
+
+// parseDNSResponse returns the supported answer records from a successful DNS response.
+// The boolean return value reports whether the message is a response.
+func parseDNSResponse(msg []byte) ([]*dnsRecord, bool, error) {
+	var p dnsmessage.Parser
+
+	hdr, err := p.Start(msg)
+	if err != nil {
+		return nil, false, err
+	}
+	if !hdr.Response || hdr.RCode != dnsmessage.RCodeSuccess {
+		return nil, hdr.Response, nil
+	}
+
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, true, err
+	}
+
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return nil, true, err
+	}
+
+	var records []*dnsRecord
+	for _, ans := range answers {
+		if rrtype, data, ok := resourceData(ans); ok {
+			records = append(records, &dnsRecord{
+				name:   cleanName(ans.Header.Name.String()),
+				rrtype: rrtype,
+				data:   data,
+			})
+		}
+	}
+	return records, true, nil
+}
+
+// resourceData returns the record type and presentation format data of a supported answer record.
+func resourceData(rr dnsmessage.Resource) (string, string, bool) {
+	switch b := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		return "A", netip.AddrFrom4(b.A).String(), true
+	case *dnsmessage.AAAAResource:
+		return "AAAA", netip.AddrFrom16(b.AAAA).String(), true
+	case *dnsmessage.CNAMEResource:
+		return "CNAME", b.CNAME.String(), true
+	case *dnsmessage.NSResource:
+		return "NS", b.NS.String(), true
+	case *dnsmessage.PTRResource:
+		return "PTR", b.PTR.String(), true
+	case *dnsmessage.MXResource:
+		return "MX", strconv.Itoa(int(b.Pref)) + " " + b.MX.String(), true
+	case *dnsmessage.SRVResource:
+		return "SRV", fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, b.Target.String()), true
+	}
+	return "", "", false
+}
+
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("failed to read the pcap header: %v", err)
+	}
+
+	pr := &pcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(hdr) == pcapMagicMicro:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr) == pcapMagicMicro:
+		pr.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(hdr) == pcapMagicNano:
+		pr.order, pr.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr) == pcapMagicNano:
+		pr.order, pr.nano = binary.BigEndian, true
+	default:
+		return nil, errors.New("the input is not a pcap or pcapng capture")
+	}
+
+	pr.linkType = pr.order.Uint32(hdr[20:24]) & 0x0fffffff
+	return pr, nil
+}
+
+func (pr *pcapReader) next() (*capturedPacket, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(pr.r, hdr); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the packet header: %v", err)
+	}
+
+	secs := int64(pr.order.Uint32(hdr[0:4]))
+	frac := int64(pr.order.Uint32(hdr[4:8]))
+	caplen := pr.order.Uint32(hdr[8:12])
+	if caplen > pcapMaxBlockSize {
+		return nil, fmt.Errorf("the packet length %d is invalid", caplen)
+	}
+
+	data := make([]byte, caplen)
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		return nil, fmt.Errorf("failed to read the packet data: %v", err)
+	}
+
+	if !pr.nano {
+		frac *= 1000
+	}
+	return &capturedPacket{
+		ts:       time.Unix(secs, frac).UTC(),
+		linkType: pr.linkType,
+		data:     data,
+	}, nil
+}
+
+type pcapngInterface struct {
+	linkType uint32
+	tsUnit   uint64 // the number of timestamp units per second
+}
+
+type pcapngReader struct {
+	r      io.Reader
+	order  binary.ByteOrder
+	ifaces []pcapngInterface
+}
+
+func newPcapngReader(r io.Reader) *pcapngReader {
+	return &pcapngReader{r: r, order: binary.LittleEndian}
+}
+
+func (nr *pcapngReader) next() (*capturedPacket, error) {
+	for {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(nr.r, hdr); err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read the block header: %v", err)
+		}
+
+		btype := binary.LittleEndian.Uint32(hdr[0:4])
+		if btype == pcapngBlockSHB {
+			// The byte-order magic follows the block length and determines how the section is encoded
+			bom := make([]byte, 4)
+			if _, err := io.ReadFull(nr.r, bom); err != nil {
+				return nil, fmt.Errorf("failed to read the section header: %v", err)
+			}
+			if binary.LittleEndian.Uint32(bom) == pcapngByteOrder {
+				nr.order = binary.LittleEndian
+			} else if binary.BigEndian.Uint32(bom) == pcapngByteOrder {
+				nr.order = binary.BigEndian
+			} else {
+				return nil, errors.New("the section header has an invalid byte-order magic")
+			}
+			nr.ifaces = nil
+
+			blen := nr.order.Uint32(hdr[4:8])
+			if blen < 28 || blen > pcapMaxBlockSize {
+				return nil, fmt.Errorf("the section header length %d is invalid", blen)
+			}
+			if _, err := io.CopyN(io.Discard, nr.r, int64(blen)-12); err != nil {
+				return nil, fmt.Errorf("failed to read the section header: %v", err)
+			}
+			continue
+		}
+
+		btype = nr.order.Uint32(hdr[0:4])
+		blen := nr.order.Uint32(hdr[4:8])
+		if blen < 12 || blen%4 != 0 || blen > pcapMaxBlockSize {
+			return nil, fmt.Errorf("the block length %d is invalid", blen)
+		}
+
+		body := make([]byte, blen-8)
+		if _, err := io.ReadFull(nr.r, body); err != nil {
+			return nil, fmt.Errorf("failed to read the block body: %v", err)
+		}
+		body = body[:len(body)-4] // remove the trailing block length
+
+		switch btype {
+		case pcapngBlockIDB:
+			if err := nr.addInterface(body); err != nil {
+				return nil, err
+			}
+		case pcapngBlockEPB, pcapngBlockPB:
+			if pkt := nr.packet(btype, body); pkt != nil {
+				return pkt, nil
+			}
+		}
+	}
+}
+
+func (nr *pcapngReader) addInterface(body []byte) error {
+	if len(body) < 8 {
+		return errors.New("the interface description block is truncated")
+	}
+
+	iface := pcapngInterface{
+		linkType: uint32(nr.order.Uint16(body[0:2])),
+		tsUnit:   1000000,
+	}
+
+	// Look for the if_tsresol option, which changes the timestamp resolution
+	for opts := body[8:]; len(opts) >= 4; {
+		code := nr.order.Uint16(opts[0:2])
+		olen := int(nr.order.Uint16(opts[2:4]))
+		if code == 0 || 4+olen > len(opts) {
+			break
+		}
+
+		if code == 9 && olen >= 1 {
+			// The number of units per second must fit in 64 bits, which allows up to 10^19 or 2^63
+			res := opts[4]
+			base, exp, limit := uint64(10), uint64(res), uint64(19)
+			if res&0x80 != 0 {
+				base, exp, limit = 2, uint64(res&0x7f), 63
+			}
+			if exp > limit {
+				return fmt.Errorf("the timestamp resolution %#x is not supported", res)
+			}
+			iface.tsUnit = pow(base, exp)
+		}
+
+		padded := (olen + 3) &^ 3
+		if 4+padded > len(opts) {
+			break
+		}
+		opts = opts[4+padded:]
+	}
+
+	nr.ifaces = append(nr.ifaces, iface)
+	return nil
+}
+
+func (nr *pcapngReader) packet(btype uint32, body []byte) *capturedPacket {
+	if len(body) < 20 {
+		return nil
+	}
+
+	id := nr.order.Uint32(body[0:4])
+	if btype == pcapngBlockPB {
+		// The obsolete packet block uses a 16-bit interface ID followed by the drops count
+		id = uint32(nr.order.Uint16(body[0:2]))
+	}
+	if int(id) >= len(nr.ifaces) {
+		return nil
+	}
+	iface := nr.ifaces[id]
+
+	ts := uint64(nr.order.Uint32(body[4:8]))<<32 | uint64(nr.order.Uint32(body[8:12]))
+	caplen := nr.order.Uint32(body[12:16])
+	if uint64(caplen) > uint64(len(body)-20) {
+		return nil
+	}
+
+	// The fraction is scaled with 128-bit arithmetic, since it overflows for resolutions finer than nanoseconds
+	secs := ts / iface.tsUnit
+	hi, lo := bits.Mul64(ts%iface.tsUnit, uint64(time.Second))
+	nsecs, _ := bits.Div64(hi, lo, iface.tsUnit)
+	return &capturedPacket{
+		ts:       time.Unix(int64(secs), int64(nsecs)).UTC(),
+		linkType: iface.linkType,
+		data:     body[20 : 20+caplen],
+	}
+}
+
+func pow(base, exp uint64) uint64 {
+	result := uint64(1)
+
+	for i := uint64(0); i < exp; i++ {
+		result *= base
+	}
+	return result
+}
+
+const (
+	// tcpStreamTimeout is the time without packets after which a TCP stream is considered abandoned.
+	tcpStreamTimeout = 2 * time.Minute
+	// maxTCPStreams is the number of TCP streams reassembled at once. The least recently active
+	// stream is dropped to make room for a new one.
+	maxTCPStreams = 1 << 16
+)
+
+// tcpStream reassembles the in-order payload of a TCP connection carrying DNS messages.
+type tcpStream struct {
+	next uint32
+	buf  []byte
+	// last is the timestamp of the most recent packet of the stream.
+	last time.Time
+}
+
+// addStream tracks the new stream, dropping the least recently active stream when there are too many.
+func addStream(streams map[string]*tcpStream, key string, s *tcpStream) {
+	if _, found := streams[key]; !found && len(streams) >= maxTCPStreams {
+		var oldest string
+		for k, v := range streams {
+			if oldest == "" || v.last.Before(streams[oldest].last) {
+				oldest = k
+			}
+		}
+		delete(streams, oldest)
+	}
+	streams[key] = s
+}
+
+// evictStreams drops the streams without packets since the cutoff.
+func evictStreams(streams map[string]*tcpStream, cutoff time.Time) {
+	for key, s := range streams {
+		if s.last.Before(cutoff) {
+			delete(streams, key)
+		}
+	}
+}
+
+// extractDNS returns the DNS messages carried by the packet over UDP or TCP port 53.
+func extractDNS(pkt *capturedPacket, streams map[string]*tcpStream) [][]byte {
+	packet, ok := linkPayload(pkt.linkType, pkt.data)
+	if !ok || len(packet) < 1 {
+		return nil
+	}
+
+	var proto uint8
+	var src, dst netip.Addr
+	var transport []byte
+	switch packet[0] >> 4 {
+	case 4:
+		src, dst, proto, transport, ok = parseIPv4(packet)
+	case 6:
+		src, dst, proto, transport, ok = parseIPv6(packet)
+	default:
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	switch proto {
+	case 17:
+		if len(transport) < 8 {
+			return nil
+		}
+
+		sport := binary.BigEndian.Uint16(transport[0:2])
+		dport := binary.BigEndian.Uint16(transport[2:4])
+		if sport != 53 && dport != 53 {
+			return nil
+		}
+		return [][]byte{transport[8:]}
+	case 6:
+		if len(transport) < 20 {
+			return nil
+		}
+
+		sport := binary.BigEndian.Uint16(transport[0:2])
+		dport := binary.BigEndian.Uint16(transport[2:4])
+		if sport != 53 && dport != 53 {
+			return nil
+		}
+
+		seq := binary.BigEndian.Uint32(transport[4:8])
+		offset := int(transport[12]>>4) * 4
+		flags := transport[13]
+		if offset < 20 || offset > len(transport) {
+			return nil
+		}
+		payload := transport[offset:]
+
+		key := netip.AddrPortFrom(src, sport).String() + "-" + netip.AddrPortFrom(dst, dport).String()
+		if flags&0x05 != 0 { // FIN or RST
+			defer delete(streams, key)
+		}
+		if flags&0x02 != 0 { // SYN
+			addStream(streams, key, &tcpStream{next: seq + 1, last: pkt.ts})
+			return nil
+		}
+
+		s, found := streams[key]
+		if !found {
+			// The capture started mid-connection, so assume this segment begins a DNS message
+			s = &tcpStream{next: seq}
+			addStream(streams, key, s)
+		}
+		s.last = pkt.ts
+		if len(payload) == 0 || seq != s.next {
+			return nil
+		}
+		s.next += uint32(len(payload))
+		s.buf = append(s.buf, payload...)
+
+		var msgs [][]byte
+		for len(s.buf) >= 2 {
+			mlen := int(binary.BigEndian.Uint16(s.buf[0:2]))
+			if len(s.buf) < 2+mlen {
+				break
+			}
+			msgs = append(msgs, s.buf[2:2+mlen])
+			s.buf = s.buf[2+mlen:]
+		}
+		return msgs
+	}
+	return nil
+}
+
+// linkPayload removes the link-layer header and returns the network-layer packet.
+func linkPayload(linkType uint32, data []byte) ([]byte, bool) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+
+		etype := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for etype == 0x8100 || etype == 0x88a8 {
+			if len(data) < 4 {
+				return nil, false
+			}
+			etype = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		if etype != 0x0800 && etype != 0x86dd {
+			return nil, false
+		}
+		return data, true
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		return data, true
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return nil, false
+		}
+		return data[4:], true
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		return data[16:], true
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		return data[20:], true
+	}
+	return nil, false
+}
+
+func parseIPv4(data []byte) (netip.Addr, netip.Addr, uint8, []byte, bool) {
+	if len(data) < 20 {
+		return netip.Addr{}, netip.Addr{}, 0, nil, false
+	}
+
+	ihl := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:4]))
+	if ihl < 20 || total < ihl || len(data) < ihl {
+		return netip.Addr{}, netip.Addr{}, 0, nil, false
+	}
+	if total > len(data) {
+		total = len(data)
+	}
+
+	// Fragmented datagrams are not reassembled
+	if frag := binary.BigEndian.Uint16(data[6:8]); frag&0x3fff != 0 {
+		return netip.Addr{}, netip.Addr{}, 0, nil, false
+	}
+
+	src := netip.AddrFrom4([4]byte(data[12:16]))
+	dst := netip.AddrFrom4([4]byte(data[16:20]))
+	return src, dst, data[9], data[ihl:total], true
+}
+
+func parseIPv6(data []byte) (netip.Addr, netip.Addr, uint8, []byte, bool) {
+	if len(data) < 40 {
+		return netip.Addr{}, netip.Addr{}, 0, nil, false
+	}
+
+	plen := int(binary.BigEndian.Uint16(data[4:6]))
+	src := netip.AddrFrom16([16]byte(data[8:24]))
+	dst := netip.AddrFrom16([16]byte(data[24:40]))
+	next := data[6]
+
+	payload := data[40:]
+	if plen < len(payload) {
+		payload = payload[:plen]
+	}
+
+	for {
+		switch next {
+		case 0, 43, 60: // Hop-by-Hop, Routing and Destination Options
+			if len(payload) < 8 {
+				return netip.Addr{}, netip.Addr{}, 0, nil, false
+			}
+
+			hlen := (int(payload[1]) + 1) * 8
+			if hlen > len(payload) {
+				return netip.Addr{}, netip.Addr{}, 0, nil, false
+			}
+			next = payload[0]
+			payload = payload[hlen:]
+		case 44: // Fragmented datagrams are not reassembled
+			return netip.Addr{}, netip.Addr{}, 0, nil, false
+		default:
+			return src, dst, next, payload, true
+		}
+	}
+}
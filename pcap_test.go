@@ -0,0 +1,157 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func TestIngestPcap(t *testing.T) {
+	for _, fixture := range []string{"testdata/dns.pcap", "testdata/dns.pcapng"} {
+		t.Run(fixture, func(t *testing.T) {
+			g := NewGraph("memory", "", "")
+			defer g.Remove()
+
+			f, err := os.Open(fixture)
+			if err != nil {
+				t.Fatalf("failed to open the fixture: %v", err)
+			}
+			defer f.Close()
+
+			ctx := context.Background()
+			report, err := g.IngestPcap(ctx, f)
+			if err != nil {
+				t.Fatalf("failed to ingest the capture: %v", err)
+			}
+			if report.Packets != 6 || report.Messages != 3 || report.Records != 4 || report.Errors != 0 {
+				t.Errorf("unexpected report: %+v", report)
+			}
+
+			if pairs, err := g.NamesToAddrs(ctx, time.Time{}, "owasp.org"); err != nil || len(pairs) != 2 {
+				t.Errorf("failed to obtain the name / address pairs: %v", err)
+			}
+			if !g.IsCNAMENode(ctx, "www.owasp.org", time.Time{}) {
+				t.Error("the CNAME record was not ingested")
+			}
+			if !g.IsMXNode(ctx, "aspmx.l.google.com", time.Time{}) {
+				t.Error("the MX record was not ingested")
+			}
+
+			assets, err := g.DB.FindByContent(&domain.FQDN{Name: "owasp.org"}, time.Time{})
+			if err != nil || len(assets) != 1 {
+				t.Fatalf("failed to find the FQDN: %v", err)
+			}
+			first := time.Date(2024, time.January, 15, 10, 43, 20, 0, time.UTC)
+			last := time.Date(2024, time.January, 15, 10, 43, 40, 0, time.UTC)
+			if !assets[0].CreatedAt.Equal(first) || !assets[0].LastSeen.Equal(last) {
+				t.Errorf("expected the packet timestamps %v and %v, got %v and %v",
+					first, last, assets[0].CreatedAt, assets[0].LastSeen)
+			}
+		})
+	}
+
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	if _, err := g.IngestPcap(context.Background(), strings.NewReader("not a packet capture")); err == nil {
+		t.Error("did not return an error for input that is not a capture")
+	}
+}
+
+func TestPcapngTimestampResolution(t *testing.T) {
+	for _, tc := range []struct {
+		res  byte
+		ts   uint64
+		want time.Time
+	}{
+		{6, 1705315400*1000000 + 250000, time.Unix(1705315400, 250000000)},
+		{9, 1705315400*1000000000 + 123456789, time.Unix(1705315400, 123456789)},
+		{12, 1705315*1000000000000 + 123456789012, time.Unix(1705315, 123456789)},
+		{19, 1*10000000000000000000 + 5000000000000000000, time.Unix(1, 500000000)},
+		{0x80 | 20, 5<<20 + 1<<19, time.Unix(5, 500000000)},
+		{0x80 | 63, 1<<63 + 1<<62, time.Unix(1, 500000000)},
+	} {
+		pkt, err := newPcapngReader(bytes.NewReader(pcapngCapture(tc.res, tc.ts))).next()
+		if err != nil {
+			t.Errorf("resolution %#x: failed to read the packet: %v", tc.res, err)
+			continue
+		}
+		if !pkt.ts.Equal(tc.want) {
+			t.Errorf("resolution %#x: expected %v, got %v", tc.res, tc.want.UTC(), pkt.ts)
+		}
+	}
+
+	// Resolutions of more than 2^64 units per second used to wrap to zero and divide by it
+	for _, res := range []byte{20, 64, 0x7f, 0x80 | 64, 0xff} {
+		if _, err := newPcapngReader(bytes.NewReader(pcapngCapture(res, 1))).next(); err == nil {
+			t.Errorf("resolution %#x: did not return an error", res)
+		}
+	}
+}
+
+// pcapngCapture returns a little-endian pcapng section with one interface using the timestamp
+// resolution and an enhanced packet block with the timestamp and an empty packet.
+func pcapngCapture(res byte, ts uint64) []byte {
+	var buf bytes.Buffer
+	block := func(btype uint32, body []byte) {
+		_ = binary.Write(&buf, binary.LittleEndian, btype)
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(body)+12))
+		buf.Write(body)
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(body)+12))
+	}
+	le := binary.LittleEndian
+
+	shb := make([]byte, 16)
+	le.PutUint32(shb[0:4], pcapngByteOrder)
+	le.PutUint16(shb[4:6], 1)
+	le.PutUint64(shb[8:16], ^uint64(0))
+	block(pcapngBlockSHB, shb)
+
+	idb := make([]byte, 8, 20)
+	le.PutUint16(idb[0:2], linkTypeRaw)
+	idb = le.AppendUint16(le.AppendUint16(idb, 9), 1)
+	idb = append(idb, res, 0, 0, 0)
+	idb = append(idb, 0, 0, 0, 0) // opt_endofopt
+	block(pcapngBlockIDB, idb)
+
+	epb := make([]byte, 20)
+	le.PutUint32(epb[4:8], uint32(ts>>32))
+	le.PutUint32(epb[8:12], uint32(ts))
+	block(pcapngBlockEPB, epb)
+	return buf.Bytes()
+}
+
+func TestTCPStreamEviction(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	streams := make(map[string]*tcpStream)
+	for i := 0; i < maxTCPStreams; i++ {
+		addStream(streams, fmt.Sprintf("stream-%d", i), &tcpStream{last: start.Add(time.Duration(i+1) * time.Millisecond)})
+	}
+	addStream(streams, "stream-0", &tcpStream{last: start.Add(time.Hour)})
+	if len(streams) != maxTCPStreams {
+		t.Errorf("replacing a stream changed the number of streams: %d", len(streams))
+	}
+
+	// The least recently active stream makes room for the new one
+	addStream(streams, "new", &tcpStream{last: start.Add(time.Hour)})
+	if _, found := streams["stream-1"]; found || len(streams) != maxTCPStreams {
+		t.Errorf("the least recently active stream was not dropped: %d streams", len(streams))
+	}
+
+	evictStreams(streams, start.Add(tcpStreamTimeout))
+	if len(streams) != 2 || streams["new"] == nil || streams["stream-0"] == nil {
+		t.Errorf("expected only the active streams to be kept, got %d", len(streams))
+	}
+}
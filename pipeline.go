@@ -0,0 +1,342 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owasp-amass/asset-db/repository"
+)
+
+var (
+	// ErrPipelineClosed is returned when a record is submitted to a pipeline that was closed.
+	ErrPipelineClosed = errors.New("the pipeline is closed")
+	// ErrQueueFull is returned by TrySubmit when the pipeline queue has no room for the record.
+	ErrQueueFull = errors.New("the pipeline queue is full")
+)
+
+// PipelineOptions configures how a Pipeline writes records into the graph.
+type PipelineOptions struct {
+	// Workers is the number of goroutines writing batches into the graph. On SQLite, the batches are
+	// written one at a time regardless of the number of workers, since the database has a single writer.
+	Workers int
+	// QueueSize is the number of distinct records waiting for a worker before producers are held back.
+	QueueSize int
+	// BatchSize is the number of distinct records a worker writes within each transaction.
+	BatchSize int
+	// FlushInterval is the longest time a record waits in a worker before being written.
+	FlushInterval time.Duration
+}
+
+// DefaultPipelineOptions returns the options used when none are provided to NewPipeline.
+func DefaultPipelineOptions() *PipelineOptions {
+	return &PipelineOptions{
+		Workers:       4,
+		QueueSize:     10000,
+		BatchSize:     500,
+		FlushInterval: time.Second,
+	}
+}
+
+// Record is a DNS resource record, with the data in presentation format, submitted to a Pipeline.
+type Record struct {
+	Name string
+	Type string
+	Data string
+	// At is the time the record was observed. The time of submission is used when it is zero.
+	At time.Time
+}
+
+// PipelineStats contains the metrics of a Pipeline.
+type PipelineStats struct {
+	// QueueDepth is the number of records waiting for a worker.
+	QueueDepth int
+	// MaxQueueDepth is the largest queue depth reached.
+	MaxQueueDepth int
+	// Pending is the number of distinct records submitted that were not written yet.
+	Pending int
+	// Submitted is the number of records accepted by the pipeline.
+	Submitted int
+	// Coalesced is the number of submitted records merged into a pending duplicate.
+	Coalesced int
+	// Rejected is the number of records refused because they were malformed or the queue was full.
+	Rejected int
+	// Written is the number of distinct records written into the graph.
+	Written int
+	// Errors is the number of distinct records that could not be written into the graph.
+	Errors int
+	// Batches is the number of transactions performed by the workers.
+	Batches int
+}
+
+// Pipeline accepts DNS records from many goroutines and writes them into the graph using a pool of workers.
+// The records wait in a bounded queue, and a record that duplicates one waiting to be written is merged
+// into it, extending its observation range, without taking room in the queue.
+type Pipeline struct {
+	sync.Mutex
+	g       *Graph
+	ctx     context.Context
+	opts    PipelineOptions
+	queue   chan *pipelineEntry
+	pending map[string]*pipelineEntry
+	closed  bool
+	// closing is held for reading while records are sent, so the queue is not closed during a send.
+	closing sync.RWMutex
+	// writer serialises the batches on backends with a single writer.
+	writer *sync.Mutex
+	wg     sync.WaitGroup
+	stats  PipelineStats
+}
+
+type pipelineEntry struct {
+	key      string
+	name     string
+	relation string
+	target   string
+	first    time.Time
+	last     time.Time
+}
+
+// NewPipeline returns a Pipeline writing into the graph. The upserts are attributed to the provenance carried by
+// the context, and cancelling the context does not discard the records already accepted: Close writes them.
+func (g *Graph) NewPipeline(ctx context.Context, opts *PipelineOptions) *Pipeline {
+	o := *DefaultPipelineOptions()
+	if opts != nil {
+		if opts.Workers > 0 {
+			o.Workers = opts.Workers
+		}
+		if opts.QueueSize > 0 {
+			o.QueueSize = opts.QueueSize
+		}
+		if opts.BatchSize > 0 {
+			o.BatchSize = opts.BatchSize
+		}
+		if opts.FlushInterval > 0 {
+			o.FlushInterval = opts.FlushInterval
+		}
+	}
+
+	p := &Pipeline{
+		g:       g,
+		ctx:     context.WithoutCancel(ctx),
+		opts:    o,
+		queue:   make(chan *pipelineEntry, o.QueueSize),
+		pending: make(map[string]*pipelineEntry),
+	}
+	if g.dbtype == repository.SQLite {
+		p.writer = new(sync.Mutex)
+	}
+
+	p.wg.Add(o.Workers)
+	for i := 0; i < o.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit adds the record to the pipeline. When the queue is full, Submit waits for room
+// until the context is cancelled. A record duplicating a pending record never waits.
+func (p *Pipeline) Submit(ctx context.Context, rec *Record) error {
+	return p.submit(ctx, rec, true)
+}
+
+// TrySubmit adds the record to the pipeline without waiting, and returns ErrQueueFull when the queue has no room.
+func (p *Pipeline) TrySubmit(rec *Record) error {
+	return p.submit(context.Background(), rec, false)
+}
+
+func (p *Pipeline) submit(ctx context.Context, rec *Record, wait bool) error {
+	// The name is normalized as the importers do, so the records differing only in case or a trailing dot are coalesced
+	name := cleanName(rec.Name)
+	relation, target, err := parseRecord(name, rec.Type, rec.Data)
+	if err != nil {
+		p.Lock()
+		p.stats.Rejected++
+		p.Unlock()
+		return err
+	}
+
+	at := rec.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	at = at.UTC().Truncate(time.Second)
+
+	if wait {
+		p.closing.RLock()
+	} else if !p.closing.TryRLock() {
+		// The pipeline is being closed
+		return ErrPipelineClosed
+	}
+	defer p.closing.RUnlock()
+
+	key := strings.Join([]string{name, relation, target}, "|")
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return ErrPipelineClosed
+	}
+	if e, found := p.pending[key]; found {
+		e.first, e.last = extendRange(e.first, e.last, at)
+		p.stats.Submitted++
+		p.stats.Coalesced++
+		p.Unlock()
+		return nil
+	}
+
+	e := &pipelineEntry{
+		key:      key,
+		name:     name,
+		relation: relation,
+		target:   target,
+		first:    at,
+		last:     at,
+	}
+	p.pending[key] = e
+	p.Unlock()
+
+	if wait {
+		select {
+		case p.queue <- e:
+		case <-ctx.Done():
+			p.reject(e)
+			return ctx.Err()
+		}
+	} else {
+		select {
+		case p.queue <- e:
+		default:
+			p.reject(e)
+			return ErrQueueFull
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.stats.Submitted++
+	if depth := len(p.queue); depth > p.stats.MaxQueueDepth {
+		p.stats.MaxQueueDepth = depth
+	}
+	return nil
+}
+
+// reject removes the entry that could not be queued. Observations merged into it meanwhile are lost.
+func (p *Pipeline) reject(e *pipelineEntry) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.pending[e.key] == e {
+		delete(p.pending, e.key)
+	}
+	p.stats.Rejected++
+}
+
+// Stats returns the current metrics of the pipeline.
+func (p *Pipeline) Stats() *PipelineStats {
+	p.Lock()
+	defer p.Unlock()
+
+	s := p.stats
+	s.QueueDepth = len(p.queue)
+	s.Pending = len(p.pending)
+	return &s
+}
+
+// Close stops the pipeline from accepting records, waits for the workers to write the
+// records already submitted, and returns the final metrics.
+func (p *Pipeline) Close() *PipelineStats {
+	p.closing.Lock()
+	p.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.Unlock()
+	p.closing.Unlock()
+
+	p.wg.Wait()
+	return p.Stats()
+}
+
+func (p *Pipeline) work() {
+	defer p.wg.Done()
+
+	t := time.NewTicker(p.opts.FlushInterval)
+	defer t.Stop()
+
+	var batch []*pipelineEntry
+	for {
+		select {
+		case e, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+
+			batch = append(batch, e)
+			if len(batch) >= p.opts.BatchSize {
+				p.flush(batch)
+				batch = nil
+			}
+		case <-t.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = nil
+			}
+		}
+	}
+}
+
+func (p *Pipeline) flush(entries []*pipelineEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	// The entries stop accepting duplicates once they are taken from the pending set
+	type observation struct {
+		e           *pipelineEntry
+		first, last time.Time
+	}
+	obs := make([]observation, 0, len(entries))
+	p.Lock()
+	for _, e := range entries {
+		delete(p.pending, e.key)
+		obs = append(obs, observation{e: e, first: e.first, last: e.last})
+	}
+	p.Unlock()
+
+	if p.writer != nil {
+		p.writer.Lock()
+		defer p.writer.Unlock()
+	}
+
+	err := p.g.Batch(p.ctx, func(b *Batch) error {
+		for _, o := range obs {
+			b.add(o.e.name, o.e.relation, o.e.target, o.first)
+			b.add(o.e.name, o.e.relation, o.e.target, o.last)
+		}
+		return nil
+	})
+
+	failed := 0
+	var berr *BatchError
+	if errors.As(err, &berr) {
+		failed = len(berr.Items)
+	} else if err != nil {
+		failed = len(obs)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.stats.Batches++
+	p.stats.Written += len(obs) - failed
+	p.stats.Errors += failed
+}
@@ -0,0 +1,126 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := WithProvenance(context.Background(), "resolver", "")
+	p := g.NewPipeline(ctx, &PipelineOptions{
+		Workers:       2,
+		QueueSize:     8,
+		BatchSize:     4,
+		FlushInterval: 10 * time.Millisecond,
+	})
+
+	first := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for producer := 0; producer < 4; producer++ {
+		wg.Add(1)
+		go func(producer int) {
+			defer wg.Done()
+
+			for i := 0; i < 10; i++ {
+				rec := &Record{
+					Name: fmt.Sprintf("host%d.owasp.org", i),
+					Type: "A",
+					Data: fmt.Sprintf("192.0.2.%d", i),
+					At:   first.Add(time.Duration(producer) * time.Hour),
+				}
+				if err := p.Submit(ctx, rec); err != nil {
+					t.Errorf("failed to submit the record: %v", err)
+				}
+			}
+		}(producer)
+	}
+	wg.Wait()
+
+	if err := p.Submit(ctx, &Record{Name: "www.owasp.org", Type: "TXT", Data: "v=spf1"}); err == nil {
+		t.Error("expected the unsupported record to be rejected")
+	}
+
+	stats := p.Close()
+	if stats.Submitted != 40 || stats.Rejected != 1 || stats.Errors != 0 || stats.Pending != 0 || stats.QueueDepth != 0 {
+		t.Errorf("unexpected pipeline stats: %+v", stats)
+	}
+	if stats.Written+stats.Coalesced < 40 || stats.Written < 10 || stats.Batches == 0 {
+		t.Errorf("the records were not all written: %+v", stats)
+	}
+
+	if err := p.Submit(ctx, &Record{Name: "www.owasp.org", Type: "A", Data: "192.0.2.1"}); !errors.Is(err, ErrPipelineClosed) {
+		t.Errorf("expected the closed pipeline to refuse the record: %v", err)
+	}
+	if err := p.TrySubmit(&Record{Name: "www.owasp.org", Type: "A", Data: "192.0.2.1"}); !errors.Is(err, ErrPipelineClosed) {
+		t.Errorf("expected the closed pipeline to refuse the record: %v", err)
+	}
+
+	rels, err := g.DB.RelationQuery("relations WHERE relations.type = 'a_record'")
+	if err != nil || len(rels) != 10 {
+		t.Fatalf("expected 10 A records in the graph: %v", err)
+	}
+	for _, rel := range rels {
+		if !rel.CreatedAt.UTC().Equal(first) || !rel.LastSeen.UTC().Equal(first.Add(3*time.Hour)) {
+			t.Errorf("the observations were not merged: %v - %v", rel.CreatedAt, rel.LastSeen)
+		}
+	}
+}
+
+func TestPipelineFlushOnClose(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := g.NewPipeline(ctx, &PipelineOptions{BatchSize: 1000, FlushInterval: time.Hour})
+
+	if err := p.TrySubmit(&Record{Name: "www.owasp.org", Type: "CNAME", Data: "owasp.github.io."}); err != nil {
+		t.Fatalf("failed to submit the record: %v", err)
+	}
+	if s := p.Stats(); s.Pending != 1 || s.Written != 0 {
+		t.Errorf("expected the record to be pending: %+v", s)
+	}
+
+	// The records accepted before the context was cancelled are still written
+	cancel()
+	if stats := p.Close(); stats.Written != 1 || stats.Pending != 0 {
+		t.Errorf("the pending record was not written on close: %+v", stats)
+	}
+	if !g.IsCNAMENode(context.Background(), "www.owasp.org", time.Time{}) {
+		t.Error("the CNAME record was not written into the graph")
+	}
+}
+
+func TestPipelineNormalizesNames(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	p := g.NewPipeline(ctx, &PipelineOptions{BatchSize: 1000, FlushInterval: time.Hour})
+
+	for _, name := range []string{"www.owasp.org", "WWW.OWASP.ORG", "www.owasp.org."} {
+		if err := p.Submit(ctx, &Record{Name: name, Type: "A", Data: "192.0.2.1"}); err != nil {
+			t.Fatalf("failed to submit the record for %s: %v", name, err)
+		}
+	}
+	if s := p.Stats(); s.Pending != 1 || s.Coalesced != 2 {
+		t.Errorf("expected the names to be coalesced: %+v", s)
+	}
+
+	if stats := p.Close(); stats.Written != 1 || stats.Errors != 0 {
+		t.Errorf("unexpected pipeline stats: %+v", stats)
+	}
+	if pairs, err := g.NamesToAddrs(ctx, time.Time{}, "www.owasp.org"); err != nil || len(pairs) != 1 {
+		t.Errorf("the normalized name was not written: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"math/bits"
+	"net/netip"
+	"sync"
+)
+
+// prefixIndex is an in-memory patricia trie of the netblocks in the graph, keyed by prefix, that
+// supports longest-prefix matching. The index is loaded from the database when first used.
+type prefixIndex struct {
+	sync.RWMutex
+	loaded bool
+	v4     *prefixNode
+	v6     *prefixNode
+}
+
+type prefixNode struct {
+	prefix netip.Prefix
+	// set is false for the nodes that only join the branches of the trie.
+	set   bool
+	id    uint64
+	child [2]*prefixNode
+}
+
+func newPrefixIndex() *prefixIndex {
+	idx := new(prefixIndex)
+	idx.clear()
+	return idx
+}
+
+func (idx *prefixIndex) clear() {
+	idx.v4 = &prefixNode{prefix: netip.PrefixFrom(netip.IPv4Unspecified(), 0)}
+	idx.v6 = &prefixNode{prefix: netip.PrefixFrom(netip.IPv6Unspecified(), 0)}
+}
+
+// reset discards the index, so it is loaded from the database again when next used.
+func (idx *prefixIndex) reset() {
+	idx.Lock()
+	defer idx.Unlock()
+
+	idx.loaded = false
+	idx.clear()
+}
+
+func (idx *prefixIndex) root(addr netip.Addr) *prefixNode {
+	if addr.Is4() {
+		return idx.v4
+	}
+	return idx.v6
+}
+
+// insert adds the prefix and the identifier of its netblock asset to the index. The caller must hold the lock.
+func (idx *prefixIndex) insert(prefix netip.Prefix, id uint64) {
+	prefix = prefix.Masked()
+	leaf := &prefixNode{prefix: prefix, set: true, id: id}
+
+	n := idx.root(prefix.Addr())
+	for {
+		if n.prefix == prefix {
+			n.set, n.id = true, id
+			return
+		}
+
+		b := addrBit(prefix.Addr(), n.prefix.Bits())
+		c := n.child[b]
+		if c == nil {
+			n.child[b] = leaf
+			return
+		}
+		if c.prefix.Bits() <= prefix.Bits() && c.prefix.Contains(prefix.Addr()) {
+			n = c
+			continue
+		}
+
+		common := commonPrefix(c.prefix, prefix)
+		if common == prefix {
+			leaf.child[addrBit(c.prefix.Addr(), prefix.Bits())] = c
+			n.child[b] = leaf
+			return
+		}
+
+		branch := &prefixNode{prefix: common}
+		branch.child[addrBit(c.prefix.Addr(), common.Bits())] = c
+		branch.child[addrBit(prefix.Addr(), common.Bits())] = leaf
+		n.child[b] = branch
+		return
+	}
+}
+
+// remove deletes the prefix from the index. The caller must hold the lock.
+func (idx *prefixIndex) remove(prefix netip.Prefix) {
+	prefix = prefix.Masked()
+
+	for n := idx.root(prefix.Addr()); n != nil && n.prefix.Bits() <= prefix.Bits() &&
+		n.prefix.Contains(prefix.Addr()); n = n.child[addrBit(prefix.Addr(), n.prefix.Bits())] {
+		if n.prefix == prefix {
+			n.set, n.id = false, 0
+			return
+		}
+	}
+}
+
+// match returns the entries of the index that contain the address, from the least to the most specific.
+// The caller must hold the lock for reading.
+func (idx *prefixIndex) match(addr netip.Addr) []*prefixNode {
+	var matches []*prefixNode
+
+	for n := idx.root(addr); n != nil && n.prefix.Contains(addr); {
+		if n.set {
+			matches = append(matches, n)
+		}
+		if n.prefix.Bits() == addr.BitLen() {
+			break
+		}
+		n = n.child[addrBit(addr, n.prefix.Bits())]
+	}
+	return matches
+}
+
+// addrBit returns the bit of the address at the position, counting from the most significant bit.
+func addrBit(addr netip.Addr, pos int) int {
+	b := addr.AsSlice()
+	if pos >= len(b)*8 {
+		return 0
+	}
+	return int(b[pos/8]>>(7-pos%8)) & 1
+}
+
+// commonPrefix returns the longest prefix that contains both prefixes.
+func commonPrefix(a, b netip.Prefix) netip.Prefix {
+	n := a.Bits()
+	if b.Bits() < n {
+		n = b.Bits()
+	}
+
+	x, y := a.Addr().AsSlice(), b.Addr().AsSlice()
+	for i := range x {
+		if d := x[i] ^ y[i]; d != 0 {
+			if same := i*8 + bits.LeadingZeros8(d); same < n {
+				n = same
+			}
+			break
+		}
+	}
+
+	p, _ := a.Addr().Prefix(n)
+	return p
+}
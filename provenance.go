@@ -0,0 +1,329 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+	"gorm.io/gorm"
+)
+
+// Provenance identifies the data source and enumeration run that produced an observation.
+type Provenance struct {
+	// Source is the name of the data source, such as a resolver, certificate transparency log or scraper.
+	Source string
+	// Event identifies the enumeration run that made the observation and is optional.
+	Event string
+	// session is the session set using WithSession, which is attributed along with the event.
+	session string
+}
+
+type provenanceKey struct{}
+
+// WithProvenance returns a copy of the context that causes the Upsert* methods to attribute
+// the assets and relations they write to the data source and enumeration run.
+func WithProvenance(ctx context.Context, source, event string) context.Context {
+	return context.WithValue(ctx, provenanceKey{}, &Provenance{Source: source, Event: event})
+}
+
+// ProvenanceFromContext returns the provenance carried by the context, or nil when there is none.
+func ProvenanceFromContext(ctx context.Context) *Provenance {
+	if p := provenanceFrom(ctx); p != nil && (p.Source != "" || p.Event != "") {
+		return &Provenance{Source: p.Source, Event: p.Event}
+	}
+	return nil
+}
+
+// provenanceFrom returns the provenance and the session carried by the context, or nil when there are none.
+func provenanceFrom(ctx context.Context) *Provenance {
+	if ctx == nil {
+		return nil
+	}
+
+	p := new(Provenance)
+	if v, ok := ctx.Value(provenanceKey{}).(*Provenance); ok {
+		p.Source, p.Event = v.Source, v.Event
+	}
+	p.session, _ = ctx.Value(sessionKey{}).(string)
+
+	if p.Source == "" && p.Event == "" && p.session == "" {
+		return nil
+	}
+	return p
+}
+
+func (g *Graph) attributeAsset(ctx context.Context, a *types.Asset, at time.Time) error {
+	p := provenanceFrom(ctx)
+	if p == nil {
+		return nil
+	}
+
+	id, err := strconv.ParseUint(a.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	return attribute(g.db.WithContext(ctx), "asset_sources", "asset_id", id, p, at)
+}
+
+func (g *Graph) attributeRelation(ctx context.Context, id uint64, at time.Time) error {
+	p := provenanceFrom(ctx)
+	if p == nil {
+		return nil
+	}
+	return attribute(g.db.WithContext(ctx), "relation_sources", "relation_id", id, p, at)
+}
+
+// attribute records the observation for the provenance, extending the first seen / last seen range
+// when the data source already contributed the asset or relation during the same enumeration run.
+// The session is recorded as another enumeration run of the data source.
+func attribute(tx *gorm.DB, table, column string, id uint64, p *Provenance, at time.Time) error {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	ts := at.UTC().Format(sqlTimeFormat)
+
+	var events []string
+	if p.Source != "" || p.Event != "" {
+		events = append(events, p.Event)
+	}
+	if p.session != "" && p.session != p.Event {
+		events = append(events, p.session)
+	}
+
+	for _, event := range events {
+		if err := tx.Exec(`INSERT INTO `+table+` (`+column+`, source, event, created_at, last_seen)
+			VALUES (?, ?, ?, ?, ?) ON CONFLICT (`+column+`, source, event) DO UPDATE SET
+			created_at = CASE WHEN excluded.created_at < `+table+`.created_at
+				THEN excluded.created_at ELSE `+table+`.created_at END,
+			last_seen = CASE WHEN excluded.last_seen > `+table+`.last_seen
+				THEN excluded.last_seen ELSE `+table+`.last_seen END`,
+			id, p.Source, event, ts, ts).Error; err != nil {
+			return backendError(err)
+		}
+	}
+	return nil
+}
+
+// sourceFilter returns the constraint that limits the relations referenced by column to
+// those contributed by at least one of the sources. An empty string is returned when no sources are provided.
+func sourceFilter(column string, sources []string) string {
+	if len(sources) == 0 {
+		return ""
+	}
+
+	escaped := make([]string, 0, len(sources))
+	for _, src := range sources {
+		escaped = append(escaped, sqlEscape(src))
+	}
+	return " AND " + column + " IN (SELECT relation_id FROM relation_sources WHERE source IN ('" +
+		strings.Join(escaped, "','") + "'))"
+}
+
+// NamesToAddrsFromSources returns a NameAddrPair for each name / address combination discovered in the graph
+// using only the DNS records contributed by at least one of the data sources.
+func (g *Graph) NamesToAddrsFromSources(ctx context.Context, since time.Time, sources []string, names ...string) ([]*NameAddrPair, error) {
+	return g.NamesToAddrsFromSourcesInRange(ctx, TimeRange{Since: since}, sources, names...)
+}
+
+// NamesToAddrsFromSourcesInRange returns a NameAddrPair for each name / address combination discovered in the graph
+// using only the DNS records contributed by at least one of the data sources and seen during the time range.
+func (g *Graph) NamesToAddrsFromSourcesInRange(ctx context.Context, tr TimeRange, sources []string, names ...string) ([]*NameAddrPair, error) {
+	if len(sources) == 0 {
+		return nil, invalidInput("no sources were provided")
+	}
+	return g.namesToAddrs(ctx, tr, sources, names...)
+}
+
+// Subdomains returns the names in the graph that are subdomains of the provided domain name.
+// When sources are provided, only the names contributed by at least one of the data sources are returned.
+func (g *Graph) Subdomains(ctx context.Context, domain string, since time.Time, sources ...string) ([]string, error) {
+	return g.SubdomainsInRange(ctx, domain, TimeRange{Since: since}, sources...)
+}
+
+// SubdomainsInRange returns the names in the graph that are subdomains of the provided domain name and were seen
+// during the time range. When sources are provided, only the names contributed by at least one of the data sources are returned.
+func (g *Graph) SubdomainsInRange(ctx context.Context, domain string, tr TimeRange, sources ...string) ([]string, error) {
+	if err := tr.Validate(); err != nil {
+		return nil, err
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	if domain == "" {
+		return nil, invalidInput("no domain name was provided")
+	}
+
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace("." + domain)
+	query := g.db.WithContext(ctx).Table("assets").
+		Select("DISTINCT assets.content->>'name'").
+		Where("assets.type = 'FQDN' AND assets.content->>'name' LIKE ? ESCAPE '\\'", "%"+pattern)
+	if !tr.Since.IsZero() {
+		query = query.Where("assets.last_seen > ?", tr.Since.UTC().Format(sqlTimeFormat))
+	}
+	if !tr.Until.IsZero() {
+		query = query.Where("assets.created_at <= ?", tr.Until.UTC().Format(sqlTimeFormat))
+	}
+	if len(sources) > 0 {
+		query = query.Where("assets.id IN (SELECT asset_id FROM asset_sources WHERE source IN ?)", sources)
+	}
+
+	var names []string
+	if err := query.Scan(&names).Error; err != nil {
+		return nil, backendError(err)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// SourceStats summarizes the contributions of a data source to the graph.
+type SourceStats struct {
+	Source    string
+	Assets    int
+	Relations int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// ReadSourceStats returns the number of assets and relations contributed by each data source,
+// along with the time range of its observations.
+func (g *Graph) ReadSourceStats(ctx context.Context) ([]*SourceStats, error) {
+	stats := make(map[string]*SourceStats)
+
+	for table, column := range map[string]string{
+		"asset_sources":    "asset_id",
+		"relation_sources": "relation_id",
+	} {
+		var rows []struct {
+			Source    string
+			Total     int
+			FirstSeen string
+			LastSeen  string
+		}
+
+		err := g.db.WithContext(ctx).Raw(`SELECT source, COUNT(DISTINCT ` + column + `) AS total,
+			MIN(created_at) AS first_seen, MAX(last_seen) AS last_seen FROM ` + table + ` WHERE source <> '' GROUP BY source`).Scan(&rows).Error
+		if err != nil {
+			return nil, backendError(err)
+		}
+
+		for _, row := range rows {
+			s, found := stats[row.Source]
+			if !found {
+				s = &SourceStats{Source: row.Source}
+				stats[row.Source] = s
+			}
+
+			if table == "asset_sources" {
+				s.Assets = row.Total
+			} else {
+				s.Relations = row.Total
+			}
+			s.FirstSeen, s.LastSeen = mergeRange(s.FirstSeen, s.LastSeen, parseSQLTime(row.FirstSeen), parseSQLTime(row.LastSeen))
+		}
+	}
+
+	results := make([]*SourceStats, 0, len(stats))
+	for _, s := range stats {
+		results = append(results, s)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Source < results[j].Source })
+	return results, nil
+}
+
+// PurgeReport summarizes the assets and relations removed from the graph.
+type PurgeReport struct {
+	Assets    int
+	Relations int
+}
+
+// PurgeSource removes the contributions of the data source from the graph. Assets and relations
+// contributed by other data sources, or observed without provenance, are kept, and only the attribution
+// to the purged source is removed.
+func (g *Graph) PurgeSource(ctx context.Context, source string) (*PurgeReport, error) {
+	if source == "" {
+		return nil, invalidInput("no source was provided")
+	}
+
+	var netblocks int64
+	report := new(PurgeReport)
+	err := g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The rows only attributed to the source were also observed by others, such as the upserts without
+		// provenance, when their first seen / last seen range extends beyond the observations of the source
+		exclusive := func(table, column, target string) string {
+			return `SELECT attr.` + column + ` FROM ` + table + ` AS attr INNER JOIN ` + target +
+				` AS t ON attr.` + column + ` = t.id WHERE attr.source = @source AND attr.` + column +
+				` NOT IN (SELECT ` + column + ` FROM ` + table + ` WHERE source <> @source)
+				GROUP BY attr.` + column + `, t.created_at, t.last_seen
+				HAVING MIN(attr.created_at) <= t.created_at AND MAX(attr.last_seen) >= t.last_seen`
+		}
+		args := map[string]interface{}{"source": source}
+
+		res := tx.Exec(`DELETE FROM relations WHERE id IN (`+
+			exclusive("relation_sources", "relation_id", "relations")+`)`, args)
+		if res.Error != nil {
+			return res.Error
+		}
+		report.Relations += int(res.RowsAffected)
+
+		assets := exclusive("asset_sources", "asset_id", "assets")
+		if err := tx.Raw(`SELECT COUNT(*) FROM assets WHERE type = 'Netblock' AND id IN (`+assets+`)`,
+			args).Scan(&netblocks).Error; err != nil {
+			return err
+		}
+
+		res = tx.Exec(`DELETE FROM relations WHERE from_asset_id IN (`+assets+`) OR to_asset_id IN (`+assets+`)`, args)
+		if res.Error != nil {
+			return res.Error
+		}
+		report.Relations += int(res.RowsAffected)
+
+		res = tx.Exec(`DELETE FROM assets WHERE id IN (`+assets+`)`, args)
+		if res.Error != nil {
+			return res.Error
+		}
+		report.Assets += int(res.RowsAffected)
+
+		if err := tx.Exec(`DELETE FROM relation_sources WHERE source = ?
+			OR relation_id NOT IN (SELECT id FROM relations)`, source).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`DELETE FROM asset_sources WHERE source = ?
+			OR asset_id NOT IN (SELECT id FROM assets)`, source).Error
+	})
+	if err != nil {
+		return nil, backendError(err)
+	}
+	if netblocks > 0 {
+		// The purged netblocks are read again by the netblock index
+		g.index.reset()
+	}
+	return report, nil
+}
+
+// mergeRange returns the time range covering both of the provided ranges, ignoring zero times.
+func mergeRange(first, last, ofirst, olast time.Time) (time.Time, time.Time) {
+	if !ofirst.IsZero() && (first.IsZero() || ofirst.Before(first)) {
+		first = ofirst
+	}
+	if olast.After(last) {
+		last = olast
+	}
+	return first, last
+}
+
+// parseSQLTime parses the timestamps returned by the database drivers, which depend on the column type.
+func parseSQLTime(s string) time.Time {
+	for _, layout := range []string{sqlTimeFormat, time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
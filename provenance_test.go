@@ -0,0 +1,146 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func TestProvenance(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	dns := WithProvenance(ctx, "dns", "run-1")
+	crtsh := WithProvenance(ctx, "crtsh", "run-1")
+	scraper := WithProvenance(ctx, "scraper", "run-2")
+
+	if err := g.UpsertA(dns, "www.owasp.org", "104.16.0.1"); err != nil {
+		t.Fatalf("failed to insert the A record: %v", err)
+	}
+	if err := g.UpsertAAt(crtsh, "www.owasp.org", "104.16.0.1", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("failed to insert the A record: %v", err)
+	}
+	if err := g.UpsertA(crtsh, "api.owasp.org", "104.16.0.2"); err != nil {
+		t.Fatalf("failed to insert the A record: %v", err)
+	}
+	if err := g.UpsertCNAME(scraper, "bogus.owasp.org", "parked.example.net"); err != nil {
+		t.Fatalf("failed to insert the CNAME record: %v", err)
+	}
+	if err := g.UpsertA(scraper, "parked.example.net", "192.0.2.1"); err != nil {
+		t.Fatalf("failed to insert the A record: %v", err)
+	}
+	if _, err := g.UpsertFQDN(ctx, "legacy.owasp.org"); err != nil {
+		t.Fatalf("failed to insert the FQDN: %v", err)
+	}
+
+	if p := ProvenanceFromContext(dns); p == nil || p.Source != "dns" || p.Event != "run-1" {
+		t.Errorf("unexpected provenance in the context: %+v", p)
+	}
+
+	pairs, err := g.NamesToAddrsFromSources(ctx, time.Time{}, []string{"dns"}, "www.owasp.org", "api.owasp.org")
+	if err != nil || len(pairs) != 1 || pairs[0].FQDN.Name != "www.owasp.org" {
+		t.Errorf("expected only the pair contributed by the dns source: %v", err)
+	}
+	if pairs, err := g.NamesToAddrsFromSources(ctx, time.Time{}, []string{"scraper"}, "parked.example.net"); err != nil || len(pairs) != 1 {
+		t.Errorf("failed to obtain the pair contributed by the scraper source: %v", err)
+	}
+
+	if names, err := g.Subdomains(ctx, "owasp.org", time.Time{}); err != nil || len(names) != 4 {
+		t.Errorf("expected four subdomains, got %v: %v", names, err)
+	}
+	if names, err := g.Subdomains(ctx, "owasp.org", time.Time{}, "crtsh"); err != nil ||
+		len(names) != 2 || names[0] != "api.owasp.org" || names[1] != "www.owasp.org" {
+		t.Errorf("expected the subdomains contributed by the crtsh source, got %v: %v", names, err)
+	}
+
+	stats, err := g.ReadSourceStats(ctx)
+	if err != nil || len(stats) != 3 {
+		t.Fatalf("expected statistics for three sources: %v", err)
+	}
+	if s := stats[0]; s.Source != "crtsh" || s.Assets != 5 || s.Relations != 2 || !s.FirstSeen.Before(s.LastSeen) {
+		t.Errorf("unexpected statistics for the crtsh source: %+v", s)
+	}
+
+	report, err := g.PurgeSource(ctx, "scraper")
+	if err != nil {
+		t.Fatalf("failed to purge the source: %v", err)
+	}
+	// bogus.owasp.org, parked.example.net, example.net and 192.0.2.1 were only contributed by the scraper
+	if report.Assets != 4 || report.Relations != 2 {
+		t.Errorf("unexpected purge report: %+v", report)
+	}
+	if assets, err := g.DB.FindByContent(&domain.FQDN{Name: "bogus.owasp.org"}, time.Time{}); err == nil && len(assets) > 0 {
+		t.Error("the FQDN contributed by the purged source is still in the graph")
+	}
+	for _, name := range []string{"owasp.org", "legacy.owasp.org"} {
+		if assets, err := g.DB.FindByContent(&domain.FQDN{Name: name}, time.Time{}); err != nil || len(assets) != 1 {
+			t.Errorf("%s was removed by the purge", name)
+		}
+	}
+	if stats, err := g.ReadSourceStats(ctx); err != nil || len(stats) != 2 {
+		t.Errorf("expected statistics for two sources after the purge: %v", err)
+	}
+}
+
+func TestPurgeSourceUnattributed(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	bad := WithProvenance(ctx, "bad", "")
+	earlier := time.Now().Add(-2 * time.Hour)
+
+	// Observed without provenance before and after the purged source
+	_ = g.UpsertAAt(ctx, "old.example.com", "192.0.2.10", earlier)
+	_ = g.UpsertA(bad, "old.example.com", "192.0.2.10")
+	_, _ = g.UpsertFQDNAt(bad, "later.example.net", earlier)
+	_, _ = g.UpsertFQDN(ctx, "later.example.net")
+	// Only observed by the purged source
+	_, _ = g.UpsertFQDN(bad, "only.example.org")
+
+	report, err := g.PurgeSource(ctx, "bad")
+	if err != nil {
+		t.Fatalf("failed to purge the source: %v", err)
+	}
+	// only.example.org and example.org were only contributed by the purged source
+	if report.Assets != 2 || report.Relations != 0 {
+		t.Errorf("unexpected purge report: %+v", report)
+	}
+
+	for _, name := range []string{"old.example.com", "later.example.net", "example.com", "example.net"} {
+		if assets, err := g.DB.FindByContent(&domain.FQDN{Name: name}, time.Time{}); err != nil || len(assets) != 1 {
+			t.Errorf("%s was removed by the purge", name)
+		}
+	}
+	if pairs, err := g.NamesToAddrs(ctx, time.Time{}, "old.example.com"); err != nil || len(pairs) != 1 {
+		t.Errorf("the A record observed without provenance was removed: %v", err)
+	}
+	if assets, err := g.DB.FindByContent(&domain.FQDN{Name: "only.example.org"}, time.Time{}); err == nil && len(assets) > 0 {
+		t.Error("the FQDN only contributed by the purged source is still in the graph")
+	}
+}
+
+func TestPurgeSourceLookup(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_, _ = g.UpsertNetblock(WithProvenance(ctx, "bad", ""), "10.0.0.0/8")
+	if got, err := g.LookupAddress(ctx, "10.0.0.1"); err != nil || got.Netblock.String() != "10.0.0.0/8" {
+		t.Fatalf("failed to look up the address before the purge: %v: %v", got, err)
+	}
+
+	if _, err := g.PurgeSource(ctx, "bad"); err != nil {
+		t.Fatalf("failed to purge the source: %v", err)
+	}
+	if got, err := g.LookupAddress(ctx, "10.0.0.1"); err == nil {
+		t.Errorf("the purged netblock was still returned by the lookup: %s", got.Netblock)
+	}
+}
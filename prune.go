@@ -0,0 +1,227 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	oam "github.com/owasp-amass/open-asset-model"
+	"gorm.io/gorm"
+)
+
+// defaultPruneBatchSize is the number of rows deleted within each transaction when no batch size is provided.
+const defaultPruneBatchSize = 1000
+
+// PrunePolicy describes how long assets and relations are retained after they were last seen.
+// A zero duration retains the assets or relations forever.
+type PrunePolicy struct {
+	// MaxAge is the retention applied to the relation and asset types without a specific rule.
+	MaxAge time.Duration
+	// RelationMaxAge contains the retention rules for specific relation types, such as "a_record".
+	RelationMaxAge map[string]time.Duration
+	// AssetMaxAge contains the retention rules for orphaned assets of specific types.
+	AssetMaxAge map[oam.AssetType]time.Duration
+	// Now is the reference time for the retention rules. The current time is used when it is zero.
+	Now time.Time
+	// DryRun causes Prune to report what would be deleted without deleting anything.
+	DryRun bool
+	// BatchSize is the number of rows deleted within each transaction.
+	BatchSize int
+}
+
+// PruneReport contains the number of relations and assets deleted by Prune, keyed by type.
+type PruneReport struct {
+	DryRun    bool
+	Relations map[string]int
+	Assets    map[string]int
+}
+
+type pruneRow struct {
+	ID   uint64
+	Type string
+	Name string
+}
+
+// Prune removes the relations that were not seen within their retention period, then removes the assets left without
+// relations that were not seen within their retention period. An apex domain name is kept while any of its subdomains
+// remain in the graph. The deletions are performed in batches, so large databases are not locked for long periods.
+// A nil policy is a zero PrunePolicy, which retains everything.
+func (g *Graph) Prune(ctx context.Context, policy *PrunePolicy) (*PruneReport, error) {
+	var p PrunePolicy
+	if policy != nil {
+		p = *policy
+	}
+	if p.Now.IsZero() {
+		p.Now = time.Now()
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = defaultPruneBatchSize
+	}
+
+	report := &PruneReport{
+		DryRun:    p.DryRun,
+		Relations: make(map[string]int),
+		Assets:    make(map[string]int),
+	}
+
+	stale := staleCondition("r", p.MaxAge, p.RelationMaxAge, p.Now)
+	if err := g.pruneBatches(ctx, &p, "SELECT r.id, r.type FROM relations AS r WHERE "+stale,
+		"r.id", report.Relations, nil, deleteRelations); err != nil {
+		return report, err
+	}
+
+	rules := make(map[string]time.Duration, len(p.AssetMaxAge))
+	for atype, age := range p.AssetMaxAge {
+		rules[string(atype)] = age
+	}
+
+	orphans := "SELECT a.id, a.type, CASE WHEN a.type = 'FQDN' THEN a.content->>'name' ELSE '' END AS name" +
+		" FROM assets AS a WHERE " + staleCondition("a", p.MaxAge, rules, p.Now) + " AND NOT EXISTS (SELECT 1" +
+		" FROM relations AS r WHERE (r.from_asset_id = a.id OR r.to_asset_id = a.id) AND NOT " + stale + ")"
+	err := g.pruneBatches(ctx, &p, orphans, "a.id", report.Assets, func(tx *gorm.DB, rows []pruneRow) ([]pruneRow, error) {
+		var remove []pruneRow
+		for _, row := range rows {
+			if row.Type == string(oam.FQDN) {
+				if found, err := hasSubdomains(tx, row.Name); err != nil {
+					return nil, err
+				} else if found {
+					continue
+				}
+			}
+			remove = append(remove, row)
+		}
+		return remove, nil
+	}, deleteAssets)
+	if report.Assets[string(oam.Netblock)] > 0 && !p.DryRun {
+		// The pruned netblocks are read again by the netblock index
+		g.index.reset()
+	}
+	return report, err
+}
+
+// pruneBatches selects the rows using keyset pagination, removes those rejected by the optional filter and passes
+// the remaining rows to the delete function within a transaction per batch, unless the policy is a dry run.
+func (g *Graph) pruneBatches(ctx context.Context, p *PrunePolicy, query, idcol string, counts map[string]int,
+	filter func(tx *gorm.DB, rows []pruneRow) ([]pruneRow, error), del func(tx *gorm.DB, rows []pruneRow) error) error {
+	var last uint64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var rows []pruneRow
+		err := g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Raw(query+" AND "+idcol+" > ? ORDER BY "+idcol+" LIMIT ?",
+				last, p.BatchSize).Scan(&rows).Error; err != nil || len(rows) == 0 {
+				return err
+			}
+			last = rows[len(rows)-1].ID
+
+			remove := rows
+			if filter != nil {
+				var err error
+				if remove, err = filter(tx, rows); err != nil {
+					return err
+				}
+			}
+			if len(remove) > 0 && !p.DryRun {
+				if err := del(tx, remove); err != nil {
+					return err
+				}
+			}
+
+			for _, row := range remove {
+				counts[row.Type]++
+			}
+			return nil
+		})
+		if err != nil {
+			return backendError(err)
+		}
+		if len(rows) < p.BatchSize {
+			return nil
+		}
+	}
+}
+
+func deleteRelations(tx *gorm.DB, rows []pruneRow) error {
+	ids := rowIDs(rows)
+
+	if err := tx.Exec("DELETE FROM relation_sources WHERE relation_id IN ?", ids).Error; err != nil {
+		return err
+	}
+	return tx.Exec("DELETE FROM relations WHERE id IN ?", ids).Error
+}
+
+func deleteAssets(tx *gorm.DB, rows []pruneRow) error {
+	args := map[string]interface{}{"ids": rowIDs(rows)}
+
+	if err := tx.Exec(`DELETE FROM relation_sources WHERE relation_id IN (SELECT id FROM relations
+		WHERE from_asset_id IN @ids OR to_asset_id IN @ids)`, args).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM relations WHERE from_asset_id IN @ids OR to_asset_id IN @ids", args).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM asset_sources WHERE asset_id IN @ids", args).Error; err != nil {
+		return err
+	}
+	return tx.Exec("DELETE FROM assets WHERE id IN @ids", args).Error
+}
+
+// hasSubdomains returns true when another FQDN in the graph is a subdomain of the name.
+func hasSubdomains(tx *gorm.DB, name string) (bool, error) {
+	var count int64
+	err := tx.Raw("SELECT COUNT(*) FROM assets WHERE type = 'FQDN' AND content->>'name' LIKE ? ESCAPE '\\'",
+		subdomainPattern(name)).Scan(&count).Error
+	return count > 0, err
+}
+
+// subdomainPattern returns the LIKE pattern that matches the subdomains of the name.
+func subdomainPattern(name string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace("."+name)
+}
+
+// staleCondition returns the SQL condition that is true for the rows of the table referenced by alias that were
+// not seen within the retention period of their type, or the default retention when the type has no rule.
+func staleCondition(alias string, maxAge time.Duration, rules map[string]time.Duration, now time.Time) string {
+	types := make([]string, 0, len(rules))
+	for t := range rules {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	var conds []string
+	for _, t := range types {
+		if age := rules[t]; age > 0 {
+			conds = append(conds, "("+alias+".type = '"+sqlEscape(t)+"' AND "+alias+".last_seen < '"+
+				now.Add(-age).UTC().Format(sqlTimeFormat)+"')")
+		}
+	}
+	if maxAge > 0 {
+		cond := "(" + alias + ".last_seen < '" + now.Add(-maxAge).UTC().Format(sqlTimeFormat) + "'"
+		if len(types) > 0 {
+			cond += " AND " + alias + ".type NOT IN (" + sqlStringList(types) + ")"
+		}
+		conds = append(conds, cond+")")
+	}
+
+	if len(conds) == 0 {
+		return "(1 = 0)"
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+func rowIDs(rows []pruneRow) []uint64 {
+	ids := make([]uint64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids
+}
@@ -0,0 +1,112 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func TestPrune(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	now := time.Now()
+	old := now.Add(-90 * 24 * time.Hour)
+	recent := now.Add(-24 * time.Hour)
+
+	_ = g.UpsertAAt(ctx, "stale.owasp.org", "192.0.2.1", old)
+	_ = g.UpsertAAt(ctx, "www.owasp.org", "192.0.2.2", recent)
+	_ = g.UpsertNSAt(ctx, "owasp.org", "ns1.owasp.org", old)
+	_, _ = g.UpsertFQDNAt(ctx, "old.example.com", old)
+
+	if report, err := g.Prune(ctx, nil); err != nil || len(report.Relations) != 0 || len(report.Assets) != 0 {
+		t.Fatalf("the nil policy did not retain everything: %+v: %v", report, err)
+	}
+
+	policy := &PrunePolicy{
+		MaxAge:         30 * 24 * time.Hour,
+		RelationMaxAge: map[string]time.Duration{"ns_record": 365 * 24 * time.Hour},
+		Now:            now,
+		DryRun:         true,
+		BatchSize:      1,
+	}
+
+	expected := &PruneReport{
+		DryRun:    true,
+		Relations: map[string]int{"a_record": 1},
+		Assets:    map[string]int{"FQDN": 2, "IPAddress": 1},
+	}
+	report, err := g.Prune(ctx, policy)
+	if err != nil || !reflect.DeepEqual(report, expected) {
+		t.Fatalf("unexpected dry run report: %+v: %v", report, err)
+	}
+	if assets, err := g.DB.FindByContent(&domain.FQDN{Name: "stale.owasp.org"}, time.Time{}); err != nil || len(assets) != 1 {
+		t.Fatal("the dry run deleted an asset")
+	}
+
+	policy.DryRun = false
+	expected.DryRun = false
+	if report, err := g.Prune(ctx, policy); err != nil || !reflect.DeepEqual(report, expected) {
+		t.Fatalf("unexpected prune report: %+v: %v", report, err)
+	}
+
+	for name, kept := range map[string]bool{
+		"stale.owasp.org": false,
+		"old.example.com": false,
+		"www.owasp.org":   true,
+		"ns1.owasp.org":   true,
+		"owasp.org":       true,
+		// The apex domain is kept while the subdomain remains in the graph
+		"example.com": true,
+	} {
+		assets, err := g.DB.FindByContent(&domain.FQDN{Name: name}, time.Time{})
+		if found := err == nil && len(assets) == 1; found != kept {
+			t.Errorf("expected %s to be kept: %t", name, kept)
+		}
+	}
+	if !g.IsNSNode(ctx, "ns1.owasp.org", time.Time{}) {
+		t.Error("the relation with a longer retention was removed")
+	}
+
+	report, err = g.Prune(ctx, policy)
+	if err != nil || report.Assets["FQDN"] != 1 || len(report.Relations) != 0 {
+		t.Errorf("expected the apex domain to be removed once orphaned: %+v: %v", report, err)
+	}
+
+	if report, err := g.Prune(ctx, &PrunePolicy{}); err != nil || len(report.Assets) != 0 || len(report.Relations) != 0 {
+		t.Errorf("the empty policy removed data: %+v: %v", report, err)
+	}
+}
+
+func TestHasSubdomains(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_, _ = g.UpsertFQDN(ctx, "www.owasp.org")
+
+	if found, err := hasSubdomains(g.db, "owasp.org"); err != nil || !found {
+		t.Errorf("expected owasp.org to have subdomains: %t: %v", found, err)
+	}
+	if found, err := hasSubdomains(g.db, "www.owasp.org"); err != nil || found {
+		t.Errorf("expected www.owasp.org not to have subdomains: %t: %v", found, err)
+	}
+
+	// A failure is not mistaken for a name that has subdomains
+	sqlDB, err := g.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+	if _, err := hasSubdomains(g.db, "owasp.org"); err == nil {
+		t.Error("expected an error when the database is closed")
+	}
+}
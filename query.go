@@ -0,0 +1,162 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/owasp-amass/asset-db/repository"
+	"github.com/owasp-amass/asset-db/types"
+	oam "github.com/owasp-amass/open-asset-model"
+)
+
+// The asset database API does not accept a context, so the graph performs its queries
+// through these equivalents, which stop when the context is cancelled or its deadline passes.
+
+// relationLoadPageSize is the number of assets loaded per query when populating relations.
+const relationLoadPageSize = 500
+
+// rawQuery performs the SQL query and scans the rows into results.
+func (g *Graph) rawQuery(ctx context.Context, query string, results interface{}) error {
+	return backendError(g.db.WithContext(ctx).Raw(query).Scan(results).Error)
+}
+
+// assetQuery is the context aware equivalent of AssetDB.AssetQuery. The constraints
+// follow the FROM keyword and must keep the assets table named assets.
+func (g *Graph) assetQuery(ctx context.Context, constraints string) ([]*types.Asset, error) {
+	if constraints == "" {
+		constraints = "assets"
+	}
+
+	var rows []repository.Asset
+	if err := g.rawQuery(ctx, "SELECT assets.id, assets.created_at, assets.last_seen, assets.type, assets.content FROM "+
+		constraints, &rows); err != nil {
+		return nil, err
+	}
+
+	assets := make([]*types.Asset, 0, len(rows))
+	for i := range rows {
+		if a, err := toAsset(&rows[i]); err == nil {
+			assets = append(assets, a)
+		}
+	}
+	return assets, nil
+}
+
+// relationQuery is the context aware equivalent of AssetDB.RelationQuery. The constraints follow the FROM
+// keyword and must keep the relations table named relations. The assets of the relations are loaded in pages,
+// rather than one at a time.
+func (g *Graph) relationQuery(ctx context.Context, constraints string) ([]*types.Relation, error) {
+	if constraints == "" {
+		constraints = "relations"
+	}
+
+	var rows []relationRow
+	if err := g.rawQuery(ctx, "SELECT relations.id, relations.created_at, relations.last_seen, relations.type,"+
+		" relations.from_asset_id, relations.to_asset_id FROM "+constraints, &rows); err != nil {
+		return nil, err
+	}
+
+	set := make(map[uint64]struct{})
+	var ids []uint64
+	for _, row := range rows {
+		for _, id := range []uint64{row.FromAssetID, row.ToAssetID} {
+			if _, found := set[id]; !found {
+				set[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	assets := make(map[uint64]*types.Asset, len(ids))
+	for start := 0; start < len(ids); start += relationLoadPageSize {
+		end := start + relationLoadPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var page []repository.Asset
+		if err := g.db.WithContext(ctx).Raw("SELECT id, created_at, last_seen, type, content FROM assets WHERE id IN ?",
+			ids[start:end]).Scan(&page).Error; err != nil {
+			return nil, backendError(err)
+		}
+		for i := range page {
+			if a, err := toAsset(&page[i]); err == nil {
+				assets[page[i].ID] = a
+			}
+		}
+	}
+
+	rels := make([]*types.Relation, 0, len(rows))
+	for _, row := range rows {
+		from, found := assets[row.FromAssetID]
+		if !found {
+			continue
+		}
+		to, found := assets[row.ToAssetID]
+		if !found {
+			continue
+		}
+
+		rels = append(rels, &types.Relation{
+			ID:        strconv.FormatUint(row.ID, 10),
+			CreatedAt: row.CreatedAt,
+			LastSeen:  row.LastSeen,
+			Type:      row.Type,
+			FromAsset: from,
+			ToAsset:   to,
+		})
+	}
+	return rels, nil
+}
+
+// findByContent is the context aware equivalent of AssetDB.FindByContent, which only returns assets of the same type.
+func (g *Graph) findByContent(ctx context.Context, asset oam.Asset, since time.Time) ([]*types.Asset, error) {
+	content, err := asset.JSON()
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := (&repository.Asset{Type: string(asset.AssetType()), Content: content}).JSONQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := g.db.WithContext(ctx).Where("type = ?", string(asset.AssetType()))
+	if !since.IsZero() {
+		tx = tx.Where("last_seen > ?", since)
+	}
+
+	var rows []repository.Asset
+	if err := tx.Order("id").Find(&rows, query).Error; err != nil {
+		return nil, backendError(err)
+	}
+
+	assets := make([]*types.Asset, 0, len(rows))
+	for i := range rows {
+		a, err := toAsset(&rows[i])
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, a)
+	}
+	return assets, nil
+}
+
+func toAsset(row *repository.Asset) (*types.Asset, error) {
+	asset, err := row.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Asset{
+		ID:        strconv.FormatUint(row.ID, 10),
+		CreatedAt: row.CreatedAt,
+		LastSeen:  row.LastSeen,
+		Asset:     asset,
+	}, nil
+}
@@ -0,0 +1,60 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCancellation(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	names := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("host%d.owasp.org", i)
+		_ = g.UpsertCNAME(context.Background(), name, "www.owasp.org")
+		names = append(names, name)
+	}
+	_ = g.UpsertA(context.Background(), "www.owasp.org", "192.0.2.1")
+	_ = g.UpsertInfrastructure(context.Background(), 64496, "EXAMPLE-AS", "192.0.2.1", "192.0.2.0/24")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := g.UpsertA(ctx, "api.owasp.org", "192.0.2.2"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the upsert to return context.Canceled: %v", err)
+	}
+	if _, err := g.NamesToAddrs(ctx, time.Time{}, names...); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected NamesToAddrs to return context.Canceled: %v", err)
+	}
+	if _, err := g.ReadASPrefixes(ctx, 64496, time.Time{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected ReadASPrefixes to return context.Canceled: %v", err)
+	}
+	if _, err := g.Prune(ctx, &PrunePolicy{MaxAge: time.Hour}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Prune to return context.Canceled: %v", err)
+	}
+
+	// The CNAME recursions are not started once the deadline has passed
+	dctx, dcancel := context.WithDeadline(context.Background(), time.Now())
+	defer dcancel()
+
+	start := time.Now()
+	_, err := g.NamesToAddrs(dctx, time.Time{}, names...)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected NamesToAddrs to return context.DeadlineExceeded: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("NamesToAddrs took %v to return after the deadline", elapsed)
+	}
+
+	if pairs, err := g.NamesToAddrs(context.Background(), time.Time{}, names...); err != nil || len(pairs) != len(names) {
+		t.Errorf("failed to resolve the names without a deadline: %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"time"
+
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+	"golang.org/x/net/publicsuffix"
+	"gorm.io/gorm"
+)
+
+// RemoveOptions controls what is removed from the graph along with an asset.
+type RemoveOptions struct {
+	// Subdomains causes the subdomains of a name to be removed with it. Without it,
+	// a name that still has subdomains in the graph is not removed.
+	Subdomains bool
+	// Orphans causes the assets left dangling by the removal to be removed as well, such as addresses that
+	// no longer have relations and apex domain names that no longer have subdomains or relations.
+	Orphans bool
+}
+
+// RemoveA removes the A record edge between the FQDN and IP address from the graph.
+func (g *Graph) RemoveA(ctx context.Context, fqdn, addr string) error {
+	return g.removeRecord(ctx, fqdn, "a_record", addr)
+}
+
+// RemoveAAAA removes the AAAA record edge between the FQDN and IP address from the graph.
+func (g *Graph) RemoveAAAA(ctx context.Context, fqdn, addr string) error {
+	return g.removeRecord(ctx, fqdn, "aaaa_record", addr)
+}
+
+// RemoveCNAME removes the CNAME record edge between the FQDNs from the graph.
+func (g *Graph) RemoveCNAME(ctx context.Context, fqdn, target string) error {
+	return g.removeRecord(ctx, fqdn, "cname_record", target)
+}
+
+// RemovePTR removes the PTR record edge between the FQDNs from the graph.
+func (g *Graph) RemovePTR(ctx context.Context, fqdn, target string) error {
+	return g.removeRecord(ctx, fqdn, "ptr_record", target)
+}
+
+// RemoveSRV removes the SRV record edge between the FQDNs from the graph.
+func (g *Graph) RemoveSRV(ctx context.Context, service, target string) error {
+	return g.removeRecord(ctx, service, "srv_record", target)
+}
+
+// RemoveNS removes the NS record edge between the FQDNs from the graph.
+func (g *Graph) RemoveNS(ctx context.Context, fqdn, target string) error {
+	return g.removeRecord(ctx, fqdn, "ns_record", target)
+}
+
+// RemoveMX removes the MX record edge between the FQDNs from the graph.
+func (g *Graph) RemoveMX(ctx context.Context, fqdn, target string) error {
+	return g.removeRecord(ctx, fqdn, "mx_record", target)
+}
+
+func (g *Graph) removeRecord(ctx context.Context, fqdn, relation, target string) error {
+	var to oam.Asset = &domain.FQDN{Name: target}
+
+	if relation == "a_record" || relation == "aaaa_record" {
+		ip, err := netip.ParseAddr(target)
+		if err != nil {
+			return inputError(err)
+		}
+		to = &network.IPAddress{Address: ip, Type: addrType(ip)}
+	}
+	return g.RemoveRelation(ctx, &domain.FQDN{Name: fqdn}, relation, to)
+}
+
+// RemoveRelation retracts the relation between the assets. The assets remain in the graph.
+func (g *Graph) RemoveRelation(ctx context.Context, from oam.Asset, relation string, to oam.Asset) error {
+	src, err := g.findAsset(ctx, from)
+	if err != nil {
+		return err
+	}
+	dst, err := g.findAsset(ctx, to)
+	if err != nil {
+		return err
+	}
+
+	return backendError(g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		args := map[string]interface{}{"from": src, "to": dst, "type": relation}
+		match := "from_asset_id = @from AND to_asset_id = @to AND type = @type"
+
+		if err := tx.Exec("DELETE FROM relation_sources WHERE relation_id IN (SELECT id FROM relations WHERE "+
+			match+")", args).Error; err != nil {
+			return err
+		}
+
+		res := tx.Exec("DELETE FROM relations WHERE "+match, args)
+		if res.Error != nil {
+			return res.Error
+		} else if res.RowsAffected == 0 {
+			return notFound("the %s relation between %s and %s", relation, describe(from), describe(to))
+		}
+		return nil
+	}))
+}
+
+// RemoveFQDN removes the name and its relations from the graph.
+func (g *Graph) RemoveFQDN(ctx context.Context, name string, opts *RemoveOptions) error {
+	if opts == nil {
+		opts = new(RemoveOptions)
+	}
+
+	id, err := g.findAsset(ctx, &domain.FQDN{Name: name})
+	if err != nil {
+		return err
+	}
+
+	err = g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		ids := []uint64{id}
+
+		subs, err := subdomainIDs(tx, name)
+		if err != nil {
+			return err
+		} else if len(subs) > 0 && !opts.Subdomains {
+			return invalidInput("%s has %d subdomains in the graph", name, len(subs))
+		}
+		ids = append(ids, subs...)
+
+		neighbors, err := neighborIDs(tx, ids)
+		if err != nil {
+			return err
+		}
+		if err := removeAssets(tx, ids); err != nil {
+			return err
+		}
+		if !opts.Orphans {
+			return nil
+		}
+
+		apexes, err := apexIDs(tx, []string{name})
+		if err != nil {
+			return err
+		}
+		return removeOrphans(tx, append(neighbors, apexes...))
+	})
+	if err == nil && opts.Orphans {
+		// The netblocks removed as orphans are read again by the netblock index
+		g.index.reset()
+	}
+	return backendError(err)
+}
+
+// RemoveNetblock removes the netblock and its relations from the graph. The addresses and netblocks
+// contained by the netblock are moved to its supernet.
+func (g *Graph) RemoveNetblock(ctx context.Context, cidr string, opts *RemoveOptions) error {
+	if opts == nil {
+		opts = new(RemoveOptions)
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return inputError(err)
+	}
+	prefix = prefix.Masked()
+
+	id, err := g.findAsset(ctx, &network.Netblock{Cidr: prefix, Type: addrType(prefix.Addr())})
+	if err != nil {
+		return err
+	}
+
+	err = g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		neighbors, err := neighborIDs(tx, []uint64{id})
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec(`INSERT INTO relations (type, from_asset_id, to_asset_id, created_at, last_seen)
+			SELECT 'contains', parents.from_asset_id, children.to_asset_id, children.created_at, children.last_seen
+			FROM (relations AS parents INNER JOIN assets AS nbs ON parents.from_asset_id = nbs.id)
+			CROSS JOIN relations AS children WHERE parents.to_asset_id = @id AND parents.type = 'contains'
+			AND nbs.type = 'Netblock' AND children.from_asset_id = @id AND children.type = 'contains'
+			AND NOT EXISTS (SELECT 1 FROM relations AS edges WHERE edges.type = 'contains'
+			AND edges.from_asset_id = parents.from_asset_id AND edges.to_asset_id = children.to_asset_id)`,
+			map[string]interface{}{"id": id}).Error; err != nil {
+			return err
+		}
+		if err := removeAssets(tx, []uint64{id}); err != nil {
+			return err
+		}
+		if opts.Orphans {
+			return removeOrphans(tx, neighbors)
+		}
+		return nil
+	})
+	if err != nil {
+		return backendError(err)
+	}
+
+	if opts.Orphans {
+		// The netblocks removed as orphans are read again by the netblock index
+		g.index.reset()
+	} else {
+		g.index.Lock()
+		g.index.remove(prefix)
+		g.index.Unlock()
+	}
+	return nil
+}
+
+// findAsset returns the identifier of the asset in the graph.
+func (g *Graph) findAsset(ctx context.Context, asset oam.Asset) (uint64, error) {
+	assets, err := g.findByContent(ctx, asset, time.Time{})
+	if err != nil {
+		return 0, err
+	} else if len(assets) > 0 {
+		return strconv.ParseUint(assets[0].ID, 10, 64)
+	}
+	return 0, notFound("the %s", describe(asset))
+}
+
+// describe returns the asset type followed by its content for use in error messages.
+func describe(asset oam.Asset) string {
+	content, err := asset.JSON()
+	if err != nil {
+		return string(asset.AssetType())
+	}
+	return string(asset.AssetType()) + " " + string(content)
+}
+
+// subdomainIDs returns the identifiers of the FQDNs in the graph that are subdomains of the name.
+func subdomainIDs(tx *gorm.DB, name string) ([]uint64, error) {
+	var ids []uint64
+	err := tx.Raw("SELECT id FROM assets WHERE type = 'FQDN' AND content->>'name' LIKE ? ESCAPE '\\'",
+		subdomainPattern(name)).Scan(&ids).Error
+	return ids, err
+}
+
+// apexIDs returns the identifiers of the apex domain names of the names, not including the names themselves.
+func apexIDs(tx *gorm.DB, names []string) ([]uint64, error) {
+	var apexes []string
+	for _, name := range names {
+		if apex, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil && apex != name {
+			apexes = append(apexes, apex)
+		}
+	}
+	if len(apexes) == 0 {
+		return nil, nil
+	}
+
+	var ids []uint64
+	err := tx.Raw("SELECT id FROM assets WHERE type = 'FQDN' AND content->>'name' IN ?", apexes).Scan(&ids).Error
+	return ids, err
+}
+
+// neighborIDs returns the identifiers of the assets related to the provided assets.
+func neighborIDs(tx *gorm.DB, ids []uint64) ([]uint64, error) {
+	var neighbors []uint64
+
+	err := tx.Raw(`SELECT to_asset_id FROM relations WHERE from_asset_id IN @ids
+		UNION SELECT from_asset_id FROM relations WHERE to_asset_id IN @ids`,
+		map[string]interface{}{"ids": ids}).Scan(&neighbors).Error
+	return neighbors, err
+}
+
+// removeAssets deletes the assets along with their relations and provenance.
+func removeAssets(tx *gorm.DB, ids []uint64) error {
+	rows := make([]pruneRow, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, pruneRow{ID: id})
+	}
+	return deleteAssets(tx, rows)
+}
+
+// removeOrphans deletes the assets, among the candidates, that were left dangling. Removing an orphan can leave
+// other assets dangling, such as the organization managing an autonomous system, so the candidates are
+// checked until no more orphans are found.
+func removeOrphans(tx *gorm.DB, candidates []uint64) error {
+	for len(candidates) > 0 {
+		var orphans []uint64
+
+		var names []string
+
+		for _, id := range candidates {
+			var asset pruneRow
+			if err := tx.Raw("SELECT id, type, CASE WHEN type = 'FQDN' THEN content->>'name' ELSE '' END AS name"+
+				" FROM assets WHERE id = ?", id).Scan(&asset).Error; err != nil {
+				return err
+			} else if asset.Type == "" {
+				continue
+			}
+
+			// An autonomous system only described by its managing organization is dangling
+			var count int64
+			if err := tx.Raw(`SELECT COUNT(*) FROM relations WHERE (from_asset_id = @id OR to_asset_id = @id)
+				AND NOT (type = 'managed_by' AND from_asset_id = @id)`,
+				map[string]interface{}{"id": id}).Scan(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			} else if asset.Type == string(oam.FQDN) {
+				if found, err := hasSubdomains(tx, asset.Name); err != nil {
+					return err
+				} else if found {
+					continue
+				}
+			}
+			orphans = append(orphans, id)
+			if asset.Type == string(oam.FQDN) {
+				names = append(names, asset.Name)
+			}
+		}
+		if len(orphans) == 0 {
+			return nil
+		}
+
+		next, err := neighborIDs(tx, orphans)
+		if err != nil {
+			return err
+		}
+		// The apex domain name can be left dangling by the removal of its last subdomain
+		apexes, err := apexIDs(tx, names)
+		if err != nil {
+			return err
+		}
+		next = append(next, apexes...)
+
+		if err := removeAssets(tx, orphans); err != nil {
+			return err
+		}
+		candidates = next
+	}
+	return nil
+}
+
+func addrType(ip netip.Addr) string {
+	if ip.Is4() {
+		return "IPv4"
+	}
+	return "IPv6"
+}
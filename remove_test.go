@@ -0,0 +1,162 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	oam "github.com/owasp-amass/open-asset-model"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+func TestRemoveRecords(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.owasp.org", "192.0.2.1")
+	_ = g.UpsertCNAME(ctx, "docs.owasp.org", "www.owasp.org")
+
+	if err := g.RemoveA(ctx, "www.owasp.org", "192.0.2.1"); err != nil {
+		t.Fatalf("failed to remove the A record: %v", err)
+	}
+	if err := g.RemoveA(ctx, "www.owasp.org", "192.0.2.1"); err == nil {
+		t.Error("expected an error when removing a missing A record")
+	}
+	if err := g.RemoveCNAME(ctx, "docs.owasp.org", "www.owasp.org"); err != nil {
+		t.Fatalf("failed to remove the CNAME record: %v", err)
+	}
+	if g.IsCNAMENode(ctx, "docs.owasp.org", time.Time{}) {
+		t.Error("the CNAME record remains in the graph")
+	}
+
+	// The assets remain after the relations are retracted
+	if !assetExists(g, &network.IPAddress{Address: netip.MustParseAddr("192.0.2.1"), Type: "IPv4"}) {
+		t.Error("the address was removed with the A record")
+	}
+	if !assetExists(g, &domain.FQDN{Name: "docs.owasp.org"}) {
+		t.Error("the name was removed with the CNAME record")
+	}
+
+	if err := g.RemoveRelation(ctx, &domain.FQDN{Name: "missing.owasp.org"}, "a_record",
+		&network.IPAddress{Address: netip.MustParseAddr("192.0.2.1"), Type: "IPv4"}); err == nil {
+		t.Error("expected an error when the asset is missing")
+	}
+}
+
+func TestRemoveFQDN(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.owasp.org", "192.0.2.1")
+	_ = g.UpsertA(ctx, "api.www.owasp.org", "192.0.2.2")
+	_ = g.UpsertA(ctx, "mail.owasp.org", "192.0.2.2")
+	_ = g.UpsertCNAME(ctx, "docs.example.com", "www.owasp.org")
+
+	if err := g.RemoveFQDN(ctx, "www.owasp.org", nil); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput when the name has subdomains: %v", err)
+	}
+	if err := g.RemoveFQDN(ctx, "www.owasp.org", &RemoveOptions{Subdomains: true, Orphans: true}); err != nil {
+		t.Fatalf("failed to remove the name: %v", err)
+	}
+
+	for name, kept := range map[string]bool{
+		"www.owasp.org":     false,
+		"api.www.owasp.org": false,
+		// The apex domain is kept while another subdomain remains in the graph
+		"owasp.org":      true,
+		"mail.owasp.org": true,
+		// The alias and its apex domain were left dangling
+		"docs.example.com": false,
+		"example.com":      false,
+	} {
+		if assetExists(g, &domain.FQDN{Name: name}) != kept {
+			t.Errorf("expected %s to be kept: %t", name, kept)
+		}
+	}
+	if assetExists(g, &network.IPAddress{Address: netip.MustParseAddr("192.0.2.1"), Type: "IPv4"}) {
+		t.Error("the orphaned address was not removed")
+	}
+	if !assetExists(g, &network.IPAddress{Address: netip.MustParseAddr("192.0.2.2"), Type: "IPv4"}) {
+		t.Error("the address still resolved by another name was removed")
+	}
+
+	if err := g.RemoveFQDN(ctx, "mail.owasp.org", &RemoveOptions{Orphans: true}); err != nil {
+		t.Fatalf("failed to remove the name: %v", err)
+	}
+	if assetExists(g, &domain.FQDN{Name: "owasp.org"}) {
+		t.Error("the apex domain was not removed once it was left dangling")
+	}
+}
+
+func TestRemoveNetblock(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.owasp.org", "192.0.2.1")
+	_ = g.UpsertInfrastructure(ctx, 64496, "EXAMPLE-AS", "192.0.2.1", "192.0.2.0/24")
+	_ = g.UpsertInfrastructure(ctx, 64496, "EXAMPLE-AS", "192.0.2.200", "192.0.2.0/24")
+
+	if err := g.RemoveNetblock(ctx, "198.51.100.0/24", nil); err == nil {
+		t.Error("expected an error when the netblock is missing")
+	}
+	if err := g.RemoveNetblock(ctx, "192.0.2.0/24", &RemoveOptions{Orphans: true}); err != nil {
+		t.Fatalf("failed to remove the netblock: %v", err)
+	}
+
+	for asset, kept := range map[oam.Asset]bool{
+		&network.Netblock{Cidr: netip.MustParsePrefix("192.0.2.0/24"), Type: "IPv4"}:  false,
+		&network.IPAddress{Address: netip.MustParseAddr("192.0.2.1"), Type: "IPv4"}:   true,
+		&network.IPAddress{Address: netip.MustParseAddr("192.0.2.200"), Type: "IPv4"}: false,
+		&network.AutonomousSystem{Number: 64496}:                                      false,
+		&network.RIROrganization{Name: "EXAMPLE-AS"}:                                  false,
+	} {
+		if assetExists(g, asset) != kept {
+			t.Errorf("expected %s to be kept: %t", describe(asset), kept)
+		}
+	}
+}
+
+func TestRemoveNetblockDuplicateEdges(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	outer, _ := g.UpsertNetblock(ctx, "10.0.0.0/8")
+	_, _ = g.UpsertNetblock(ctx, "10.1.0.0/16")
+	addr, err := g.UpsertAddress(ctx, "10.1.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The supernet already contains the address, e.g. from an earlier observation
+	if err := g.linkAt(ctx, outer, "contains", addr, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.RemoveNetblock(ctx, "10.1.0.0/16", nil); err != nil {
+		t.Fatalf("failed to remove the netblock: %v", err)
+	}
+
+	var count int64
+	if err := g.db.Raw(`SELECT COUNT(*) FROM relations WHERE type = 'contains' AND from_asset_id = ?
+		AND to_asset_id = ?`, outer.ID, addr.ID).Scan(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected one contains relation from the supernet to the address, got %d", count)
+	}
+}
+
+func assetExists(g *Graph, asset oam.Asset) bool {
+	_, err := g.findAsset(context.Background(), asset)
+	return err == nil
+}
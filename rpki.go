@@ -0,0 +1,398 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// roaBatchSize is the number of validated ROA payloads inserted by each statement.
+const roaBatchSize = 500
+
+// ROVState is the route origin validation state of an announcement, as defined by RFC 6811.
+type ROVState string
+
+// The route origin validation states. An announcement is not found when no ROA covers its prefix, valid when
+// a covering ROA authorizes its origin autonomous system for the prefix length, and invalid otherwise.
+const (
+	ROVValid    ROVState = "valid"
+	ROVInvalid  ROVState = "invalid"
+	ROVNotFound ROVState = "not-found"
+)
+
+// ROA is a validated ROA payload, which authorizes the autonomous system to originate the prefix
+// and the more specific prefixes up to the maximum length.
+type ROA struct {
+	ASN         int
+	Prefix      netip.Prefix
+	MaxLength   int
+	TrustAnchor string
+}
+
+// RouteValidation is the route origin validation state of a netblock announced by an autonomous system.
+type RouteValidation struct {
+	Netblock string
+	ASN      int
+	State    ROVState
+	// ROAs are the validated ROA payloads covering the netblock.
+	ROAs []*ROA
+}
+
+type roaDocument struct {
+	ROAs []roaRecord `json:"roas"`
+}
+
+// roaRecord is a validated ROA payload of the JSON exports, where rpki-client writes the AS number
+// as a number and routinator writes it as a string starting with AS.
+type roaRecord struct {
+	ASN       json.RawMessage `json:"asn"`
+	Prefix    string          `json:"prefix"`
+	MaxLength int             `json:"maxLength"`
+	TA        string          `json:"ta"`
+}
+
+type roaRow struct {
+	ASN         int64
+	Prefix      string
+	MaxLength   int
+	TrustAnchor string
+}
+
+// ImportROAs reads the validated ROA payloads exported by rpki-client or routinator, either as a JSON document
+// with a roas array or as CSV with the ASN, IP Prefix, Max Length and Trust Anchor columns, and replaces the
+// payloads previously imported into the graph. For JSON documents, the lines of the report are the positions
+// of the payloads in the roas array. Malformed payloads are skipped and listed in the returned report.
+func (g *Graph) ImportROAs(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	report := new(ImportReport)
+	br := bufio.NewReader(r)
+
+	var err error
+	var roas []*ROA
+	if skipSpace(br) == '{' {
+		roas, err = readROAJSON(ctx, br, report)
+	} else {
+		roas, err = readROACSV(ctx, br, report)
+	}
+	if err != nil {
+		return report, err
+	}
+	// Replacing the payloads with an empty set would disable the validation
+	if len(roas) == 0 {
+		return report, invalidInput("the input does not contain validated ROA payloads")
+	}
+
+	err = g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM roas").Error; err != nil {
+			return err
+		}
+
+		for start := 0; start < len(roas); start += roaBatchSize {
+			end := start + roaBatchSize
+			if end > len(roas) {
+				end = len(roas)
+			}
+
+			values := make([]string, 0, end-start)
+			args := make([]interface{}, 0, 4*(end-start))
+			for _, roa := range roas[start:end] {
+				values = append(values, "(?, ?, ?, ?)")
+				args = append(args, roa.ASN, roa.Prefix.String(), roa.MaxLength, roa.TrustAnchor)
+			}
+			if err := tx.Exec("INSERT INTO roas (asn, prefix, max_length, trust_anchor) VALUES "+
+				strings.Join(values, ", "), args...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, backendError(err)
+	}
+
+	report.Records = len(roas)
+	return report, nil
+}
+
+func readROAJSON(ctx context.Context, r io.Reader, report *ImportReport) ([]*ROA, error) {
+	var doc roaDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, invalidInput("the ROA document is malformed: %v", err)
+	}
+
+	set := newROAList(report)
+	for i, rec := range doc.ROAs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Lines++
+
+		set.add(i+1, strings.Trim(string(rec.ASN), `"`), rec.Prefix, rec.MaxLength, rec.TA)
+	}
+	return set.roas, nil
+}
+
+func readROACSV(ctx context.Context, r io.Reader, report *ImportReport) ([]*ROA, error) {
+	set := newROAList(report)
+
+	err := scanLines(ctx, r, func(n int, line []byte) {
+		report.Lines++
+
+		fields := strings.Split(strings.TrimRight(string(line), "\r"), ",")
+		if strings.EqualFold(strings.TrimSpace(fields[0]), "ASN") {
+			return
+		}
+		if len(fields) < 3 {
+			report.skip(n, fmt.Errorf("the line has %d fields instead of at least 3", len(fields)))
+			return
+		}
+
+		var maxLength int
+		if s := strings.TrimSpace(fields[2]); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				report.skip(n, err)
+				return
+			}
+			maxLength = v
+		}
+
+		var ta string
+		if len(fields) > 3 {
+			ta = fields[3]
+		}
+		set.add(n, fields[0], fields[1], maxLength, ta)
+	})
+	return set.roas, err
+}
+
+// roaList collects the distinct validated ROA payloads read from an export.
+type roaList struct {
+	report *ImportReport
+	seen   map[ROA]struct{}
+	roas   []*ROA
+}
+
+func newROAList(report *ImportReport) *roaList {
+	return &roaList{report: report, seen: make(map[ROA]struct{})}
+}
+
+// add parses the payload found at line n. A missing maximum length is the length of the prefix.
+// The same payload published by several trust anchors is only kept once.
+func (l *roaList) add(n int, asn, prefix string, maxLength int, ta string) {
+	num, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS"), 10, 32)
+	if err != nil {
+		l.report.skip(n, fmt.Errorf("%q is not a valid AS number", asn))
+		return
+	}
+
+	p, err := netip.ParsePrefix(strings.TrimSpace(prefix))
+	if err != nil {
+		l.report.skip(n, err)
+		return
+	}
+	p = p.Masked()
+
+	if maxLength == 0 {
+		maxLength = p.Bits()
+	}
+	if maxLength < p.Bits() || maxLength > p.Addr().BitLen() {
+		l.report.skip(n, fmt.Errorf("the maximum length %d is not valid for %s", maxLength, p))
+		return
+	}
+
+	key := ROA{ASN: int(num), Prefix: p, MaxLength: maxLength}
+	if _, found := l.seen[key]; found {
+		l.report.Duplicates++
+		return
+	}
+	l.seen[key] = struct{}{}
+
+	key.TrustAnchor = strings.TrimSpace(ta)
+	l.roas = append(l.roas, &key)
+}
+
+// ValidateRoute returns the route origin validation state of the prefix originated by the autonomous system,
+// using the validated ROA payloads imported into the graph. The announcement does not need to be in the graph.
+func (g *Graph) ValidateRoute(ctx context.Context, cidr string, asn int) (*RouteValidation, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, inputError(err)
+	}
+	prefix = prefix.Masked()
+
+	var cidrs []string
+	for bits := 0; bits <= prefix.Bits(); bits++ {
+		if p, err := prefix.Addr().Prefix(bits); err == nil {
+			cidrs = append(cidrs, p.String())
+		}
+	}
+
+	roas, err := g.readROAs(ctx, " WHERE prefix IN ("+sqlStringList(cidrs)+")")
+	if err != nil {
+		return nil, err
+	}
+	return validateRoute(prefix, asn, roas), nil
+}
+
+// ValidateAnnouncements returns the route origin validation state of every announces edge between an autonomous
+// system and a netblock in the graph, when both were seen after since. The results are sorted by netblock.
+func (g *Graph) ValidateAnnouncements(ctx context.Context, since time.Time) ([]*RouteValidation, error) {
+	return g.ValidateAnnouncementsInRange(ctx, TimeRange{Since: since})
+}
+
+// ValidateAnnouncementsInRange returns the route origin validation state of every announces edge between an
+// autonomous system and a netblock seen during the time range. The results are sorted by netblock.
+func (g *Graph) ValidateAnnouncementsInRange(ctx context.Context, tr TimeRange) ([]*RouteValidation, error) {
+	if err := tr.Validate(); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ASN  int
+		Cidr string
+	}
+	if err := g.db.WithContext(ctx).Raw("SELECT DISTINCT asns.content->>'number' AS asn," +
+		" netblocks.content->>'cidr' AS cidr FROM relations INNER JOIN assets AS asns ON relations.from_asset_id = asns.id" +
+		" INNER JOIN assets AS netblocks ON relations.to_asset_id = netblocks.id" +
+		" WHERE relations.type = 'announces' AND asns.type = 'ASN' AND netblocks.type = 'Netblock'" +
+		tr.constraint("relations") + tr.constraint("asns") + tr.constraint("netblocks")).Scan(&rows).Error; err != nil {
+		return nil, backendError(err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	roas, err := g.readROAs(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	covering := newROASet(roas)
+
+	type announcement struct {
+		prefix netip.Prefix
+		asn    int
+	}
+	anns := make([]announcement, 0, len(rows))
+	for _, row := range rows {
+		if prefix, err := netip.ParsePrefix(row.Cidr); err == nil {
+			anns = append(anns, announcement{prefix: prefix.Masked(), asn: row.ASN})
+		}
+	}
+	sort.Slice(anns, func(i, j int) bool {
+		if c := comparePrefixes(anns[i].prefix, anns[j].prefix); c != 0 {
+			return c < 0
+		}
+		return anns[i].asn < anns[j].asn
+	})
+
+	results := make([]*RouteValidation, 0, len(anns))
+	for _, ann := range anns {
+		results = append(results, validateRoute(ann.prefix, ann.asn, covering.covering(ann.prefix)))
+	}
+	return results, nil
+}
+
+// readROAs returns the validated ROA payloads that satisfy the SQL condition.
+func (g *Graph) readROAs(ctx context.Context, where string) ([]*ROA, error) {
+	var rows []roaRow
+	if err := g.db.WithContext(ctx).Raw("SELECT asn, prefix, max_length, trust_anchor FROM roas" +
+		where + " ORDER BY id").Scan(&rows).Error; err != nil {
+		return nil, backendError(err)
+	}
+
+	roas := make([]*ROA, 0, len(rows))
+	for _, row := range rows {
+		if prefix, err := netip.ParsePrefix(row.Prefix); err == nil {
+			roas = append(roas, &ROA{
+				ASN:         int(row.ASN),
+				Prefix:      prefix,
+				MaxLength:   row.MaxLength,
+				TrustAnchor: row.TrustAnchor,
+			})
+		}
+	}
+	return roas, nil
+}
+
+// validateRoute applies the route origin validation procedure of RFC 6811 to the prefix originated
+// by the autonomous system, using the validated ROA payloads covering the prefix.
+func validateRoute(prefix netip.Prefix, asn int, roas []*ROA) *RouteValidation {
+	v := &RouteValidation{Netblock: prefix.String(), ASN: asn, State: ROVNotFound, ROAs: roas}
+	if len(roas) == 0 {
+		return v
+	}
+
+	v.State = ROVInvalid
+	for _, roa := range roas {
+		// An AS 0 ROA states that the prefix must not be originated
+		if roa.ASN != 0 && roa.ASN == asn && prefix.Bits() <= roa.MaxLength {
+			v.State = ROVValid
+			break
+		}
+	}
+	return v
+}
+
+// roaSet indexes the validated ROA payloads by prefix, so the payloads covering an announcement are found
+// without reading them from the database again. The index is not shared, so it is used without locking.
+type roaSet struct {
+	index  *prefixIndex
+	groups [][]*ROA
+}
+
+func newROASet(roas []*ROA) *roaSet {
+	s := &roaSet{index: newPrefixIndex()}
+
+	ids := make(map[netip.Prefix]int)
+	for _, roa := range roas {
+		id, found := ids[roa.Prefix]
+		if !found {
+			id = len(s.groups)
+			ids[roa.Prefix] = id
+			s.groups = append(s.groups, nil)
+			s.index.insert(roa.Prefix, uint64(id))
+		}
+		s.groups[id] = append(s.groups[id], roa)
+	}
+	return s
+}
+
+// covering returns the validated ROA payloads whose prefix contains the provided prefix.
+func (s *roaSet) covering(prefix netip.Prefix) []*ROA {
+	var roas []*ROA
+
+	for _, n := range s.index.match(prefix.Addr()) {
+		if n.prefix.Bits() <= prefix.Bits() {
+			roas = append(roas, s.groups[n.id]...)
+		}
+	}
+	return roas
+}
+
+// skipSpace discards the leading white space of the reader and returns the next byte without consuming it.
+func skipSpace(br *bufio.Reader) byte {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if !unicode.IsSpace(rune(b)) {
+			_ = br.UnreadByte()
+			return b
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportROAs(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	for _, tc := range []struct {
+		path                         string
+		records, duplicates, skipped int
+	}{
+		{"testdata/rpki/rpki-client.json", 5, 1, 1},
+		{"testdata/rpki/routinator.json", 2, 0, 1},
+		{"testdata/rpki/routinator.csv", 3, 1, 2},
+	} {
+		report := importDataset(t, g, g.ImportROAs, tc.path)
+		if report.Records != tc.records || report.Duplicates != tc.duplicates || len(report.Skipped) != tc.skipped {
+			t.Errorf("%s: unexpected report: %d records, %d duplicates and %d skipped",
+				tc.path, report.Records, report.Duplicates, len(report.Skipped))
+		}
+
+		roas, err := g.readROAs(context.Background(), "")
+		if err != nil || len(roas) != tc.records {
+			t.Errorf("%s: expected the %d imported payloads to replace the others, got %d: %v",
+				tc.path, tc.records, len(roas), err)
+		}
+	}
+
+	ctx := context.Background()
+	for _, input := range []string{"ASN,IP Prefix,Max Length,Trust Anchor\n", `{"roas": [`, ""} {
+		if _, err := g.ImportROAs(ctx, strings.NewReader(input)); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("expected ErrInvalidInput for %q: %v", input, err)
+		}
+	}
+	if roas, err := g.readROAs(ctx, ""); err != nil || len(roas) != 3 {
+		t.Errorf("a failed import replaced the payloads: %d: %v", len(roas), err)
+	}
+}
+
+func TestRouteOriginValidation(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = importDataset(t, g, g.ImportROAs, "testdata/rpki/rpki-client.json")
+
+	want := map[string]ROVState{
+		"1.1.1.0/24 13335":          ROVValid,
+		"203.0.113.0/25 64500":      ROVValid,
+		"203.0.113.0/24 64666":      ROVInvalid,
+		"198.51.100.0/24 64510":     ROVInvalid,
+		"192.0.2.0/24 64520":        ROVNotFound,
+		"2001:db8:1::/48 64501":     ROVValid,
+		"2001:db8:1:1::/64 64501":   ROVInvalid,
+		"2001:db8:ffff::/48 64502":  ROVInvalid,
+		"2001:db8:1:1::/64 4200000": ROVInvalid,
+	}
+	for _, ann := range []struct {
+		asn        int
+		addr, cidr string
+	}{
+		{13335, "1.1.1.1", "1.1.1.0/24"},
+		{64500, "203.0.113.1", "203.0.113.0/25"},
+		{64666, "203.0.113.200", "203.0.113.0/24"},
+		{64510, "198.51.100.1", "198.51.100.0/24"},
+		{64520, "192.0.2.1", "192.0.2.0/24"},
+		{64501, "2001:db8:1::1", "2001:db8:1::/48"},
+		{64501, "2001:db8:1:1::1", "2001:db8:1:1::/64"},
+		{64502, "2001:db8:ffff::1", "2001:db8:ffff::/48"},
+		{4200000, "2001:db8:1:1::2", "2001:db8:1:1::/64"},
+	} {
+		if err := g.UpsertInfrastructure(ctx, ann.asn, "EXAMPLE", ann.addr, ann.cidr); err != nil {
+			t.Fatalf("failed to upsert %s: %v", ann.cidr, err)
+		}
+	}
+
+	results, err := g.ValidateAnnouncements(ctx, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(want) {
+		t.Errorf("expected %d announcements, got %d", len(want), len(results))
+	}
+	for _, v := range results {
+		key := fmt.Sprintf("%s %d", v.Netblock, v.ASN)
+		if v.State != want[key] {
+			t.Errorf("%s: expected: %s, got: %s", key, want[key], v.State)
+		}
+		if (v.State == ROVNotFound) != (len(v.ROAs) == 0) {
+			t.Errorf("%s: unexpected covering payloads: %v", key, v.ROAs)
+		}
+	}
+
+	for _, tc := range []struct {
+		cidr  string
+		asn   int
+		state ROVState
+	}{
+		{"1.1.1.0/24", 13335, ROVValid},
+		{"1.1.1.0/25", 13335, ROVInvalid},
+		{"1.0.0.0/24", 64500, ROVInvalid},
+		{"10.0.0.0/8", 64500, ROVNotFound},
+		{"2001:db8:abcd::/48", 64501, ROVValid},
+	} {
+		v, err := g.ValidateRoute(ctx, tc.cidr, tc.asn)
+		if err != nil {
+			t.Fatalf("failed to validate %s: %v", tc.cidr, err)
+		}
+		if v.State != tc.state {
+			t.Errorf("%s %d: expected: %s, got: %s", tc.cidr, tc.asn, tc.state, v.State)
+		}
+	}
+	if _, err := g.ValidateRoute(ctx, "1.1.1.0/33", 13335); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a malformed prefix: %v", err)
+	}
+
+	if results, err := g.ValidateAnnouncementsInRange(ctx,
+		TimeRange{Until: time.Now().Add(-time.Hour)}); err != nil || len(results) != 0 {
+		t.Errorf("expected no announcements before they were seen, got %d: %v", len(results), err)
+	}
+
+	_ = importDataset(t, g, g.ImportROAs, "testdata/rpki/routinator.csv")
+	if v, err := g.ValidateRoute(ctx, "198.51.100.0/24", 64510); err != nil || v.State != ROVNotFound {
+		t.Errorf("the payloads were not replaced: %v: %v", v, err)
+	}
+}
+
+func TestAddressCSVROV(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "one.one.one.one", "1.1.1.1")
+	_ = g.UpsertA(ctx, "invalid.example.com", "203.0.113.200")
+	_ = g.UpsertA(ctx, "unannounced.example.com", "192.0.2.1")
+	_ = g.UpsertInfrastructure(ctx, 13335, "CLOUDFLARENET", "1.1.1.1", "1.1.1.0/24")
+	_ = g.UpsertInfrastructure(ctx, 64666, "EXAMPLE", "203.0.113.200", "203.0.113.0/24")
+
+	var buf bytes.Buffer
+	if err := g.WriteAddressCSV(ctx, &buf, time.Time{}, nil, "one.one.one.one"); err != nil {
+		t.Fatalf("failed to write the address report: %v", err)
+	}
+	if strings.Contains(buf.String(), string(ColumnROV)) {
+		t.Errorf("the default columns include the validation state: %s", buf.String())
+	}
+
+	buf.Reset()
+	cols := []CSVColumn{ColumnName, ColumnROV}
+	if err := g.WriteAddressCSV(ctx, &buf, time.Time{}, cols, "one.one.one.one"); err != nil {
+		t.Fatalf("failed to write the address report: %v", err)
+	}
+	if !strings.Contains(buf.String(), "one.one.one.one,\n") {
+		t.Errorf("expected an empty validation state without ROAs: %s", buf.String())
+	}
+
+	buf.Reset()
+	_ = importDataset(t, g, g.ImportROAs, "testdata/rpki/rpki-client.json")
+	if err := g.WriteAddressCSV(ctx, &buf, time.Time{}, cols,
+		"one.one.one.one", "invalid.example.com", "unannounced.example.com"); err != nil {
+		t.Fatalf("failed to write the address report: %v", err)
+	}
+
+	for _, line := range []string{
+		"one.one.one.one,valid", "invalid.example.com,invalid", "unannounced.example.com,",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("the report does not contain %q: %s", line, buf.String())
+		}
+	}
+}
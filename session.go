@@ -0,0 +1,258 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/owasp-amass/asset-db/types"
+	"gorm.io/gorm"
+)
+
+// sessionTimeFormat keeps the fractional seconds, so sessions started within the same second are ordered.
+const sessionTimeFormat = "2006-01-02 15:04:05.000000"
+
+// Session is an enumeration run against the graph.
+type Session struct {
+	ID       string
+	Started  time.Time
+	Finished time.Time
+	Tags     []string
+}
+
+type sessionRow struct {
+	ID         string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// StartSession creates a new enumeration session with the provided tags. The upserts performed
+// with the context returned by WithSession are linked to the session.
+func (g *Graph) StartSession(ctx context.Context, tags ...string) (*Session, error) {
+	s := &Session{
+		ID:      uuid.New().String(),
+		Started: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	err := g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("INSERT INTO sessions (id, started_at) VALUES (?, ?)",
+			s.ID, s.Started.Format(sessionTimeFormat)).Error; err != nil {
+			return err
+		}
+		return insertSessionTags(tx, s.ID, tags...)
+	})
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	s.Tags = uniqueTags(tags)
+	return s, nil
+}
+
+type sessionKey struct{}
+
+// WithSession returns a copy of the context that causes the Upsert* methods to link the assets and
+// relations they write to the session. The data source and enumeration run set using WithProvenance are kept.
+func WithSession(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, id)
+}
+
+// SessionFromContext returns the identifier of the session carried by the context, or an empty string.
+func SessionFromContext(ctx context.Context) string {
+	if p := provenanceFrom(ctx); p != nil {
+		return p.session
+	}
+	return ""
+}
+
+// TagSession adds the tags to the session.
+func (g *Graph) TagSession(ctx context.Context, id string, tags ...string) error {
+	if _, err := g.ReadSession(ctx, id); err != nil {
+		return err
+	}
+	return backendError(insertSessionTags(g.db.WithContext(ctx), id, tags...))
+}
+
+// FinishSession records the time the session ended. Finishing a session more than once keeps the original time.
+func (g *Graph) FinishSession(ctx context.Context, id string) error {
+	res := g.db.WithContext(ctx).Exec("UPDATE sessions SET finished_at = ? WHERE id = ? AND finished_at IS NULL",
+		time.Now().UTC().Format(sessionTimeFormat), id)
+	if res.Error != nil {
+		return backendError(res.Error)
+	} else if res.RowsAffected == 0 {
+		_, err := g.ReadSession(ctx, id)
+		return err
+	}
+	return nil
+}
+
+// ReadSession returns the session with the provided identifier.
+func (g *Graph) ReadSession(ctx context.Context, id string) (*Session, error) {
+	sessions, err := g.readSessions(ctx, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	} else if len(sessions) == 0 {
+		return nil, notFound("the session %s", id)
+	}
+	return sessions[0], nil
+}
+
+// ReadSessions returns the sessions in the order they were started. When tags are provided,
+// only the sessions with all of the tags are returned.
+func (g *Graph) ReadSessions(ctx context.Context, tags ...string) ([]*Session, error) {
+	sessions, err := g.readSessions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return filterSessions(sessions, tags), nil
+}
+
+// PreviousSession returns the latest session started before the session with the provided identifier.
+// When tags are provided, only the sessions with all of the tags are considered.
+func (g *Graph) PreviousSession(ctx context.Context, id string, tags ...string) (*Session, error) {
+	cur, err := g.ReadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := g.readSessions(ctx, "WHERE id <> ? AND started_at <= ?", id, cur.Started.Format(sessionTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	if sessions = filterSessions(sessions, tags); len(sessions) == 0 {
+		return nil, notFound("no session was started before %s", id)
+	}
+	return sessions[len(sessions)-1], nil
+}
+
+// AssetsDiscoveredInSession returns the assets linked to the session that were not seen before the session first
+// observed them, and were not linked to any of the sessions started earlier. The observations made during the
+// session with an earlier observation time are included.
+func (g *Graph) AssetsDiscoveredInSession(ctx context.Context, id string) ([]*types.Asset, error) {
+	s, err := g.ReadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	event := sqlEscape(s.ID)
+	return g.assetQuery(ctx, "assets WHERE assets.id IN (SELECT asset_id FROM asset_sources WHERE event = '"+
+		event+"') AND assets.id NOT IN (SELECT asset_id FROM asset_sources WHERE event IN "+
+		"(SELECT id FROM sessions WHERE started_at < '"+s.Started.Format(sessionTimeFormat)+"'))"+
+		" AND assets.created_at >= (SELECT MIN(created_at) FROM asset_sources WHERE asset_id = assets.id"+
+		" AND event = '"+event+"')")
+}
+
+// AssetsMissingFromSession returns the assets linked to the previous session that were not seen in the current session.
+func (g *Graph) AssetsMissingFromSession(ctx context.Context, previous, current string) ([]*types.Asset, error) {
+	for _, id := range []string{previous, current} {
+		if _, err := g.ReadSession(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return g.assetQuery(ctx, "assets WHERE assets.id IN (SELECT asset_id FROM asset_sources WHERE event = '"+
+		sqlEscape(previous)+"') AND assets.id NOT IN (SELECT asset_id FROM asset_sources WHERE event = '"+
+		sqlEscape(current)+"')")
+}
+
+func (g *Graph) readSessions(ctx context.Context, where string, args ...interface{}) ([]*Session, error) {
+	var rows []sessionRow
+	if err := g.db.WithContext(ctx).Raw("SELECT id, started_at, finished_at FROM sessions "+
+		where+" ORDER BY started_at, id", args...).Scan(&rows).Error; err != nil {
+		return nil, backendError(err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+
+	var tags []struct {
+		SessionID string
+		Tag       string
+	}
+	if err := g.db.WithContext(ctx).Raw("SELECT session_id, tag FROM session_tags WHERE session_id IN ? ORDER BY tag",
+		ids).Scan(&tags).Error; err != nil {
+		return nil, backendError(err)
+	}
+
+	byID := make(map[string]*Session, len(rows))
+	sessions := make([]*Session, 0, len(rows))
+	for _, row := range rows {
+		s := &Session{ID: row.ID, Started: row.StartedAt.UTC()}
+		if row.FinishedAt != nil {
+			s.Finished = row.FinishedAt.UTC()
+		}
+		byID[s.ID] = s
+		sessions = append(sessions, s)
+	}
+	for _, t := range tags {
+		if s, found := byID[t.SessionID]; found {
+			s.Tags = append(s.Tags, t.Tag)
+		}
+	}
+	return sessions, nil
+}
+
+func insertSessionTags(tx *gorm.DB, id string, tags ...string) error {
+	for _, tag := range uniqueTags(tags) {
+		if err := tx.Exec(`INSERT INTO session_tags (session_id, tag) VALUES (?, ?)
+			ON CONFLICT (session_id, tag) DO NOTHING`, id, tag).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uniqueTags(tags []string) []string {
+	set := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		if tag != "" {
+			set[tag] = struct{}{}
+		}
+	}
+
+	unique := make([]string, 0, len(set))
+	for tag := range set {
+		unique = append(unique, tag)
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+func filterSessions(sessions []*Session, tags []string) []*Session {
+	if len(tags) == 0 {
+		return sessions
+	}
+
+	var filtered []*Session
+	for _, s := range sessions {
+		if s.hasTags(tags) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+func (s *Session) hasTags(tags []string) bool {
+	set := make(map[string]struct{}, len(s.Tags))
+	for _, tag := range s.Tags {
+		set[tag] = struct{}{}
+	}
+
+	for _, tag := range tags {
+		if _, found := set[tag]; !found {
+			return false
+		}
+	}
+	return true
+}
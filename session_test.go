@@ -0,0 +1,124 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/open-asset-model/domain"
+)
+
+func fqdnNames(assets []*types.Asset) []string {
+	var names []string
+	for _, a := range assets {
+		if fqdn, ok := a.Asset.(*domain.FQDN); ok {
+			names = append(names, fqdn.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSessions(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	if _, err := g.UpsertFQDNAt(ctx, "old.owasp.org", time.Now().Add(-24*time.Hour)); err != nil {
+		t.Fatalf("failed to insert the FQDN: %v", err)
+	}
+
+	first, err := g.StartSession(ctx, "owasp.org", "weekly")
+	if err != nil {
+		t.Fatalf("failed to start the session: %v", err)
+	}
+	sctx := WithSession(WithProvenance(ctx, "dns", ""), first.ID)
+	for _, name := range []string{"www.owasp.org", "api.owasp.org"} {
+		if err := g.UpsertA(sctx, name, "104.16.0.1"); err != nil {
+			t.Fatalf("failed to insert the A record: %v", err)
+		}
+	}
+	if err := g.FinishSession(ctx, first.ID); err != nil {
+		t.Fatalf("failed to finish the session: %v", err)
+	}
+
+	second, err := g.StartSession(ctx, "owasp.org")
+	if err != nil {
+		t.Fatalf("failed to start the session: %v", err)
+	}
+	if err := g.TagSession(ctx, second.ID, "weekly", "weekly"); err != nil {
+		t.Fatalf("failed to tag the session: %v", err)
+	}
+	sctx = WithSession(ctx, second.ID)
+	for _, name := range []string{"www.owasp.org", "new.owasp.org", "old.owasp.org"} {
+		if _, err := g.UpsertFQDN(sctx, name); err != nil {
+			t.Fatalf("failed to insert the FQDN: %v", err)
+		}
+	}
+	// Observations made during the session can be backdated, such as those read from a passive capture
+	if _, err := g.UpsertFQDNAt(WithSession(WithProvenance(ctx, "pcap", "run-7"), second.ID),
+		"backdated.owasp.org", time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatalf("failed to insert the FQDN: %v", err)
+	}
+	if names, err := g.Subdomains(ctx, "owasp.org", time.Time{}, "pcap"); err != nil ||
+		len(names) != 1 || names[0] != "backdated.owasp.org" {
+		t.Errorf("the session did not keep the data source of the observation: %v: %v", names, err)
+	}
+
+	pctx := WithSession(WithProvenance(ctx, "dns", "run-7"), first.ID)
+	if p := ProvenanceFromContext(pctx); p == nil || p.Source != "dns" || p.Event != "run-7" {
+		t.Errorf("the session did not keep the data source and enumeration run: %+v", p)
+	}
+	if id := SessionFromContext(pctx); id != first.ID {
+		t.Errorf("expected the session %s in the context, got %s", first.ID, id)
+	}
+	if p := ProvenanceFromContext(WithSession(ctx, first.ID)); p != nil {
+		t.Errorf("returned a provenance for a context only carrying a session: %+v", p)
+	}
+
+	s, err := g.ReadSession(ctx, first.ID)
+	if err != nil || s.Finished.IsZero() || s.Finished.Before(s.Started) || len(s.Tags) != 2 {
+		t.Errorf("unexpected session: %+v: %v", s, err)
+	}
+	if s, err := g.ReadSession(ctx, second.ID); err != nil || !s.Finished.IsZero() || len(s.Tags) != 2 {
+		t.Errorf("unexpected session: %+v: %v", s, err)
+	}
+	if sessions, err := g.ReadSessions(ctx, "weekly"); err != nil || len(sessions) != 2 || sessions[0].ID != first.ID {
+		t.Errorf("failed to read the sessions in the order they were started: %v", err)
+	}
+	if _, err := g.ReadSession(ctx, "unknown"); err == nil {
+		t.Error("did not return an error for an unknown session")
+	}
+	if err := g.FinishSession(ctx, "unknown"); err == nil {
+		t.Error("did not return an error when finishing an unknown session")
+	}
+
+	prev, err := g.PreviousSession(ctx, second.ID, "owasp.org")
+	if err != nil || prev.ID != first.ID {
+		t.Fatalf("failed to obtain the previous session: %v", err)
+	}
+	if _, err := g.PreviousSession(ctx, first.ID); err == nil {
+		t.Error("returned a previous session for the first session")
+	}
+
+	assets, err := g.AssetsDiscoveredInSession(ctx, first.ID)
+	// owasp.org was already in the graph since old.owasp.org was inserted before the session
+	if names := fqdnNames(assets); err != nil || len(names) != 2 || names[0] != "api.owasp.org" || names[1] != "www.owasp.org" {
+		t.Errorf("unexpected assets discovered in the first session: %v: %v", names, err)
+	}
+	assets, err = g.AssetsDiscoveredInSession(ctx, second.ID)
+	if names := fqdnNames(assets); err != nil || len(names) != 2 || names[0] != "backdated.owasp.org" || names[1] != "new.owasp.org" {
+		t.Errorf("unexpected assets discovered in the second session: %v: %v", names, err)
+	}
+
+	assets, err = g.AssetsMissingFromSession(ctx, prev.ID, second.ID)
+	if names := fqdnNames(assets); err != nil || len(names) != 1 || names[0] != "api.owasp.org" || len(assets) != 2 {
+		t.Errorf("unexpected assets missing from the second session: %v: %v", names, err)
+	}
+}
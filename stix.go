@@ -0,0 +1,234 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/open-asset-model/domain"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+// stixNamespace is the UUIDv5 namespace defined by STIX 2.1 for deterministic cyber-observable identifiers.
+var stixNamespace = uuid.MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7")
+
+const stixTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// STIXBundle is a STIX 2.1 bundle of cyber-observable and relationship objects.
+type STIXBundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []*STIXObject `json:"objects"`
+}
+
+// STIXObject is a STIX 2.1 cyber-observable or relationship object.
+// Only the properties relevant to the object type are populated.
+type STIXObject struct {
+	Type             string `json:"type"`
+	SpecVersion      string `json:"spec_version"`
+	ID               string `json:"id"`
+	Value            string `json:"value,omitempty"`
+	Number           int    `json:"number,omitempty"`
+	Name             string `json:"name,omitempty"`
+	Created          string `json:"created,omitempty"`
+	Modified         string `json:"modified,omitempty"`
+	RelationshipType string `json:"relationship_type,omitempty"`
+	SourceRef        string `json:"source_ref,omitempty"`
+	TargetRef        string `json:"target_ref,omitempty"`
+}
+
+// BuildSTIXBundle returns a STIX 2.1 bundle describing the subgraph reachable from the provided names.
+// The subgraph includes CNAME chains, resolved addresses, the netblocks containing them and the announcing
+// autonomous systems.
+func (g *Graph) BuildSTIXBundle(ctx context.Context, since time.Time, names ...string) (*STIXBundle, error) {
+	assets, rels, err := g.infrastructureSubgraph(ctx, since, names...)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &STIXBundle{
+		Type: "bundle",
+		ID:   "bundle--" + uuid.New().String(),
+	}
+
+	ids := make(map[string]string, len(assets))
+	for _, a := range assets {
+		if obj := g.stixObservable(ctx, a, since); obj != nil {
+			ids[a.ID] = obj.ID
+			bundle.Objects = append(bundle.Objects, obj)
+		}
+	}
+
+	for _, rel := range rels {
+		src, found := ids[rel.FromAsset.ID]
+		if !found {
+			continue
+		}
+		dst, found := ids[rel.ToAsset.ID]
+		if !found {
+			continue
+		}
+
+		rtype := "related-to"
+		switch rel.Type {
+		case "a_record", "aaaa_record", "cname_record":
+			rtype = "resolves-to"
+		}
+
+		bundle.Objects = append(bundle.Objects, &STIXObject{
+			Type:             "relationship",
+			SpecVersion:      "2.1",
+			ID:               "relationship--" + uuid.New().String(),
+			Created:          rel.CreatedAt.UTC().Format(stixTimeFormat),
+			Modified:         rel.LastSeen.UTC().Format(stixTimeFormat),
+			RelationshipType: rtype,
+			SourceRef:        src,
+			TargetRef:        dst,
+		})
+	}
+	return bundle, nil
+}
+
+// ExportSTIX writes the STIX 2.1 bundle produced by BuildSTIXBundle to w as JSON.
+func (g *Graph) ExportSTIX(ctx context.Context, w io.Writer, since time.Time, names ...string) error {
+	bundle, err := g.BuildSTIXBundle(ctx, since, names...)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bundle)
+}
+
+func (g *Graph) stixObservable(ctx context.Context, a *types.Asset, since time.Time) *STIXObject {
+	var obj *STIXObject
+
+	switch v := a.Asset.(type) {
+	case *domain.FQDN:
+		obj = &STIXObject{Type: "domain-name", Value: v.Name}
+	case *network.IPAddress:
+		obj = &STIXObject{Type: "ipv4-addr", Value: v.Address.String()}
+		if v.Address.Is6() && !v.Address.Is4In6() {
+			obj.Type = "ipv6-addr"
+		}
+	case *network.Netblock:
+		obj = &STIXObject{Type: "ipv4-addr", Value: v.Cidr.String()}
+		if v.Cidr.Addr().Is6() {
+			obj.Type = "ipv6-addr"
+		}
+	case *network.AutonomousSystem:
+		desc, _ := g.ReadASDescription(ctx, v.Number, since)
+		obj = &STIXObject{
+			Type:   "autonomous-system",
+			Number: v.Number,
+			Name:   desc,
+		}
+	default:
+		return nil
+	}
+
+	var contrib []byte
+	if obj.Type == "autonomous-system" {
+		contrib, _ = json.Marshal(struct {
+			Number int `json:"number"`
+		}{obj.Number})
+	} else {
+		contrib, _ = json.Marshal(struct {
+			Value string `json:"value"`
+		}{obj.Value})
+	}
+
+	obj.SpecVersion = "2.1"
+	obj.ID = obj.Type + "--" + uuid.NewSHA1(stixNamespace, contrib).String()
+	return obj
+}
+
+// infrastructureSubgraph walks the graph from the provided names through CNAME, A and AAAA records
+// to the addresses, then to the netblocks containing them and the autonomous systems announcing them. Netblocks
+// that are not announced are followed to their supernets until an announced netblock is found.
+func (g *Graph) infrastructureSubgraph(ctx context.Context, since time.Time, names ...string) ([]*types.Asset, []*types.Relation, error) {
+	var queue []*types.Asset
+	seen := make(map[string]struct{})
+
+	for _, name := range names {
+		assets, err := g.findByContent(ctx, &domain.FQDN{Name: name}, since)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, a := range assets {
+			if _, found := seen[a.ID]; !found {
+				seen[a.ID] = struct{}{}
+				queue = append(queue, a)
+			}
+		}
+	}
+	if len(queue) == 0 {
+		return nil, nil, notFound("none of the names")
+	}
+
+	var assets []*types.Asset
+	var rels []*types.Relation
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		cur := queue[0]
+		queue = queue[1:]
+		assets = append(assets, cur)
+
+		// The queries are tried in order until one of them returns relations
+		var queries []string
+		switch cur.Asset.(type) {
+		case *domain.FQDN:
+			queries = []string{"relations WHERE relations.from_asset_id = " + cur.ID +
+				" AND relations.type IN ('cname_record','a_record','aaaa_record')"}
+		case *network.IPAddress:
+			queries = []string{"relations WHERE relations.to_asset_id = " + cur.ID + " AND relations.type = 'contains'"}
+		case *network.Netblock:
+			// A netblock that is not announced is followed to the netblock containing it
+			queries = []string{
+				"relations WHERE relations.to_asset_id = " + cur.ID + " AND relations.type = 'announces'",
+				"relations INNER JOIN assets ON relations.from_asset_id = assets.id WHERE relations.to_asset_id = " +
+					cur.ID + " AND relations.type = 'contains' AND assets.type = 'Netblock'",
+			}
+		default:
+			continue
+		}
+
+		var relations []*types.Relation
+		for _, query := range queries {
+			var err error
+
+			relations, err = g.relationQuery(ctx, query+TimeRange{Since: since}.constraint("relations"))
+			if err != nil {
+				return nil, nil, err
+			} else if len(relations) > 0 {
+				break
+			}
+		}
+
+		for _, rel := range relations {
+			rels = append(rels, rel)
+
+			next := rel.ToAsset
+			if next.ID == cur.ID {
+				next = rel.FromAsset
+			}
+			if _, found := seen[next.ID]; !found {
+				seen[next.ID] = struct{}{}
+				queue = append(queue, next)
+			}
+		}
+	}
+	return assets, rels, nil
+}
@@ -0,0 +1,139 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+func TestSTIX(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertCNAME(ctx, "owasp.org", "www.owasp.org")
+	_ = g.UpsertA(ctx, "www.owasp.org", "192.168.1.1")
+	_ = g.UpsertAAAA(ctx, "www.owasp.org", "2001:db8::1")
+	_ = g.UpsertInfrastructure(ctx, 667, "Great AS", "192.168.1.1", "192.168.1.0/24")
+
+	schema, err := jsonschema.Compile("testdata/stix/common/bundle.json")
+	if err != nil {
+		t.Fatalf("failed to compile the STIX bundle schema: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := g.ExportSTIX(ctx, &buf, time.Time{}, "owasp.org"); err != nil {
+		t.Fatalf("failed to export the STIX bundle: %v", err)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to unmarshal the STIX bundle: %v", err)
+	}
+	if err := schema.Validate(doc); err != nil {
+		t.Errorf("the STIX bundle failed schema validation: %#v", err)
+	}
+
+	bad := bytes.Replace(buf.Bytes(), []byte(`"spec_version": "2.1"`), []byte(`"spec_version": "2.0.1"`), 1)
+	if err := json.Unmarshal(bad, &doc); err == nil && schema.Validate(doc) == nil {
+		t.Error("the schema did not reject an invalid STIX bundle")
+	}
+
+	var bundle STIXBundle
+	if err := json.Unmarshal(buf.Bytes(), &bundle); err != nil {
+		t.Fatalf("failed to unmarshal the STIX bundle: %v", err)
+	}
+
+	counts := make(map[string]int)
+	rtypes := make(map[string]int)
+	for _, obj := range bundle.Objects {
+		counts[obj.Type]++
+		if obj.Type == "relationship" {
+			rtypes[obj.RelationshipType]++
+		}
+		if obj.Type == "autonomous-system" && (obj.Number != 667 || obj.Name != "Great AS") {
+			t.Errorf("the autonomous system was not exported properly: %+v", obj)
+		}
+	}
+
+	want := map[string]int{
+		"domain-name":       2,
+		"ipv4-addr":         2,
+		"ipv6-addr":         1,
+		"autonomous-system": 1,
+		"relationship":      5,
+	}
+	for k, v := range want {
+		if counts[k] != v {
+			t.Errorf("expected %d %s objects, got %d", v, k, counts[k])
+		}
+	}
+	if rtypes["resolves-to"] != 3 || rtypes["related-to"] != 2 {
+		t.Errorf("unexpected relationship types: %v", rtypes)
+	}
+
+	again, err := g.BuildSTIXBundle(ctx, time.Time{}, "owasp.org")
+	if err != nil {
+		t.Fatalf("failed to build the STIX bundle: %v", err)
+	}
+	ids := make(map[string]struct{})
+	for _, obj := range bundle.Objects {
+		ids[obj.ID] = struct{}{}
+	}
+	for _, obj := range again.Objects {
+		if _, found := ids[obj.ID]; obj.Type != "relationship" && !found {
+			t.Errorf("the identifier for %s was not deterministic", obj.Value)
+		}
+	}
+
+	if _, err := g.BuildSTIXBundle(ctx, time.Time{}, "doesnot.exist"); err == nil {
+		t.Error("did not return an error when provided a name not existing in the graph")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := g.BuildSTIXBundle(cancelled, time.Time{}, "owasp.org"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation instead of a partial bundle: %v", err)
+	}
+}
+
+func TestSTIXNestedNetblock(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertA(ctx, "www.example.com", "10.0.0.1")
+	_ = g.UpsertInfrastructure(ctx, 64500, "EXAMPLE", "10.0.0.1", "10.0.0.0/16")
+	_, _ = g.UpsertNetblock(ctx, "10.0.0.0/24")
+
+	bundle, err := g.BuildSTIXBundle(ctx, time.Time{}, "www.example.com")
+	if err != nil {
+		t.Fatalf("failed to build the STIX bundle: %v", err)
+	}
+
+	values := make(map[string]struct{})
+	var asn int
+	for _, obj := range bundle.Objects {
+		if obj.Type == "autonomous-system" {
+			asn = obj.Number
+		}
+		values[obj.Value] = struct{}{}
+	}
+	if asn != 64500 {
+		t.Errorf("expected the autonomous system announcing the supernet, got %d", asn)
+	}
+	for _, v := range []string{"10.0.0.0/24", "10.0.0.0/16"} {
+		if _, found := values[v]; !found {
+			t.Errorf("the %s netblock was missing from the bundle", v)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+)
+
+// TimeRange limits a query to the assets and relations seen during the period. An asset or relation is
+// within the range when it was last seen after Since and first seen no later than Until, so the graph can
+// be reconstructed as it looked on a past date. A zero Since or Until leaves that end of the range open.
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// Validate returns an error when the range ends before it starts.
+func (tr TimeRange) Validate() error {
+	if !tr.Since.IsZero() && !tr.Until.IsZero() && tr.Until.Before(tr.Since) {
+		return invalidInput("the time range ends at %v before it starts at %v", tr.Until, tr.Since)
+	}
+	return nil
+}
+
+// Includes returns true when the first seen / last seen range overlaps the time range.
+func (tr TimeRange) Includes(first, last time.Time) bool {
+	if !tr.Since.IsZero() && !last.After(tr.Since) {
+		return false
+	}
+	if !tr.Until.IsZero() && first.After(tr.Until) {
+		return false
+	}
+	return true
+}
+
+// includesAsset returns true when the asset was seen during the time range.
+func (tr TimeRange) includesAsset(a *types.Asset) bool {
+	return tr.Includes(a.CreatedAt, a.LastSeen)
+}
+
+// constraint returns the SQL that limits the table referenced by alias to the rows seen during the time range.
+func (tr TimeRange) constraint(alias string) string {
+	var c string
+
+	if !tr.Since.IsZero() {
+		c += " AND " + alias + ".last_seen > '" + tr.Since.UTC().Format(sqlTimeFormat) + "'"
+	}
+	if !tr.Until.IsZero() {
+		c += " AND " + alias + ".created_at <= '" + tr.Until.UTC().Format(sqlTimeFormat) + "'"
+	}
+	return c
+}
@@ -0,0 +1,86 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func pairAddrs(pairs []*NameAddrPair) []string {
+	var addrs []string
+	for _, p := range pairs {
+		addrs = append(addrs, p.Addr.Address.String())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestTimeRange(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	t1 := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	t3 := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	_ = g.UpsertCNAMEAt(ctx, "www.owasp.org", "a.owasp.org", t1)
+	_ = g.UpsertAAt(ctx, "a.owasp.org", "192.0.2.1", t1)
+	_ = g.UpsertAAt(ctx, "a.owasp.org", "192.0.2.1", t3)
+	_ = g.UpsertCNAMEAt(ctx, "www.owasp.org", "b.owasp.org", t3)
+	_ = g.UpsertAAt(ctx, "b.owasp.org", "192.0.2.2", t3)
+	_, _ = g.UpsertASAt(ctx, 667, "Old AS", t1)
+	_, _ = g.UpsertASAt(ctx, 667, "New AS", t3)
+	_ = g.UpsertInfrastructureAt(ctx, 667, "New AS", "192.0.2.2", "192.0.2.0/24", t3)
+
+	tests := []struct {
+		tr    TimeRange
+		addrs []string
+	}{
+		{TimeRange{}, []string{"192.0.2.1", "192.0.2.2"}},
+		{TimeRange{Until: t2}, []string{"192.0.2.1"}},
+		{TimeRange{Since: t2}, []string{"192.0.2.2"}},
+		{TimeRange{Since: t1.Add(-time.Hour), Until: t1}, []string{"192.0.2.1"}},
+	}
+	for _, test := range tests {
+		pairs, err := g.NamesToAddrsInRange(ctx, test.tr, "www.owasp.org")
+		if got := pairAddrs(pairs); err != nil || len(got) != len(test.addrs) || got[0] != test.addrs[0] {
+			t.Errorf("expected %v for the range %+v, got %v: %v", test.addrs, test.tr, got, err)
+		}
+	}
+	if _, err := g.NamesToAddrsInRange(ctx, TimeRange{Until: t1.Add(-time.Hour)}, "www.owasp.org"); err == nil {
+		t.Error("returned pairs for a range before the names were seen")
+	}
+	if _, err := g.NamesToAddrsInRange(ctx, TimeRange{Since: t3, Until: t1}, "www.owasp.org"); err == nil {
+		t.Error("did not return an error for a range ending before it starts")
+	}
+
+	if !g.IsCNAMENodeInRange(ctx, "www.owasp.org", TimeRange{Until: t2}) {
+		t.Error("the CNAME record was not found before the until time")
+	}
+	if g.IsCNAMENodeInRange(ctx, "www.owasp.org", TimeRange{Until: t1.Add(-time.Hour)}) {
+		t.Error("the CNAME record was found before it was first seen")
+	}
+
+	if desc, _ := g.ReadASDescriptionInRange(ctx, 667, TimeRange{Until: t2}); desc != "Old AS" {
+		t.Errorf("expected the description Old AS before the until time, got %s", desc)
+	}
+	if desc, _ := g.ReadASDescription(ctx, 667, time.Time{}); desc != "New AS" {
+		t.Errorf("expected the most recent description New AS, got %s", desc)
+	}
+	if prefixes, _ := g.ReadASPrefixesInRange(ctx, 667, TimeRange{Until: t2}); len(prefixes) != 0 {
+		t.Errorf("expected no prefixes before the until time, got %v", prefixes)
+	}
+	if prefixes, _ := g.ReadASPrefixesInRange(ctx, 667, TimeRange{Since: t2}); len(prefixes) != 1 || prefixes[0] != "192.0.2.0/24" {
+		t.Errorf("expected the prefix 192.0.2.0/24 after the since time, got %v", prefixes)
+	}
+
+	if names, err := g.SubdomainsInRange(ctx, "owasp.org", TimeRange{Until: t2}); err != nil || len(names) != 2 {
+		t.Errorf("expected two subdomains before the until time, got %v: %v", names, err)
+	}
+}
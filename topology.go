@@ -0,0 +1,375 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owasp-amass/asset-db/types"
+	"github.com/owasp-amass/open-asset-model/network"
+)
+
+// ASRelationship describes the business relationship of a neighbouring autonomous system.
+type ASRelationship string
+
+// The relationships between autonomous systems. A provider sells transit to its customers, while peers exchange
+// the traffic of their customers. Adjacent autonomous systems were found next to each other in AS paths without
+// knowing their relationship. The graph stores peers_with edges between peers, provider_of edges from a provider
+// to its customers and adjacent_to edges between adjacent autonomous systems.
+const (
+	ASPeer     ASRelationship = "peer"
+	ASProvider ASRelationship = "provider"
+	ASCustomer ASRelationship = "customer"
+	ASAdjacent ASRelationship = "adjacent"
+)
+
+// ASNeighbor is an autonomous system connected to another and its relationship to it.
+type ASNeighbor struct {
+	ASN          int
+	Relationship ASRelationship
+}
+
+// PrefixUpstreams is a netblock of an organization and the transit providers its traffic depends on.
+type PrefixUpstreams struct {
+	Netblock string
+	// Origins are the autonomous systems of the organization announcing the netblock.
+	Origins []int
+	// Providers are the closest providers of the origins that are not managed by the organization.
+	Providers []int
+}
+
+// SinglePointOfFailure returns true when the netblock depends on one transit provider.
+func (p *PrefixUpstreams) SinglePointOfFailure() bool {
+	return len(p.Providers) == 1
+}
+
+// UpsertASRelationship adds the relationship of the neighbor to the autonomous system to the graph.
+// For example, ASProvider means the neighbor is a transit provider of the autonomous system.
+func (g *Graph) UpsertASRelationship(ctx context.Context, asn, neighbor int, rel ASRelationship) error {
+	return g.UpsertASRelationshipAt(ctx, asn, neighbor, rel, time.Time{})
+}
+
+// UpsertASRelationshipAt adds the relationship of the neighbor to the autonomous system, observed at the provided
+// time, to the graph.
+func (g *Graph) UpsertASRelationshipAt(ctx context.Context, asn, neighbor int, rel ASRelationship, at time.Time) error {
+	if asn == neighbor {
+		return invalidInput("AS%d cannot be its own %s", asn, rel)
+	}
+
+	from, to := asn, neighbor
+	var relation string
+	switch rel {
+	case ASPeer:
+		relation = "peers_with"
+	case ASProvider:
+		relation = "provider_of"
+		from, to = neighbor, asn
+	case ASCustomer:
+		relation = "provider_of"
+	case ASAdjacent:
+		relation = "adjacent_to"
+	default:
+		return invalidInput("the %s AS relationship is not supported", rel)
+	}
+
+	a, err := g.upsertAssetAt(ctx, &network.AutonomousSystem{Number: from}, at)
+	if err != nil {
+		return err
+	}
+	b, err := g.upsertAssetAt(ctx, &network.AutonomousSystem{Number: to}, at)
+	if err != nil {
+		return err
+	}
+	return g.linkAt(ctx, a, relation, b, at)
+}
+
+// ImportASRelationships reads a CAIDA AS relationship dataset (as-rel or as-rel2) and upserts the peering and
+// transit relationships between the autonomous systems. Malformed lines are skipped and listed in the returned report.
+func (g *Graph) ImportASRelationships(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	report := new(ImportReport)
+	imp := g.newDatasetImporter(ctx, report, time.Time{})
+
+	err := scanLines(ctx, r, func(n int, line []byte) {
+		text := strings.TrimSpace(string(line))
+		if strings.HasPrefix(text, "#") {
+			return
+		}
+		report.Lines++
+
+		fields := strings.Split(text, "|")
+		if len(fields) < 3 {
+			report.skip(n, fmt.Errorf("the line has %d fields instead of at least 3", len(fields)))
+			return
+		}
+
+		a, err := strconv.Atoi(fields[0])
+		if err != nil {
+			report.skip(n, err)
+			return
+		}
+		b, err := strconv.Atoi(fields[1])
+		if err != nil {
+			report.skip(n, err)
+			return
+		}
+
+		// The first AS is the provider of the second (-1) or its peer (0)
+		switch fields[2] {
+		case "-1":
+			imp.add(&datasetEntry{asns: []int{a}, adjacent: []int{b}, relation: "provider_of"})
+		case "0":
+			imp.add(&datasetEntry{asns: []int{a}, adjacent: []int{b}, relation: "peers_with"})
+		default:
+			report.skip(n, fmt.Errorf("the %s relationship is not supported", fields[2]))
+		}
+	})
+	return report, imp.close(err)
+}
+
+// ReadASNeighbors returns the autonomous systems connected to the autonomous system and their relationships to it.
+func (g *Graph) ReadASNeighbors(ctx context.Context, asn int, since time.Time) ([]*ASNeighbor, error) {
+	return g.ReadASNeighborsInRange(ctx, asn, TimeRange{Since: since})
+}
+
+// ReadASNeighborsInRange returns the autonomous systems connected to the autonomous system during the time range.
+// When the relationship with a neighbor changed, the most recently seen relationship is returned, and a known
+// relationship is preferred to an adjacency.
+func (g *Graph) ReadASNeighborsInRange(ctx context.Context, asn int, tr TimeRange) ([]*ASNeighbor, error) {
+	as, err := g.findAS(ctx, asn, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	rels, err := g.relationQuery(ctx, "relations WHERE (relations.from_asset_id = "+as.ID+
+		" OR relations.to_asset_id = "+as.ID+") AND relations.type IN ('peers_with', 'provider_of', 'adjacent_to')"+
+		tr.constraint("relations")+" ORDER BY relations.last_seen DESC, relations.id DESC")
+	if err != nil {
+		return nil, err
+	}
+
+	byASN := make(map[int]*ASNeighbor)
+	for _, rel := range rels {
+		outgoing := rel.FromAsset.ID == as.ID
+		other := rel.ToAsset
+		if !outgoing {
+			other = rel.FromAsset
+		}
+		if !tr.includesAsset(other) {
+			continue
+		}
+		neighbor, ok := other.Asset.(*network.AutonomousSystem)
+		if !ok || neighbor.Number == asn {
+			continue
+		}
+
+		var r ASRelationship
+		switch {
+		case rel.Type == "peers_with":
+			r = ASPeer
+		case rel.Type == "provider_of" && outgoing:
+			r = ASCustomer
+		case rel.Type == "provider_of":
+			r = ASProvider
+		default:
+			r = ASAdjacent
+		}
+
+		if cur, found := byASN[neighbor.Number]; !found || (cur.Relationship == ASAdjacent && r != ASAdjacent) {
+			byASN[neighbor.Number] = &ASNeighbor{ASN: neighbor.Number, Relationship: r}
+		}
+	}
+
+	neighbors := make([]*ASNeighbor, 0, len(byASN))
+	for _, n := range byASN {
+		neighbors = append(neighbors, n)
+	}
+	sort.Slice(neighbors, func(i, j int) bool { return neighbors[i].ASN < neighbors[j].ASN })
+	return neighbors, nil
+}
+
+// ReadCustomerCone returns the autonomous systems in the customer cone of the autonomous system, which are the
+// autonomous system itself and those reachable by following provider to customer relationships.
+func (g *Graph) ReadCustomerCone(ctx context.Context, asn int, since time.Time) ([]int, error) {
+	return g.ReadCustomerConeInRange(ctx, asn, TimeRange{Since: since})
+}
+
+// ReadCustomerConeInRange returns the customer cone of the autonomous system using the relationships seen
+// during the time range.
+func (g *Graph) ReadCustomerConeInRange(ctx context.Context, asn int, tr TimeRange) ([]int, error) {
+	as, err := g.findAS(ctx, asn, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	cone := map[string]int{as.ID: asn}
+	for frontier := []string{as.ID}; len(frontier) > 0; {
+		customers, err := g.transitNeighbors(ctx, tr, frontier, false)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = nil
+		for _, c := range customers {
+			if _, found := cone[c.ID]; !found {
+				cone[c.ID] = c.Asset.(*network.AutonomousSystem).Number
+				frontier = append(frontier, c.ID)
+			}
+		}
+	}
+
+	asns := make([]int, 0, len(cone))
+	for _, n := range cone {
+		asns = append(asns, n)
+	}
+	sort.Ints(asns)
+	return asns, nil
+}
+
+// ReadUpstreamProviders returns the netblocks announced by the autonomous systems managed by the organization,
+// along with the transit providers they depend on. The organization is the RIROrganization managing the
+// autonomous systems, such as the AS description provided to UpsertAS.
+func (g *Graph) ReadUpstreamProviders(ctx context.Context, org string, since time.Time) ([]*PrefixUpstreams, error) {
+	return g.ReadUpstreamProvidersInRange(ctx, org, TimeRange{Since: since})
+}
+
+// ReadUpstreamProvidersInRange returns the netblocks announced by the autonomous systems managed by the organization
+// and the transit providers they depend on, using the assets and relations seen during the time range.
+func (g *Graph) ReadUpstreamProvidersInRange(ctx context.Context, org string, tr TimeRange) ([]*PrefixUpstreams, error) {
+	if err := tr.Validate(); err != nil {
+		return nil, err
+	}
+
+	rels, err := g.relationQuery(ctx, "relations INNER JOIN assets ON relations.to_asset_id = assets.id"+
+		" WHERE relations.type = 'managed_by' AND assets.type = 'RIROrg' AND assets.content->>'name' = '"+
+		sqlEscape(org)+"'"+tr.constraint("relations")+tr.constraint("assets"))
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]*types.Asset)
+	for _, rel := range rels {
+		if _, ok := rel.FromAsset.Asset.(*network.AutonomousSystem); ok && tr.includesAsset(rel.FromAsset) {
+			owned[rel.FromAsset.ID] = rel.FromAsset
+		}
+	}
+	if len(owned) == 0 {
+		return nil, notFound("the autonomous systems managed by %s", org)
+	}
+
+	byNetblock := make(map[string]*PrefixUpstreams)
+	providers := make(map[string][]int)
+	for id, as := range owned {
+		asn := as.Asset.(*network.AutonomousSystem).Number
+
+		ann, err := g.relationQuery(ctx, "relations INNER JOIN assets ON relations.to_asset_id = assets.id"+
+			" WHERE relations.from_asset_id = "+id+" AND relations.type = 'announces'"+
+			tr.constraint("relations")+tr.constraint("assets"))
+		if err != nil {
+			return nil, err
+		}
+		if len(ann) == 0 {
+			continue
+		}
+
+		upstreams, err := g.upstreams(ctx, tr, id, owned)
+		if err != nil {
+			return nil, err
+		}
+		providers[id] = upstreams
+
+		for _, rel := range ann {
+			nb, ok := rel.ToAsset.Asset.(*network.Netblock)
+			if !ok {
+				continue
+			}
+
+			cidr := nb.Cidr.String()
+			p, found := byNetblock[cidr]
+			if !found {
+				p = &PrefixUpstreams{Netblock: cidr}
+				byNetblock[cidr] = p
+			}
+			p.Origins = append(p.Origins, asn)
+			p.Providers = append(p.Providers, upstreams...)
+		}
+	}
+
+	results := make([]*PrefixUpstreams, 0, len(byNetblock))
+	for _, p := range byNetblock {
+		p.Origins = uniqueInts(p.Origins)
+		p.Providers = uniqueInts(p.Providers)
+		results = append(results, p)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Netblock < results[j].Netblock })
+	return results, nil
+}
+
+// upstreams returns the closest transit providers of the autonomous system that are not among the owned
+// autonomous systems. The providers of an owned provider are followed, since its traffic depends on them.
+func (g *Graph) upstreams(ctx context.Context, tr TimeRange, id string, owned map[string]*types.Asset) ([]int, error) {
+	var results []int
+	seen := map[string]struct{}{id: {}}
+
+	for frontier := []string{id}; len(frontier) > 0; {
+		providers, err := g.transitNeighbors(ctx, tr, frontier, true)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = nil
+		for _, p := range providers {
+			if _, found := seen[p.ID]; found {
+				continue
+			}
+			seen[p.ID] = struct{}{}
+
+			if _, found := owned[p.ID]; found {
+				frontier = append(frontier, p.ID)
+			} else {
+				results = append(results, p.Asset.(*network.AutonomousSystem).Number)
+			}
+		}
+	}
+	return uniqueInts(results), nil
+}
+
+// transitNeighbors returns the providers, or the customers, of the autonomous systems seen during the time range.
+func (g *Graph) transitNeighbors(ctx context.Context, tr TimeRange, ids []string, providers bool) ([]*types.Asset, error) {
+	join, match := "relations.to_asset_id", "relations.from_asset_id"
+	if providers {
+		join, match = match, join
+	}
+
+	rels, err := g.relationQuery(ctx, "relations INNER JOIN assets ON "+join+" = assets.id"+
+		" WHERE relations.type = 'provider_of' AND assets.type = 'ASN' AND "+match+" IN ("+strings.Join(ids, ", ")+")"+
+		tr.constraint("relations")+tr.constraint("assets"))
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*types.Asset
+	for _, rel := range rels {
+		if providers {
+			results = append(results, rel.FromAsset)
+		} else {
+			results = append(results, rel.ToAsset)
+		}
+	}
+	return results, nil
+}
+
+func uniqueInts(values []int) []int {
+	set := make(map[int]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return sortedASNs(set)
+}
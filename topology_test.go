@@ -0,0 +1,115 @@
+// Copyright © by Jeff Foley 2017-2023. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package netmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestASTopology(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	ctx := context.Background()
+	_ = g.UpsertInfrastructure(ctx, 64500, "EXAMPLE-CUSTOMER", "192.0.2.1", "192.0.2.0/24")
+	_ = g.UpsertInfrastructure(ctx, 64501, "EXAMPLE-CUSTOMER", "198.51.100.1", "198.51.100.0/24")
+	_ = g.UpsertInfrastructure(ctx, 64502, "EXAMPLE-CUSTOMER", "203.0.113.1", "203.0.113.0/24")
+
+	for _, r := range []struct {
+		asn, neighbor int
+		rel           ASRelationship
+	}{
+		{64500, 64510, ASProvider},
+		{64500, 64511, ASProvider},
+		{64500, 64501, ASCustomer},
+		{64510, 64502, ASCustomer},
+		{64510, 64511, ASPeer},
+		{64510, 64520, ASAdjacent},
+		{64510, 64500, ASAdjacent},
+	} {
+		if err := g.UpsertASRelationship(ctx, r.asn, r.neighbor, r.rel); err != nil {
+			t.Fatalf("failed to upsert the relationship: %v", err)
+		}
+	}
+	if err := g.UpsertASRelationship(ctx, 64500, 64500, ASPeer); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a relationship with itself: %v", err)
+	}
+
+	neighbors, err := g.ReadASNeighbors(ctx, 64510, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, n := range neighbors {
+		got = append(got, fmt.Sprintf("%d:%s", n.ASN, n.Relationship))
+	}
+	if want := "64500:customer 64502:customer 64511:peer 64520:adjacent"; strings.Join(got, " ") != want {
+		t.Errorf("unexpected neighbors: %v", got)
+	}
+
+	for asn, want := range map[int]string{
+		64510: "[64500 64501 64502 64510]",
+		64511: "[64500 64501 64511]",
+		64501: "[64501]",
+	} {
+		if cone, err := g.ReadCustomerCone(ctx, asn, time.Time{}); err != nil || fmt.Sprint(cone) != want {
+			t.Errorf("unexpected customer cone of AS%d: %v, %v", asn, cone, err)
+		}
+	}
+
+	upstreams, err := g.ReadUpstreamProviders(ctx, "EXAMPLE-CUSTOMER", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, p := range upstreams {
+		got = append(got, fmt.Sprintf("%s %v %v %t", p.Netblock, p.Origins, p.Providers, p.SinglePointOfFailure()))
+	}
+	want := []string{
+		"192.0.2.0/24 [64500] [64510 64511] false",
+		"198.51.100.0/24 [64501] [64510 64511] false",
+		"203.0.113.0/24 [64502] [64510] true",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected upstream providers:\n%s", strings.Join(got, "\n"))
+	}
+	if _, err := g.ReadUpstreamProviders(ctx, "MISSING-ORG", time.Time{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown organization: %v", err)
+	}
+}
+
+func TestImportASRelationships(t *testing.T) {
+	g := NewGraph("memory", "", "")
+	defer g.Remove()
+
+	f, err := os.Open("testdata/datasets/as-rel2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	report, err := g.ImportASRelationships(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Lines != 7 || report.Records != 4 || report.Duplicates != 1 || len(report.Skipped) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	if cone, err := g.ReadCustomerCone(ctx, 64510, time.Time{}); err != nil || fmt.Sprint(cone) != "[64500 64501 64510]" {
+		t.Errorf("unexpected customer cone: %v, %v", cone, err)
+	}
+	if neighbors, err := g.ReadASNeighbors(ctx, 64511, time.Time{}); err != nil || len(neighbors) != 2 ||
+		neighbors[0].Relationship != ASCustomer || neighbors[1].Relationship != ASPeer {
+		t.Errorf("unexpected neighbors: %v, %v", neighbors, err)
+	}
+}